@@ -0,0 +1,96 @@
+package alert
+
+import (
+	"context"
+	"sync"
+	"time"
+	"ui-backend-for-omotebako-site-controller/pkg"
+)
+
+var sugar = pkg.NewSugaredLogger()
+
+// Kind 異常の種類
+type Kind string
+
+const (
+	// KindMountUnavailable 監視するディレクトリ（共有フォルダ）にアクセスできない
+	KindMountUnavailable Kind = "MOUNT_UNAVAILABLE"
+	// KindArrivalOverdue expected_arrivalsの時間内に新しいファイルが届かない
+	KindArrivalOverdue Kind = "ARRIVAL_OVERDUE"
+	// KindImportQuarantined ファイル全体の取込に繰り返し失敗したため、ジョブを隔離した
+	KindImportQuarantined Kind = "IMPORT_QUARANTINED"
+)
+
+const (
+	// eventLimit 保持する直近のイベントの数
+	eventLimit = 100
+	// notifyTimeout 通知先1つへの通知を待つ時間
+	notifyTimeout = 10 * time.Second
+)
+
+// Event 監視元の異常の発生・解消
+type Event struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Kind    Kind      `json:"kind"`
+	Message string    `json:"message"`
+	// Resolved 異常が解消した
+	Resolved bool `json:"resolved"`
+}
+
+// Notifier アラートの通知先
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Alerts 監視の異常のイベントをログに出して通知先に送り、直近のイベントを保持する
+type Alerts struct {
+	notifiers []Notifier
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func NewAlerts(notifiers ...Notifier) *Alerts {
+	return &Alerts{notifiers: notifiers}
+}
+
+func (a *Alerts) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Resolved {
+		sugar.Infof("[%s] alert resolved: %s: %s", event.Source, event.Kind, event.Message)
+	} else {
+		sugar.Errorf("[%s] alert: %s: %s", event.Source, event.Kind, event.Message)
+	}
+
+	a.mu.Lock()
+	a.events = append(a.events, event)
+	if len(a.events) > eventLimit {
+		a.events = a.events[len(a.events)-eventLimit:]
+	}
+	a.mu.Unlock()
+
+	// 通知先の応答を監視で待たない
+	for _, notifier := range a.notifiers {
+		go func(notifier Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, event); err != nil {
+				sugar.Errorf("[%s] failed to notify alert %s: %v", event.Source, event.Kind, err)
+			}
+		}(notifier)
+	}
+}
+
+// Events 直近のイベントを新しい順に返す
+func (a *Alerts) Events() []Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	events := make([]Event, 0, len(a.events))
+	for i := len(a.events) - 1; i >= 0; i-- {
+		events = append(events, a.events[i])
+	}
+	return events
+}
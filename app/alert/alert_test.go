@@ -0,0 +1,53 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlerts(t *testing.T) {
+	received := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("%v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	alerts := NewAlerts(NewWebhook(server.URL))
+	alerts.Publish(Event{Source: "lincoln", Kind: KindArrivalOverdue, Message: "no file"})
+
+	select {
+	case payload := <-received:
+		if payload.Source != "lincoln" || payload.Kind != KindArrivalOverdue || payload.Text != "[異常] lincoln: ARRIVAL_OVERDUE: no file" {
+			t.Errorf("unexpected payload: %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook is not called")
+	}
+
+	for i := 0; i < eventLimit+1; i++ {
+		alerts.Publish(Event{Source: "lincoln", Kind: KindMountUnavailable, Resolved: true})
+		<-received
+	}
+	events := alerts.Events()
+	if len(events) != eventLimit || events[0].Kind != KindMountUnavailable || events[0].Time.IsZero() {
+		t.Errorf("want %d events, newest first, got %d: %+v", eventLimit, len(events), events[0])
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	if err := NewWebhook(server.URL).Notify(context.Background(), Event{Source: "lincoln"}); err == nil {
+		t.Errorf("want error")
+	}
+}
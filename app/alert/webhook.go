@@ -0,0 +1,55 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/xerrors"
+)
+
+// Webhook アラートをJSONでPOSTする。textはSlack等の受信Webhookでそのまま表示できる
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+type webhookPayload struct {
+	Event
+	Text string `json:"text"`
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{}}
+}
+
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(webhookPayload{Event: event, Text: Text(event)})
+	if err != nil {
+		return xerrors.Errorf("failed to marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to post webhook: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return xerrors.Errorf("webhook returned %s", res.Status)
+	}
+	return nil
+}
+
+// Text 通知に表示する文
+func Text(event Event) string {
+	if event.Resolved {
+		return fmt.Sprintf("[解消] %s: %s: %s", event.Source, event.Kind, event.Message)
+	}
+	return fmt.Sprintf("[異常] %s: %s: %s", event.Source, event.Kind, event.Message)
+}
@@ -0,0 +1,177 @@
+package fileController
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/xerrors"
+)
+
+// errorsCSVSuffix 取込に失敗したファイルの横に置くエラー一覧の接尾辞
+const errorsCSVSuffix = ".errors.csv"
+
+// Archive 取込後のファイルを、archive_modeに従って日付ごとのprocessed/かfailed/に移動（コピー）し、移動先を返す。
+// 取込に失敗したファイルは、行ごとのエラーをCP932の.errors.csvに書き出す（Windowsのエクスプローラーから確認できるように）。
+// 検証のみ（import_mode: validate）の監視元は予約を登録していないため、moveでもコピーし、ファイルを共有フォルダに残す
+func Archive(source *config.WatchSource, f *file.File, result *database.ImportResult, importErr error, now time.Time) (string, error) {
+	mode := source.ArchiveMode
+	if mode == config.ArchiveModeNone {
+		return "", nil
+	}
+	if source.ImportMode == config.ImportModeValidate && mode == config.ArchiveModeMove {
+		mode = config.ArchiveModeCopy
+	}
+	failed := result == nil || result.Failed
+	dir := config.ArchiveProcessedDir
+	if failed {
+		dir = config.ArchiveFailedDir
+	}
+	// SFTPから別名でダウンロードしたファイルはNameがPathのファイル名と異なる
+	rel := filepath.Join(filepath.Dir(filepath.FromSlash(f.Path)), f.Name)
+	src := filepath.Join(source.LocalPath(), rel)
+	dest := filepath.Join(source.ArchivePath, dir, now.Format("2006-01-02"), rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", xerrors.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+	}
+	dest = uniquePath(dest)
+
+	switch mode {
+	case config.ArchiveModeMove:
+		if err := moveFile(src, dest); err != nil {
+			return "", xerrors.Errorf("failed to move %s to %s: %w", src, dest, err)
+		}
+	case config.ArchiveModeCopy:
+		if err := copyFile(src, dest); err != nil {
+			return "", xerrors.Errorf("failed to copy %s to %s: %w", src, dest, err)
+		}
+	}
+
+	if failed {
+		var errors map[int][]database.ErrorStruct
+		if result != nil {
+			errors = result.Errors
+		}
+		path := strings.TrimSuffix(dest, filepath.Ext(dest)) + errorsCSVSuffix
+		if err := writeErrorsCSV(path, errors, importErr); err != nil {
+			return dest, xerrors.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return dest, nil
+}
+
+// archived 監視するディレクトリ内のprocessed/、failed/に移動したファイルか
+func (w *Watcher) archived(f *file.File) bool {
+	if w.source.ArchiveMode == config.ArchiveModeNone || w.source.Type == config.SourceTypeSFTP {
+		return false
+	}
+	rel, err := filepath.Rel(w.source.Path, w.source.ArchivePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	for _, dir := range []string{config.ArchiveProcessedDir, config.ArchiveFailedDir} {
+		if strings.HasPrefix(f.Path, filepath.ToSlash(filepath.Join(rel, dir))+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// uniquePath 同じ名前のファイルがある場合は「名前_2.csv」のように番号を付ける
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 2; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+}
+
+// moveFile 共有フォルダからローカルへの移動のようにリネームできない場合は、コピーしてから削除する
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// 作成日時で取込順を決めるため、更新日時を引き継ぐ
+	if info, err := in.Stat(); err == nil {
+		return os.Chtimes(dest, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+// writeErrorsCSV 行ごとのエラーを書き出す。ファイル全体のエラーは行番号なしで先頭に書く
+func writeErrorsCSV(path string, errors map[int][]database.ErrorStruct, importErr error) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// CP932にない文字は?にする
+	w := csv.NewWriter(encoding.ReplaceUnsupported(japanese.ShiftJIS.NewEncoder()).Writer(out))
+	w.UseCRLF = true
+
+	records := [][]string{{"行番号", "予約者名", "電話番号", "エラーコード", "項目", "エラー内容"}}
+	if importErr != nil {
+		records = append(records, []string{"", "", "", string(importerror.CodeUnknown), "", importErr.Error()})
+	}
+	lines := make([]int, 0, len(errors))
+	for line := range errors {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	for _, line := range lines {
+		for _, e := range errors[line] {
+			records = append(records, []string{
+				strconv.Itoa(line + 1),
+				e.CustomerName,
+				e.CustomerPhoneNumber,
+				string(e.Code),
+				e.Field,
+				importerror.Message(e.Code, e.Field, e.Params, importerror.Japanese),
+			})
+		}
+	}
+	if err := w.WriteAll(records); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
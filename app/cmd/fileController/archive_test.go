@@ -0,0 +1,86 @@
+package fileController
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	source := &config.WatchSource{Name: "test", Path: dir, ArchiveMode: config.ArchiveModeMove, ArchivePath: dir}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"a.csv", "sub/b.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	now := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+
+	dest, err := Archive(source, &file.File{Name: "a.csv", Path: "a.csv"}, &database.ImportResult{}, nil, now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := filepath.Join(dir, "processed", "2021-04-01", "a.csv"); dest != want {
+		t.Errorf("got %s, want %s", dest, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.csv")); !os.IsNotExist(err) {
+		t.Errorf("a.csv is not moved")
+	}
+
+	result := &database.ImportResult{Failed: true, Errors: map[int][]database.ErrorStruct{
+		1: {{CustomerName: "山田太郎", Code: importerror.CodeCancelNotFound}},
+	}}
+	dest, err = Archive(source, &file.File{Name: "b.csv", Path: "sub/b.csv"}, result, nil, now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := filepath.Join(dir, "failed", "2021-04-01", "sub", "b.csv"); dest != want {
+		t.Errorf("got %s, want %s", dest, want)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "failed", "2021-04-01", "sub", "b.errors.csv"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(raw)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := "2,山田太郎,,CANCEL_NOT_FOUND,,キャンセルする予約が登録されていません。\r\n"; !strings.HasSuffix(string(decoded), want) {
+		t.Errorf("got %q, want suffix %q", decoded, want)
+	}
+
+	// 検証のみの監視元はファイルを残す
+	validate := *source
+	validate.ImportMode = config.ImportModeValidate
+	if err := os.WriteFile(filepath.Join(dir, "c.csv"), []byte("c.csv"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	dest, err = Archive(&validate, &file.File{Name: "c.csv", Path: "c.csv"}, &database.ImportResult{}, nil, now)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Errorf("c.csv is not copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.csv")); err != nil {
+		t.Errorf("c.csv is moved: %v", err)
+	}
+
+	// 移動したファイルは監視の対象外
+	w := NewWatcher(nil, source, alert.NewAlerts())
+	if !w.archived(&file.File{Path: "failed/2021-04-01/sub/b.csv"}) || w.archived(&file.File{Path: "sub/b.csv"}) {
+		t.Errorf("archived files are not detected")
+	}
+}
@@ -0,0 +1,75 @@
+package fileController
+
+import (
+	"fmt"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+// arrivalWindow nowを含むルールの時間帯の開始と終了。時間帯外の場合はfalseを返す。
+// 時間帯が終日の場合は日付で区切らないため、開始と終了はゼロ値になる
+func arrivalWindow(rule config.ArrivalRule, now time.Time) (time.Time, time.Time, bool) {
+	return config.ClockWindow(rule.From, rule.To, rule.Weekdays, now)
+}
+
+// arrivalDeadline 時間帯内で、since（最後にファイルが届いた日時）か時間帯の開始の遅い方からWithinが経過する日時。時間帯外の場合はfalseを返す
+func arrivalDeadline(rule config.ArrivalRule, since, now time.Time) (time.Time, bool) {
+	start, _, ok := arrivalWindow(rule, now)
+	if !ok {
+		return time.Time{}, false
+	}
+	if since.After(start) {
+		start = since
+	}
+	return start.Add(rule.Within), true
+}
+
+// overdueArrival 期限を過ぎたルールがある場合は理由を返す。
+// ファイルが届いたことがない場合は、監視を始めた日時（started）から数える
+func overdueArrival(rules []config.ArrivalRule, lastArrival, started, now time.Time) (string, bool) {
+	since := lastArrival
+	if since.IsZero() {
+		since = started
+	}
+	for _, rule := range rules {
+		deadline, ok := arrivalDeadline(rule, since, now)
+		if ok && !now.Before(deadline) {
+			last := "none"
+			if !lastArrival.IsZero() {
+				last = lastArrival.In(now.Location()).Format(time.RFC3339)
+			}
+			return fmt.Sprintf("no new file within %v (last arrival: %s, deadline: %s)", rule.Within, last, deadline.Format(time.RFC3339)), true
+		}
+	}
+	return "", false
+}
+
+// checkArrival expected_arrivalsの期限を過ぎたかを状態に記録し、超過の発生・解消をアラートにする
+func (w *Watcher) checkArrival(now time.Time) {
+	if len(w.source.ExpectedArrivals) == 0 {
+		return
+	}
+	w.mu.Lock()
+	message, overdue := overdueArrival(w.source.ExpectedArrivals, w.status.LastArrival, w.started, now.In(w.location))
+	alerted := w.status.ArrivalOverdue
+	w.status.ArrivalOverdue = overdue
+	w.status.ArrivalMessage = message
+	w.mu.Unlock()
+
+	switch {
+	case overdue && !alerted:
+		w.alerts.Publish(alert.Event{Source: w.source.Name, Kind: alert.KindArrivalOverdue, Message: message})
+	case !overdue && alerted:
+		w.alerts.Publish(alert.Event{Source: w.source.Name, Kind: alert.KindArrivalOverdue, Message: "a new file arrived or the rule is out of hours", Resolved: true})
+	}
+}
+
+// setLastArrival 新しいファイルを検知した日時を記録する
+func (w *Watcher) setLastArrival(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.After(w.status.LastArrival) {
+		w.status.LastArrival = t
+	}
+}
@@ -0,0 +1,77 @@
+package fileController
+
+import (
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+func TestArrivalDeadline(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	at := func(day, hour, minute int) time.Time {
+		// 2021-04-05は月曜日
+		return time.Date(2021, 4, day, hour, minute, 0, 0, jst)
+	}
+	daytime := config.ArrivalRule{Within: 6 * time.Hour, From: "08:00", To: "22:00", Weekdays: []string{"mon", "tue", "wed", "thu", "fri"}}
+	overnight := config.ArrivalRule{Within: 2 * time.Hour, From: "22:00", To: "06:00"}
+
+	cases := []struct {
+		name         string
+		rule         config.ArrivalRule
+		lastArrival  time.Time
+		now          time.Time
+		wantDeadline time.Time
+		wantOK       bool
+	}{
+		{"時間帯の開始から", daytime, at(4, 23, 0), at(5, 9, 0), at(5, 14, 0), true},
+		{"最後のファイルから", daytime, at(5, 10, 30), at(5, 12, 0), at(5, 16, 30), true},
+		{"時間帯外", daytime, time.Time{}, at(5, 7, 0), time.Time{}, false},
+		{"曜日外", daytime, time.Time{}, at(4, 12, 0), time.Time{}, false},
+		{"日をまたぐ時間帯の開始日", overnight, time.Time{}, at(5, 23, 0), at(6, 0, 0), true},
+		{"日をまたぐ時間帯の終了日", overnight, at(6, 1, 0), at(6, 2, 0), at(6, 3, 0), true},
+		{"日をまたぐ時間帯の外", overnight, time.Time{}, at(6, 12, 0), time.Time{}, false},
+		{"終日は日付で区切らない", config.ArrivalRule{Within: time.Hour}, at(5, 23, 30), at(6, 0, 10), at(6, 0, 30), true},
+		{"終日の曜日外", config.ArrivalRule{Within: time.Hour, Weekdays: []string{"sat", "sun"}}, at(5, 23, 30), at(6, 0, 10), time.Time{}, false},
+	}
+	for _, c := range cases {
+		deadline, ok := arrivalDeadline(c.rule, c.lastArrival, c.now)
+		if ok != c.wantOK || !deadline.Equal(c.wantDeadline) {
+			t.Errorf("%s: got %v, %v, want %v, %v", c.name, deadline, ok, c.wantDeadline, c.wantOK)
+		}
+	}
+}
+
+func TestCheckArrival(t *testing.T) {
+	alerts := alert.NewAlerts()
+	w := NewWatcher(nil, &config.WatchSource{
+		Name:             "test",
+		ExpectedArrivals: []config.ArrivalRule{{Within: time.Hour}},
+	}, alerts)
+	now := w.started.Add(3 * time.Hour)
+
+	// ファイルが届いたことがない場合は監視を始めた日時から数える
+	w.checkArrival(w.started.Add(30 * time.Minute))
+	if w.Status().ArrivalOverdue {
+		t.Errorf("arrival should not be overdue before the first deadline")
+	}
+
+	w.setLastArrival(now.Add(-2 * time.Hour))
+	w.checkArrival(now)
+	w.checkArrival(now)
+	if status := w.Status(); !status.ArrivalOverdue || status.ArrivalMessage == "" {
+		t.Errorf("arrival should be overdue: %+v", status)
+	}
+	if events := alerts.Events(); len(events) != 1 || events[0].Kind != alert.KindArrivalOverdue {
+		t.Errorf("want 1 alert, got %+v", events)
+	}
+
+	w.setLastArrival(now)
+	w.checkArrival(now)
+	if w.Status().ArrivalOverdue {
+		t.Errorf("arrival should not be overdue")
+	}
+	if events := alerts.Events(); len(events) != 2 || !events[0].Resolved {
+		t.Errorf("want resolved alert, got %+v", events)
+	}
+}
@@ -0,0 +1,110 @@
+//go:build linux
+// +build linux
+
+package fileController
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// statfsのf_type（linux/magic.h）
+var fileSystemNames = map[uint32]string{
+	0xEF53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683E: "btrfs",
+	0x01021994: "tmpfs",
+	0x794C7630: "overlayfs",
+	0x65735546: "fuse",
+	0x517B:     "smb",
+	0xFF534D42: "cifs",
+	0xFE534D42: "smb2",
+	0x6969:     "nfs",
+	0x01021997: "9p",
+}
+
+// notifyUnsupported リモートの変更がinotifyで通知されないファイルシステム
+var notifyUnsupported = map[string]bool{
+	"fuse": true,
+	"smb":  true,
+	"cifs": true,
+	"smb2": true,
+	"nfs":  true,
+	"9p":   true,
+}
+
+func fileSystemType(path string) (string, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return "", err
+	}
+	magic := uint32(stat.Type)
+	if name, ok := fileSystemNames[magic]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("0x%X", magic), nil
+}
+
+func supportsNotify(fileSystem string) bool {
+	return !notifyUnsupported[fileSystem]
+}
+
+// staleErrors 切断された共有フォルダ（FUSE、CIFS、NFS）へのアクセスで返るエラー
+var staleErrors = []syscall.Errno{syscall.ENOTCONN, syscall.ESTALE, syscall.EIO, syscall.EHOSTDOWN, syscall.ECONNABORTED}
+
+func isStale(err error) bool {
+	for _, errno := range staleErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountOf /proc/self/mountsから、pathを含むマウントのマウントポイントとファイルシステムの種類を返す
+func mountOf(path string) (string, string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	fileSystems := map[string]string{}
+	var mountPoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[1])
+		// 同じマウントポイントに重ねてマウントした場合は後のものが見える
+		fileSystems[mountPoint] = fields[2]
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	mountPoint := mountPointOf(path, mountPoints)
+	return mountPoint, fileSystems[mountPoint], nil
+}
+
+// unescapeMountPath /proc/self/mountsで\040のように8進数でエスケープされた空白等を戻す
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package fileController
+
+import "testing"
+
+func TestUnescapeMountPath(t *testing.T) {
+	cases := map[string]string{
+		`/mnt/windows`:           "/mnt/windows",
+		`/mnt/windows/予約\040データ`: "/mnt/windows/予約 データ",
+		`/mnt/a\134b`:            `/mnt/a\b`,
+		`/mnt/trailing\04`:       `/mnt/trailing\04`,
+	}
+	for path, want := range cases {
+		if got := unescapeMountPath(path); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package fileController
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func fileSystemType(path string) (string, error) {
+	return "", fmt.Errorf("file system detection is not supported on %s", runtime.GOOS)
+}
+
+func supportsNotify(fileSystem string) bool {
+	return true
+}
+
+func isStale(err error) bool {
+	return false
+}
+
+func mountOf(path string) (string, string, error) {
+	return "", "", fmt.Errorf("mount table is not supported on %s", runtime.GOOS)
+}
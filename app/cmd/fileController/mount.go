@@ -0,0 +1,151 @@
+package fileController
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"golang.org/x/xerrors"
+)
+
+// 監視するディレクトリの状態
+const (
+	MountOK = "ok"
+	// MountMissing ディレクトリがない
+	MountMissing = "missing"
+	// MountUnmounted 共有フォルダがマウントされていない（mount_typesのマウントの下にない）
+	MountUnmounted = "unmounted"
+	// MountStale 共有フォルダの応答がない、または切断されている
+	MountStale = "stale"
+	// MountSentinelMissing sentinel_fileが見えない
+	MountSentinelMissing = "sentinel_missing"
+	// MountUnavailable その他の理由でアクセスできない
+	MountUnavailable = "unavailable"
+)
+
+type probeResult struct {
+	state string
+	err   error
+}
+
+// checkMount 監視ディレクトリにアクセスできるかを状態に記録し、異常の発生・解消をアラートにする。
+// 共有フォルダが切断されると空のディレクトリに見えることがあるため、マウントとsentinel_fileも確認する
+func (w *Watcher) checkMount() error {
+	state, err := w.probeMount()
+	w.mu.Lock()
+	w.status.MountState = state
+	w.status.MountAvailable = err == nil
+	w.status.MountError = ""
+	if err != nil {
+		w.status.MountError = err.Error()
+	}
+	alerted := w.mountAlerted
+	w.mountAlerted = err != nil
+	w.mu.Unlock()
+
+	switch {
+	case err != nil && !alerted:
+		w.alerts.Publish(alert.Event{Source: w.source.Name, Kind: alert.KindMountUnavailable, Message: err.Error()})
+	case err == nil && alerted:
+		w.alerts.Publish(alert.Event{Source: w.source.Name, Kind: alert.KindMountUnavailable, Message: w.source.Path + " is available", Resolved: true})
+	}
+	return err
+}
+
+// probeMount 応答のない共有フォルダへのアクセスは戻らないことがあるため、AccessTimeoutで打ち切る。
+// 打ち切ったアクセスが戻るまでは新しいアクセスをせず、その結果を待つ
+func (w *Watcher) probeMount() (string, error) {
+	if w.probe == nil {
+		probe := make(chan probeResult, 1)
+		go func() {
+			state, err := w.files.Probe()
+			probe <- probeResult{state: state, err: err}
+		}()
+		w.probe = probe
+	}
+	select {
+	case result := <-w.probe:
+		w.probe = nil
+		return result.state, result.err
+	case <-time.After(w.source.AccessTimeout):
+		return MountStale, xerrors.Errorf("no response from %s in %v", w.source.Path, w.source.AccessTimeout)
+	}
+}
+
+func probeMount(source *config.WatchSource) (string, error) {
+	path := source.Path
+	info, err := os.Stat(path)
+	if err != nil {
+		return mountErrorState(err), xerrors.Errorf("failed to access %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return MountMissing, xerrors.Errorf("%s is not a directory", path)
+	}
+	if len(source.MountTypes) > 0 {
+		mountPoint, fileSystem, err := mountOf(path)
+		if err != nil {
+			return MountUnavailable, xerrors.Errorf("failed to read mount table: %w", err)
+		}
+		if !containsString(source.MountTypes, fileSystem) {
+			return MountUnmounted, xerrors.Errorf("%s is not mounted: %s is on %s (%s), want one of %v", path, path, mountPoint, fileSystem, source.MountTypes)
+		}
+	}
+	// 切断されたFUSEはstatに成功してもディレクトリを読めないことがある
+	dir, err := os.Open(path)
+	if err != nil {
+		return mountErrorState(err), xerrors.Errorf("failed to open %s: %w", path, err)
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return mountErrorState(err), xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+	if source.SentinelFile != "" {
+		sentinel := filepath.Join(path, filepath.FromSlash(source.SentinelFile))
+		if _, err := os.Stat(sentinel); err != nil {
+			if os.IsNotExist(err) {
+				return MountSentinelMissing, xerrors.Errorf("sentinel file %s is not found, the share may be disconnected", sentinel)
+			}
+			return mountErrorState(err), xerrors.Errorf("failed to access %s: %w", sentinel, err)
+		}
+	}
+	return MountOK, nil
+}
+
+func mountErrorState(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return MountMissing
+	case isStale(err):
+		return MountStale
+	default:
+		return MountUnavailable
+	}
+}
+
+// mountPointOf マウントポイントの一覧から、pathを含む最も深いマウントポイントを返す
+func mountPointOf(path string, mountPoints []string) string {
+	path = filepath.Clean(path)
+	found := ""
+	for _, mountPoint := range mountPoints {
+		if mountPoint != "/" && path != mountPoint && !strings.HasPrefix(path, mountPoint+"/") {
+			continue
+		}
+		if len(mountPoint) > len(found) {
+			found = mountPoint
+		}
+	}
+	return found
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package fileController
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+func TestCheckMount(t *testing.T) {
+	dir := t.TempDir()
+	alerts := alert.NewAlerts()
+	source := &config.WatchSource{Name: "test", Path: dir, SentinelFile: ".sentinel", AccessTimeout: time.Second}
+	w := NewWatcher(nil, source, alerts)
+
+	// 空のディレクトリはsentinel_fileがないため切断とみなす
+	if err := w.checkMount(); err == nil || w.Status().MountState != MountSentinelMissing || w.Status().Healthy() {
+		t.Errorf("sentinel missing: got %+v, %v", w.Status(), err)
+	}
+	// 異常が続いている間はアラートを繰り返さない
+	w.checkMount()
+	if events := alerts.Events(); len(events) != 1 || events[0].Kind != alert.KindMountUnavailable || events[0].Resolved {
+		t.Errorf("want 1 alert, got %+v", events)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".sentinel"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := w.checkMount(); err != nil || !w.Status().MountAvailable || w.Status().MountState != MountOK {
+		t.Errorf("mount should be available: %+v, %v", w.Status(), err)
+	}
+	if events := alerts.Events(); len(events) != 2 || !events[0].Resolved {
+		t.Errorf("want resolved alert, got %+v", events)
+	}
+
+	source.Path = filepath.Join(dir, "missing")
+	if err := w.checkMount(); err == nil || w.Status().MountState != MountMissing {
+		t.Errorf("missing directory: got %+v, %v", w.Status(), err)
+	}
+	if err := os.WriteFile(source.Path, nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := w.checkMount(); err == nil || w.Status().MountState != MountMissing {
+		t.Errorf("file should not be regarded as a directory: got %+v, %v", w.Status(), err)
+	}
+}
+
+func TestMountPointOf(t *testing.T) {
+	mountPoints := []string{"/", "/mnt", "/mnt/windows", "/mnt/windows2"}
+	cases := map[string]string{
+		"/mnt/windows":         "/mnt/windows",
+		"/mnt/windows/lincoln": "/mnt/windows",
+		"/mnt/windows2/a":      "/mnt/windows2",
+		"/mnt/other":           "/mnt",
+		"/var/lib":             "/",
+	}
+	for path, want := range cases {
+		if got := mountPointOf(path, mountPoints); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+}
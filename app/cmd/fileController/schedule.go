@@ -0,0 +1,51 @@
+package fileController
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// parseSchedules schedulesのcron式を読み込む（設定はチェック済み）
+func parseSchedules(specs []string) []cron.Schedule {
+	var schedules []cron.Schedule
+	for _, spec := range specs {
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			sugar.Errorf("invalid cron expression %s: %v", spec, err)
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+// nextScan schedulesのいずれかで、nowより後の最初のスキャン日時。cron式はnowのタイムゾーンの時刻にする
+func nextScan(schedules []cron.Schedule, now time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range schedules {
+		t := schedule.Next(now)
+		// 存在しない日付（2月30日等）だけの場合はゼロ値になる
+		if t.IsZero() {
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// untilNextScan 次にschedulesでスキャンするまでの時間。次の日時を状態に記録する
+func (w *Watcher) untilNextScan(now time.Time) time.Duration {
+	next := nextScan(w.schedules, now.In(w.location))
+	w.mu.Lock()
+	w.status.NextScan = next
+	w.mu.Unlock()
+	if next.IsZero() {
+		// スキャンする日時がない場合は、1日後に確認し直す
+		sugar.Warnf("[%s] no next scan time in schedules", w.source.Name)
+		return 24 * time.Hour
+	}
+	return next.Sub(now)
+}
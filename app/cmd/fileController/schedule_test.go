@@ -0,0 +1,29 @@
+package fileController
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextScan(t *testing.T) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	// 6:00～23:00は2分ごと、夜間は1時間ごと
+	schedules := parseSchedules([]string{"*/2 6-22 * * *", "0 23,0-5 * * *"})
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2021, 4, 1, 12, 0, 30, 0, jst), time.Date(2021, 4, 1, 12, 2, 0, 0, jst)},
+		{time.Date(2021, 4, 1, 22, 58, 0, 0, jst), time.Date(2021, 4, 1, 23, 0, 0, 0, jst)},
+		{time.Date(2021, 4, 1, 23, 0, 0, 0, jst), time.Date(2021, 4, 2, 0, 0, 0, 0, jst)},
+		{time.Date(2021, 4, 2, 5, 10, 0, 0, jst), time.Date(2021, 4, 2, 6, 0, 0, 0, jst)},
+	}
+	for _, tt := range tests {
+		if got := nextScan(schedules, tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextScan(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+	if got := nextScan(parseSchedules([]string{"0 0 30 2 *"}), time.Date(2021, 4, 1, 0, 0, 0, 0, jst)); !got.IsZero() {
+		t.Errorf("nextScan of impossible date = %v, want zero", got)
+	}
+}
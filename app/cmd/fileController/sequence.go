@@ -0,0 +1,62 @@
+package fileController
+
+import (
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/file"
+)
+
+// notificationRange ファイルの通知番号の最小・最大。内容（ハッシュ）が変わるまで再読込しない
+type notificationRange struct {
+	hash  string
+	first int
+	last  int
+}
+
+// holdGaps 通知番号に欠番があるファイルと、それより後の通知番号のあるファイルを保留し、取り込むファイルと保留した数を返す。
+// filesは古い順で、取得元から取得済み（Fetch）のもの。保留してからgap_timeoutが経過したファイルは、欠番を待たずに取り込む
+func (w *Watcher) holdGaps(files file.Files, now time.Time) (file.Files, int) {
+	var ready file.Files
+	held := map[string]time.Time{}
+	ranges := map[string]notificationRange{}
+	holding := false
+	for _, f := range files {
+		r, ok := w.ranges[f.Path]
+		if !ok || r.hash != f.Hash {
+			first, last, err := w.readNotificationRange(localFilePath(w.source, f), w.source.SiteController)
+			if err != nil {
+				// 読み込めないファイルは取込時にエラーとして記録する
+				sugar.Warnf("[%s] %v", w.source.Name, err)
+			}
+			r = notificationRange{hash: f.Hash, first: first, last: last}
+		}
+		ranges[f.Path] = r
+		first, last := r.first, r.last
+		f.FirstNotificationNumber, f.LastNotificationNumber = first, last
+
+		if first > 0 && *w.source.GapTimeout > 0 {
+			since, ok := w.heldSince[f.Path]
+			if !ok {
+				since = now
+			}
+			gap := w.lastNotification > 0 && first > w.lastNotification+1
+			if (gap || holding) && now.Sub(since) < *w.source.GapTimeout {
+				if gap {
+					sugar.Warnf("[%s] notification numbers %d-%d are missing, hold %s", w.source.Name, w.lastNotification+1, first-1, f.Path)
+				}
+				held[f.Path] = since
+				holding = true
+				continue
+			}
+			if gap {
+				sugar.Warnf("[%s] notification numbers %d-%d are still missing after %v, import %s", w.source.Name, w.lastNotification+1, first-1, *w.source.GapTimeout, f.Path)
+			}
+		}
+		if last > w.lastNotification {
+			w.lastNotification = last
+		}
+		ready = append(ready, f)
+	}
+	w.heldSince = held
+	w.ranges = ranges
+	return ready, len(held)
+}
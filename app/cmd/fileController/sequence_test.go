@@ -0,0 +1,89 @@
+package fileController
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+func TestHoldGaps(t *testing.T) {
+	gapTimeout := 10 * time.Minute
+	w := NewWatcher(nil, &config.WatchSource{Name: "test", Path: "/mnt", GapTimeout: &gapTimeout}, alert.NewAlerts())
+	w.lastNotification = 10
+	ranges := map[string][2]int{
+		"a.csv": {11, 12},
+		// 13が欠番
+		"b.csv": {14, 15},
+		"c.csv": {16, 16},
+	}
+	reads := map[string]int{}
+	w.readNotificationRange = func(csvPath string, siteControllerName string) (int, int, error) {
+		name := filepath.Base(csvPath)
+		reads[name]++
+		if r, ok := ranges[name]; ok {
+			return r[0], r[1], nil
+		}
+		return 0, 0, errors.New("failed to read")
+	}
+	paths := func(files file.Files) []string {
+		var list []string
+		for _, f := range files {
+			list = append(list, f.Path)
+		}
+		return list
+	}
+	newFiles := func(names ...string) file.Files {
+		var files file.Files
+		for _, name := range names {
+			files = append(files, &file.File{Name: name, Path: name, Hash: "hash-" + name})
+		}
+		return files
+	}
+
+	// 欠番の後のファイルと、それより後のファイルを保留する
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	ready, held := w.holdGaps(newFiles("a.csv", "b.csv", "c.csv"), now)
+	if got, want := paths(ready), []string{"a.csv"}; !reflect.DeepEqual(got, want) || held != 2 {
+		t.Errorf("got %v, %d held, want %v, 2 held", got, held, want)
+	}
+	if w.lastNotification != 12 {
+		t.Errorf("got last notification %d, want 12", w.lastNotification)
+	}
+
+	// gap_timeoutまでは保留し、ファイルは再読込しない
+	ready, held = w.holdGaps(newFiles("b.csv", "c.csv"), now.Add(gapTimeout-time.Second))
+	if len(ready) != 0 || held != 2 {
+		t.Errorf("got %v, %d held, want none, 2 held", paths(ready), held)
+	}
+	if reads["b.csv"] != 1 || reads["c.csv"] != 1 {
+		t.Errorf("got reads %v, want 1 read per file", reads)
+	}
+
+	// gap_timeoutが経過したら欠番を待たずに取り込む
+	ready, held = w.holdGaps(newFiles("b.csv", "c.csv"), now.Add(gapTimeout))
+	if got, want := paths(ready), []string{"b.csv", "c.csv"}; !reflect.DeepEqual(got, want) || held != 0 {
+		t.Errorf("got %v, %d held, want %v, 0 held", got, held, want)
+	}
+	if w.lastNotification != 16 {
+		t.Errorf("got last notification %d, want 16", w.lastNotification)
+	}
+
+	// 読み込めないファイルは保留せず、取込時にエラーとして記録する
+	ready, held = w.holdGaps(newFiles("broken.csv"), now)
+	if got, want := paths(ready), []string{"broken.csv"}; !reflect.DeepEqual(got, want) || held != 0 {
+		t.Errorf("got %v, %d held, want %v, 0 held", got, held, want)
+	}
+
+	// 内容が変わったファイルは再読込する
+	files := newFiles("broken.csv")
+	files[0].Hash = "changed"
+	w.holdGaps(files, now)
+	if reads["broken.csv"] != 2 {
+		t.Errorf("got %d reads, want 2", reads["broken.csv"])
+	}
+}
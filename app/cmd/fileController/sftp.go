@@ -0,0 +1,312 @@
+package fileController
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/xerrors"
+)
+
+// downloadSuffix ダウンロード中のファイルの接尾辞
+const downloadSuffix = ".download"
+
+// sftpSource SFTPサーバのディレクトリ。ファイルはWatchSource.DownloadPathにダウンロードしてから取り込む
+type sftpSource struct {
+	source *config.WatchSource
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPSource(source *config.WatchSource) *sftpSource {
+	return &sftpSource{source: source}
+}
+
+// connect 接続していない場合は接続する
+func (s *sftpSource) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	clientConfig, err := sshClientConfig(s.source)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(s.source.SFTP.Host, strconv.Itoa(s.source.SFTP.Port))
+	conn, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, xerrors.Errorf("failed to start sftp session on %s: %w", addr, err)
+	}
+	s.conn, s.client = conn, client
+	return client, nil
+}
+
+// check 通信エラーの場合は、次の操作で接続し直すよう接続を閉じる
+func (s *sftpSource) check(err error) error {
+	var statusErr *sftp.StatusError
+	if err == nil || os.IsNotExist(err) || os.IsPermission(err) || errors.As(err, &statusErr) {
+		return err
+	}
+	s.Close()
+	return err
+}
+
+func (s *sftpSource) Probe() (string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return MountUnavailable, err
+	}
+	info, err := client.Stat(s.source.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return MountMissing, xerrors.Errorf("%s is not found on the sftp server: %w", s.source.Path, err)
+		}
+		return MountUnavailable, xerrors.Errorf("failed to access %s on the sftp server: %w", s.source.Path, s.check(err))
+	}
+	if !info.IsDir() {
+		return MountMissing, xerrors.Errorf("%s is not a directory on the sftp server", s.source.Path)
+	}
+	if err := os.MkdirAll(s.source.DownloadPath, 0755); err != nil {
+		return MountUnavailable, xerrors.Errorf("failed to create %s: %w", s.source.DownloadPath, err)
+	}
+	return MountOK, nil
+}
+
+func (s *sftpSource) List(filter *file.Filter) (file.Files, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	fileList, err := file.ListFiles(&sftpFS{client: client, root: s.source.Path, skip: s.movePath()}, filter)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot get file list in %s on the sftp server: %w", s.source.Path, s.check(err))
+	}
+	return fileList, nil
+}
+
+func (s *sftpSource) Exists(p string) bool {
+	client, err := s.connect()
+	if err != nil {
+		return false
+	}
+	_, err = client.Stat(s.remotePath(p))
+	return s.check(err) == nil
+}
+
+func (s *sftpSource) Hash(f *file.File) (string, error) {
+	client, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	remote, err := client.Open(s.remotePath(f.Path))
+	if err != nil {
+		return "", s.check(err)
+	}
+	defer remote.Close()
+	hash, err := file.HashReader(remote)
+	return hash, s.check(err)
+}
+
+// Fetch DownloadPathの同じ相対パスにダウンロードする。
+// 内容の違うファイルがまだある（取り込む前に更新された）場合は、別名（名前_2.csv）でダウンロードする
+func (s *sftpSource) Fetch(f *file.File) error {
+	dest := filepath.Join(s.source.DownloadPath, filepath.FromSlash(f.Path))
+	if hash, err := file.Hash(dest); err == nil {
+		if hash == f.Hash {
+			// 前回ダウンロードした後、取込ジョブの登録に失敗した
+			return nil
+		}
+		dest = uniquePath(dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return xerrors.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+	}
+	if err := s.download(f, dest); err != nil {
+		return err
+	}
+	f.Name = filepath.Base(dest)
+	return nil
+}
+
+// download ダウンロード中のファイルを取り込まないよう、一時ファイルに書いてからリネームする
+func (s *sftpSource) download(f *file.File, dest string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	remote, err := client.Open(s.remotePath(f.Path))
+	if err != nil {
+		return xerrors.Errorf("failed to open %s on the sftp server: %w", f.Path, s.check(err))
+	}
+	defer remote.Close()
+
+	tmp := dest + downloadSuffix
+	local, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := io.Copy(local, remote); err != nil {
+		local.Close()
+		return xerrors.Errorf("failed to download %s: %w", f.Path, s.check(err))
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+	// ハッシュを計算した後に更新された場合は、次のスキャンで取り込む
+	hash, err := file.Hash(tmp)
+	if err != nil {
+		return err
+	}
+	if hash != f.Hash {
+		return xerrors.Errorf("%s was modified while downloading", f.Path)
+	}
+	if err := os.Chtimes(tmp, f.CreatedTime, f.CreatedTime); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func (s *sftpSource) Complete(f *file.File) error {
+	sftpConfig := s.source.SFTP
+	if sftpConfig.After == config.RemoteAfterKeep {
+		return nil
+	}
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	remote := s.remotePath(f.Path)
+	switch sftpConfig.After {
+	case config.RemoteAfterDelete:
+		if err := client.Remove(remote); err != nil && !os.IsNotExist(err) {
+			return xerrors.Errorf("failed to delete %s on the sftp server: %w", remote, s.check(err))
+		}
+	case config.RemoteAfterMove:
+		dest := path.Join(s.movePath(), f.Path)
+		if err := client.MkdirAll(path.Dir(dest)); err != nil {
+			return xerrors.Errorf("failed to create %s on the sftp server: %w", path.Dir(dest), s.check(err))
+		}
+		// 移動先に同じ名前のファイルがある場合は上書きする
+		if err := client.PosixRename(remote, dest); err != nil && !os.IsNotExist(err) {
+			return xerrors.Errorf("failed to move %s to %s on the sftp server: %w", remote, dest, s.check(err))
+		}
+	}
+	return nil
+}
+
+func (s *sftpSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	err := s.conn.Close()
+	s.conn, s.client = nil, nil
+	return err
+}
+
+func (s *sftpSource) remotePath(p string) string {
+	return path.Join(s.source.Path, p)
+}
+
+// movePath 取得後にファイルを移動するディレクトリ。相対パスの場合はPathからの相対パス
+func (s *sftpSource) movePath() string {
+	movePath := s.source.SFTP.MovePath
+	if s.source.SFTP.After != config.RemoteAfterMove || movePath == "" {
+		return ""
+	}
+	if path.IsAbs(movePath) {
+		return path.Clean(movePath)
+	}
+	return path.Join(s.source.Path, movePath)
+}
+
+// sftpFS SFTPサーバのディレクトリをルートにするfile.FileSystem。移動先のディレクトリ（skip）はたどらない
+type sftpFS struct {
+	client *sftp.Client
+	root   string
+	skip   string
+}
+
+func (s *sftpFS) ReadDir(dir string) ([]fs.FileInfo, error) {
+	dirPath := path.Join(s.root, dir)
+	infos, err := s.client.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		if s.skip != "" && path.Join(dirPath, info.Name()) == s.skip {
+			continue
+		}
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// sshClientConfig パスワードか秘密鍵で認証し、サーバの公開鍵をknown_hostsかフィンガープリントで確認する
+func sshClientConfig(source *config.WatchSource) (*ssh.ClientConfig, error) {
+	sftpConfig := source.SFTP
+	var auth []ssh.AuthMethod
+	if sftpConfig.PrivateKeyPath != "" {
+		key, err := os.ReadFile(sftpConfig.PrivateKeyPath)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sftpConfig.Password != "" {
+		auth = append(auth, ssh.Password(sftpConfig.Password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	if sftpConfig.KnownHostsPath != "" {
+		callback, err := knownhosts.New(sftpConfig.KnownHostsPath)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read known hosts: %w", err)
+		}
+		hostKeyCallback = callback
+	} else {
+		hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != sftpConfig.HostKey {
+				return xerrors.Errorf("host key of %s does not match: %s", hostname, fingerprint)
+			}
+			return nil
+		}
+	}
+	return &ssh.ClientConfig{
+		User:            sftpConfig.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         source.AccessTimeout,
+	}, nil
+}
@@ -0,0 +1,266 @@
+package fileController
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer パスワード認証のSFTPサーバを起動し、ポートとホスト鍵のフィンガープリントを返す。
+// サーバはローカルのファイルシステムをそのまま公開する
+func startSFTPServer(t *testing.T, password string) (int, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "omotebako" && string(pass) == password {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, serverConfig)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func serveSFTP(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// ペイロードは長さ（4バイト）とサブシステム名
+				req.Reply(req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		go func() {
+			defer channel.Close()
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			server.Serve()
+		}()
+	}
+}
+
+// fakeLedger 登録した取込ジョブをメモリに持つledgerStore
+type fakeLedger struct {
+	jobs []*models.ImportJob
+}
+
+func (l *fakeLedger) GetProcessedFileKeys(ctx context.Context, source string) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+func (l *fakeLedger) GetLastNotificationNumber(ctx context.Context, source string) (int, error) {
+	return 0, nil
+}
+
+func (l *fakeLedger) GetLastArrivalTime(ctx context.Context, source string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (l *fakeLedger) GetLatestFileCreatedTime(ctx context.Context, source string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (l *fakeLedger) InsertProcessedFile(ctx context.Context, source string, f *file.File) (int, error) {
+	return 0, nil
+}
+
+func (l *fakeLedger) EnqueueDetectedFile(ctx context.Context, source *config.WatchSource, f *file.File) (*models.ImportJob, error) {
+	job := database.NewDetectedImportJob(source, f, len(l.jobs)+1)
+	l.jobs = append(l.jobs, job)
+	return job, nil
+}
+
+func TestSFTPSource(t *testing.T) {
+	port, hostKey := startSFTPServer(t, "secret")
+	remoteDir := t.TempDir()
+	source := &config.WatchSource{
+		Name:          "sftp",
+		Type:          config.SourceTypeSFTP,
+		Path:          remoteDir,
+		DownloadPath:  t.TempDir(),
+		AccessTimeout: 5 * time.Second,
+		SFTP: &config.SFTPSource{
+			Host:     "127.0.0.1",
+			Port:     port,
+			User:     "omotebako",
+			Password: "secret",
+			HostKey:  hostKey,
+			After:    config.RemoteAfterMove,
+			MovePath: "done",
+		},
+	}
+	files := NewFileSource(source)
+	defer files.Close()
+
+	if state, err := files.Probe(); err != nil || state != MountOK {
+		t.Fatalf("probe: %s, %v", state, err)
+	}
+
+	modTime := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{"a.csv": "予約,a", "sub/b.csv": "予約,b", "c.txt": "c", "done/old.csv": "予約,old"} {
+		path := filepath.Join(remoteDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// 移動先のディレクトリはたどらない
+	filter, err := file.NewFilter([]string{"*.csv"}, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fileList, err := files.List(filter)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(fileList) != 2 || fileList[0].Path != "a.csv" || fileList[1].Path != "sub/b.csv" || !fileList[0].CreatedTime.Equal(modTime) {
+		t.Fatalf("unexpected files: %+v, %+v", fileList[0], fileList)
+	}
+	if !files.Exists("c.txt") || files.Exists("c.txt.lock") {
+		t.Errorf("Exists returned unexpected result")
+	}
+
+	f := fileList[1]
+	if f.Hash, err = files.Hash(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := files.Fetch(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	local := filepath.Join(source.DownloadPath, "sub", "b.csv")
+	if content, err := os.ReadFile(local); err != nil || string(content) != "予約,b" {
+		t.Errorf("downloaded file: %q, %v", content, err)
+	}
+	if info, err := os.Stat(local); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("modification time is not preserved: %v", err)
+	}
+	if err := files.Complete(f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "done", "sub", "b.csv")); err != nil {
+		t.Errorf("remote file is not moved: %v", err)
+	}
+	// 移動済みの場合も失敗しない
+	if err := files.Complete(f); err != nil {
+		t.Errorf("%v", err)
+	}
+
+	// 取り込む前に内容が変わった同じパスのファイルは別名でダウンロードする
+	if err := os.WriteFile(filepath.Join(remoteDir, "sub", "b.csv"), []byte("予約,b2"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	updated := &file.File{Name: "b.csv", Path: "sub/b.csv", CreatedTime: modTime}
+	if updated.Hash, err = files.Hash(updated); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := files.Fetch(updated); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if updated.Name != "b_2.csv" {
+		t.Errorf("want b_2.csv, got %s", updated.Name)
+	}
+
+	// 監視で検知したファイルは、ダウンロードしたファイルから通知番号を読み込み、ダウンロードしたディレクトリから取り込む
+	source.DownloadPath = t.TempDir()
+	quietPeriod, gapTimeout := time.Duration(0), time.Minute
+	source.QuietPeriod, source.GapTimeout = &quietPeriod, &gapTimeout
+	source.Include = []string{"*.csv"}
+	w := NewWatcher(nil, source, alert.NewAlerts())
+	defer w.files.Close()
+	ledger := &fakeLedger{}
+	w.db = ledger
+	var readPaths []string
+	w.readNotificationRange = func(csvPath string, siteControllerName string) (int, int, error) {
+		if _, err := os.Stat(csvPath); err != nil {
+			return 0, 0, err
+		}
+		readPaths = append(readPaths, csvPath)
+		return 0, 0, nil
+	}
+	// 2回のスキャンで変わらないファイルを取り込む
+	for i := 0; i < 2; i++ {
+		if _, _, _, _, err := w.newFiles(context.Background(), time.Time{}); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	wantPaths := []string{filepath.Join(source.DownloadPath, "a.csv"), filepath.Join(source.DownloadPath, "sub", "b.csv")}
+	if !reflect.DeepEqual(readPaths, wantPaths) {
+		t.Errorf("read notification numbers from %v, want %v", readPaths, wantPaths)
+	}
+	if len(ledger.jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(ledger.jobs))
+	}
+	for i, job := range ledger.jobs {
+		if got := filepath.Join(job.Dir, job.FileName); got != wantPaths[i] {
+			t.Errorf("job %s reads %s, want %s", job.FilePath, got, wantPaths[i])
+		}
+	}
+	if content, err := os.ReadFile(filepath.Join(ledger.jobs[1].Dir, ledger.jobs[1].FileName)); err != nil || string(content) != "予約,b2" {
+		t.Errorf("file of job: %q, %v", content, err)
+	}
+
+	// 接続できない場合
+	source.SFTP.Password = "wrong"
+	files.Close()
+	if state, err := files.Probe(); err == nil || state != MountUnavailable {
+		t.Errorf("probe with wrong password: %s, %v", state, err)
+	}
+}
@@ -0,0 +1,77 @@
+package fileController
+
+import (
+	"os"
+	"path/filepath"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+// FileSource 監視するファイルの取得元（ローカルのディレクトリ、SFTPサーバ）。
+// ファイルのパスは取得元のディレクトリからの相対パス（/区切り）
+type FileSource interface {
+	// Probe 取得元にアクセスできるかを確認し、状態（MountOK等）を返す
+	Probe() (string, error)
+	// List filterに一致するファイルを古い順に返す
+	List(filter *file.Filter) (file.Files, error)
+	// Exists 取得元にファイルがあるか（ロックファイルの確認に使う）
+	Exists(path string) bool
+	// Hash ファイルの内容のSHA-256
+	Hash(f *file.File) (string, error)
+	// Fetch 取込ジョブが読み込めるよう、ファイルをWatchSource.LocalPathに置く。別名で置いた場合はNameを変える
+	Fetch(f *file.File) error
+	// Complete 取込ジョブに登録したファイルを、取得元の設定に従って削除（移動）する
+	Complete(f *file.File) error
+	Close() error
+}
+
+// localFilePath 取得したファイルのローカルのパス。SFTPから別名でダウンロードしたファイルはNameがPathのファイル名と異なる
+func localFilePath(source *config.WatchSource, f *file.File) string {
+	return filepath.Join(source.LocalPath(), filepath.Dir(filepath.FromSlash(f.Path)), f.Name)
+}
+
+// NewFileSource 監視元のtypeに応じた取得元を返す
+func NewFileSource(source *config.WatchSource) FileSource {
+	if source.Type == config.SourceTypeSFTP {
+		return newSFTPSource(source)
+	}
+	return &localSource{source: source}
+}
+
+// localSource ローカル（マウントした共有フォルダ）のディレクトリ。ファイルはその場所から取り込む
+type localSource struct {
+	source *config.WatchSource
+}
+
+func (s *localSource) Probe() (string, error) {
+	return probeMount(s.source)
+}
+
+func (s *localSource) List(filter *file.Filter) (file.Files, error) {
+	return file.GetFileList(s.source.Path, filter)
+}
+
+func (s *localSource) Exists(path string) bool {
+	_, err := os.Stat(s.localPath(path))
+	return err == nil
+}
+
+func (s *localSource) Hash(f *file.File) (string, error) {
+	return file.Hash(s.localPath(f.Path))
+}
+
+func (s *localSource) Fetch(f *file.File) error {
+	return nil
+}
+
+func (s *localSource) Complete(f *file.File) error {
+	return nil
+}
+
+func (s *localSource) Close() error {
+	return nil
+}
+
+func (s *localSource) localPath(path string) string {
+	return filepath.Join(s.source.Path, filepath.FromSlash(path))
+}
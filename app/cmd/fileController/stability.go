@@ -0,0 +1,73 @@
+package fileController
+
+import (
+	"path/filepath"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/file"
+)
+
+// observation 前回のスキャンで見たファイルのサイズと更新日時
+type observation struct {
+	size    int64
+	modTime time.Time
+}
+
+// stableFiles 書き込みが終わったとみなせるファイルを返す。
+// 次のスキャンで取り込める可能性がある（書き込み中の）ファイルの数も返す
+func (w *Watcher) stableFiles(fileList file.Files, now time.Time) (file.Files, int) {
+	var stable file.Files
+	pending := 0
+	observations := make(map[string]observation, len(fileList))
+	for _, f := range fileList {
+		// 一時ファイルは書き込み後にリネームされるため、待たずに無視する
+		if matchAny(w.source.TempPatterns, f.Name) {
+			continue
+		}
+		current := observation{size: f.Size, modTime: f.CreatedTime}
+		observations[f.Path] = current
+		previous, observed := w.observations[f.Path]
+
+		switch {
+		case w.locked(f):
+			sugar.Debugf("[%s] %s is locked", w.source.Name, f.Path)
+		case now.Sub(f.CreatedTime) < *w.source.QuietPeriod:
+			sugar.Debugf("[%s] %s was modified within %v", w.source.Name, f.Path, *w.source.QuietPeriod)
+		case !observed || previous != current:
+			// 2回のスキャンでサイズと更新日時が変わらないことを確認する
+			sugar.Debugf("[%s] %s is not observed or changed since the last scan", w.source.Name, f.Path)
+		default:
+			stable = append(stable, f)
+			continue
+		}
+		pending++
+	}
+	w.observations = observations
+	return stable, pending
+}
+
+// locked ロックファイル（ファイル名＋接尾辞）があるか
+func (w *Watcher) locked(f *file.File) bool {
+	for _, suffix := range w.source.LockSuffixes {
+		if w.files.Exists(f.Path + suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// recheckDelay 書き込み中のファイルがある場合に再スキャンするまでの時間
+func (w *Watcher) recheckDelay() time.Duration {
+	if *w.source.QuietPeriod > notifyDelay {
+		return *w.source.QuietPeriod
+	}
+	return notifyDelay
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
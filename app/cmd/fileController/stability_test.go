@@ -0,0 +1,54 @@
+package fileController
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+func TestStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	quietPeriod := time.Minute
+	w := NewWatcher(nil, &config.WatchSource{
+		Name:         "test",
+		Path:         dir,
+		QuietPeriod:  &quietPeriod,
+		TempPatterns: []string{"*.tmp"},
+		LockSuffixes: []string{".lock"},
+	}, alert.NewAlerts())
+	if err := os.WriteFile(filepath.Join(dir, "locked.csv.lock"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-time.Hour)
+	fileList := file.Files{
+		{Name: "a.csv", Path: "a.csv", Size: 10, CreatedTime: old},
+		{Name: "b.csv", Path: "b.csv", Size: 10, CreatedTime: now.Add(-time.Second)},
+		{Name: "c.tmp", Path: "c.tmp", Size: 10, CreatedTime: old},
+		{Name: "locked.csv", Path: "locked.csv", Size: 10, CreatedTime: old},
+	}
+
+	// 1回目の走査では変わらないことを確認できない
+	stable, pending := w.stableFiles(fileList, now)
+	if len(stable) != 0 || pending != 3 {
+		t.Errorf("first scan: got %d stable, %d pending, want 0, 3", len(stable), pending)
+	}
+
+	// 2回目の走査でサイズと更新日時が変わらず、静止時間が経過したファイルだけ取り込む
+	stable, pending = w.stableFiles(fileList, now.Add(time.Second))
+	if len(stable) != 1 || stable[0].Path != "a.csv" || pending != 2 {
+		t.Errorf("second scan: got %v stable, %d pending, want a.csv, 2", stable, pending)
+	}
+
+	// サイズが変わったファイルは次の走査まで待つ
+	fileList[0].Size = 20
+	stable, _ = w.stableFiles(fileList, now.Add(2*time.Second))
+	if len(stable) != 0 {
+		t.Errorf("changed file: got %v stable, want none", stable)
+	}
+}
@@ -2,70 +2,630 @@ package fileController
 
 import (
 	"context"
+	"fmt"
+	"io/fs"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"sync"
 	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
 	"ui-backend-for-omotebako-site-controller/app/database"
 	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
 	"ui-backend-for-omotebako-site-controller/config"
 	"ui-backend-for-omotebako-site-controller/pkg"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/xerrors"
 )
 
 var sugar = pkg.NewSugaredLogger()
 
-func Watch(ctx context.Context, list chan<- file.Files, done <-chan bool, db *database.Database, env *config.WatchEnv) {
-	sugar.Info("created watch go routine")
-	// DBから最新のファイルの作成情報を取得する
-	rows, err := db.GetCSVUpdateTransaction(ctx)
-	if err != nil {
-		// log.Errorf("%w", err)
+// notifyDelay 書き込み中の連続したイベントをまとめるため、最後のイベントから待つ時間
+const notifyDelay = 3 * time.Second
+
+// Status 監視元ごとの監視の状態
+type Status struct {
+	Source         string `json:"source"`
+	SiteController string `json:"site_controller"`
+	ImportMode     string `json:"import_mode"`
+	Pattern        string `json:"pattern"`
+	// Include, Exclude 監視対象にする（しない）ファイルのパターン
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// Mode 実際に動作している監視方法（fsnotify, polling）
+	Mode      string `json:"mode"`
+	MountPath string `json:"mount_path"`
+	// FileSystem 監視ディレクトリのファイルシステム（判定できない場合は空）
+	FileSystem string `json:"file_system"`
+	// Reason ポーリングで動作している理由
+	Reason    string    `json:"reason,omitempty"`
+	LastScan  time.Time `json:"last_scan"`
+	LastError string    `json:"last_error,omitempty"`
+	// Paused 自動取込を一時停止している
+	Paused bool `json:"paused"`
+	// PollingInterval 現在のスキャン間隔（分）。Schedulesがある場合は使わない
+	PollingInterval int `json:"polling_interval"`
+	// Schedules スキャンする時刻のcron式。NextScanは次にスキャンする日時
+	Schedules []string  `json:"schedules,omitempty"`
+	NextScan  time.Time `json:"next_scan"`
+	// QuietHours 取込を止める時間帯内のため、検知したファイルを取り込まずに待っている
+	QuietHours bool `json:"quiet_hours"`
+	// MountAvailable 監視ディレクトリにアクセスできる。MountStateは状態（ok、missing、unmounted、stale等）
+	MountAvailable bool   `json:"mount_available"`
+	MountState     string `json:"mount_state"`
+	MountError     string `json:"mount_error,omitempty"`
+	// FilesFound 最後のスキャンで見つかった監視対象のファイルの数
+	FilesFound int `json:"files_found"`
+	// LastArrival 最後に新しいファイルを検知した日時
+	LastArrival time.Time `json:"last_arrival"`
+	// ArrivalOverdue expected_arrivalsの時間内に新しいファイルが届いていない
+	ArrivalOverdue bool   `json:"arrival_overdue"`
+	ArrivalMessage string `json:"arrival_message,omitempty"`
+	// ProcessedFiles 処理済みファイル台帳の件数
+	ProcessedFiles int `json:"processed_files"`
+	// PendingFiles 書き込み中の可能性があり、取込を待っているファイルの数
+	PendingFiles int `json:"pending_files"`
+	// HeldFiles 通知番号の欠番のため、取込を保留しているファイルの数
+	HeldFiles int `json:"held_files"`
+	// LastNotificationNumber 検知したファイルの最大の通知番号
+	LastNotificationNumber int `json:"last_notification_number"`
+}
+
+// Healthy 監視ディレクトリにアクセスできる（まだ確認していない場合も含む）
+func (s Status) Healthy() bool {
+	return s.MountState == "" || s.MountState == MountOK
+}
+
+// ledgerStore 処理済みファイル台帳と取込ジョブの保存先（database.Database）
+type ledgerStore interface {
+	GetProcessedFileKeys(ctx context.Context, source string) (map[string]bool, error)
+	GetLastNotificationNumber(ctx context.Context, source string) (int, error)
+	GetLastArrivalTime(ctx context.Context, source string) (time.Time, error)
+	GetLatestFileCreatedTime(ctx context.Context, source string) (time.Time, error)
+	InsertProcessedFile(ctx context.Context, source string, f *file.File) (int, error)
+	EnqueueDetectedFile(ctx context.Context, source *config.WatchSource, f *file.File) (*models.ImportJob, error)
+}
+
+type Watcher struct {
+	db     ledgerStore
+	source *config.WatchSource
+	alerts *alert.Alerts
+	// files 監視するファイルの取得元
+	files FileSource
+	// location expected_arrivalsの時間帯のタイムゾーン
+	location *time.Location
+	// started ファイルが届いたことがない場合に、expected_arrivalsの期限を数え始める日時
+	started time.Time
+	// schedules スキャンする時刻。ない場合はスキャン間隔ごとにスキャンする
+	schedules []cron.Schedule
+
+	// processed 処理済みファイル台帳のキー
+	processed map[string]bool
+	// hashes サイズと更新日時が変わっていないファイルはハッシュを再計算しない
+	hashes map[string]hashCache
+	// observations 前回のスキャンで見たファイル
+	observations map[string]observation
+	// lastNotification 検知したファイルの最大の通知番号
+	lastNotification int
+	// heldSince 通知番号の欠番のため保留しているファイルと、保留を始めた日時
+	heldSince map[string]time.Time
+	// ranges 未処理のファイルの通知番号の範囲
+	ranges map[string]notificationRange
+	// readNotificationRange ファイルの通知番号の範囲を読み込む
+	readNotificationRange func(csvPath string, siteControllerName string) (int, int, error)
+
+	// mountAlerted 監視ディレクトリにアクセスできないことをアラートにした
+	mountAlerted bool
+	// probe 打ち切った監視ディレクトリへのアクセスの結果
+	probe chan probeResult
+
+	// scanNow 即時スキャンの要求
+	scanNow chan struct{}
+	// intervalChanged スキャン間隔の変更の通知
+	intervalChanged chan struct{}
+
+	mu     sync.RWMutex
+	status Status
+}
+
+type hashCache struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+type Watchers []*Watcher
+
+func NewWatcher(db *database.Database, source *config.WatchSource, alerts *alert.Alerts) *Watcher {
+	return &Watcher{
+		db:              db,
+		source:          source,
+		alerts:          alerts,
+		files:           NewFileSource(source),
+		location:        config.DefaultLocation(),
+		started:         time.Now(),
+		schedules:       parseSchedules(source.Schedules),
+		processed:       map[string]bool{},
+		hashes:          map[string]hashCache{},
+		observations:    map[string]observation{},
+		heldSince:       map[string]time.Time{},
+		ranges:          map[string]notificationRange{},
+		scanNow:         make(chan struct{}, 1),
+		intervalChanged: make(chan struct{}, 1),
+
+		readNotificationRange: database.NotificationNumberRange,
+		status: Status{
+			Source:          source.Name,
+			SiteController:  source.SiteController,
+			ImportMode:      source.ImportMode,
+			Pattern:         source.Pattern,
+			Include:         source.Include,
+			Exclude:         source.Exclude,
+			Mode:            source.WatchMode,
+			MountPath:       source.Path,
+			PollingInterval: source.PollingInterval,
+			Schedules:       source.Schedules,
+		},
 	}
-	var latestFileCreatedTime time.Time
-	if len(rows) > 0 {
-		createdTimeInWindows := rows[0].CreatedTimeInWindows
-		if t, _ := createdTimeInWindows.Value(); t != nil {
-			latestFileCreatedTime = t.(time.Time)
+}
+
+// NewWatchers 監視元ごとにWatcherを作る
+func NewWatchers(db *database.Database, env *config.WatchEnv, alerts *alert.Alerts) Watchers {
+	var watchers Watchers
+	for i := range env.Sources {
+		watcher := NewWatcher(db, &env.Sources[i], alerts)
+		if env.Location != nil {
+			watcher.location = env.Location
 		}
+		watchers = append(watchers, watcher)
 	}
+	return watchers
+}
+
+// Find 名前が一致する監視元のWatcherを返す。ない場合はnil
+func (ws Watchers) Find(name string) *Watcher {
+	for _, w := range ws {
+		if w.source.Name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+func (ws Watchers) Statuses() []Status {
+	statuses := make([]Status, 0, len(ws))
+	for _, w := range ws {
+		statuses = append(statuses, w.Status())
+	}
+	return statuses
+}
+
+func (w *Watcher) Status() Status {
+	w.mu.RLock()
+	status := w.status
+	w.mu.RUnlock()
+	status.QuietHours = w.source.InQuietHours(time.Now().In(w.location))
+	return status
+}
+
+// ScanNow 次のスキャンを待たずにスキャンする。一時停止中でもスキャンする
+func (w *Watcher) ScanNow() {
+	select {
+	case w.scanNow <- struct{}{}:
+	default:
+		// 既に要求済み
+	}
+}
 
-	tickTime := time.Duration(env.PollingInterval) * time.Minute
-	ticker := time.NewTicker(tickTime)
+// Pause 自動取込を一時停止する。一時停止中もScanNowによるスキャンは行う
+func (w *Watcher) Pause() {
+	w.setPaused(true)
+}
+
+// Resume 自動取込を再開し、停止中に置かれたファイルを取り込むためすぐにスキャンする
+func (w *Watcher) Resume() {
+	w.setPaused(false)
+	w.ScanNow()
+}
+
+func (w *Watcher) setPaused(paused bool) {
+	w.mu.Lock()
+	w.status.Paused = paused
+	w.mu.Unlock()
+	sugar.Infof("[%s] auto import paused: %v", w.source.Name, paused)
+}
+
+func (w *Watcher) paused() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.status.Paused
+}
+
+// SetPollingInterval スキャン間隔（分）を変更する。再起動するとPOLLING_INTERVAL（polling_interval）に戻る。
+// schedulesがある監視元はスキャン間隔を使わないため、エラーを返す
+func (w *Watcher) SetPollingInterval(minutes int) error {
+	if minutes <= 0 {
+		return xerrors.Errorf("polling interval should be positive: %d", minutes)
+	}
+	if len(w.schedules) > 0 {
+		return xerrors.Errorf("polling interval is not used because schedules are set: %v", w.source.Schedules)
+	}
+	w.mu.Lock()
+	w.status.PollingInterval = minutes
+	w.mu.Unlock()
+	sugar.Infof("[%s] polling interval: %d minutes", w.source.Name, minutes)
+	select {
+	case w.intervalChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *Watcher) pollingInterval() time.Duration {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return time.Duration(w.status.PollingInterval) * time.Minute
+}
+
+func (w *Watcher) setMode(mode, reason string) {
+	w.mu.Lock()
+	w.status.Mode = mode
+	w.status.Reason = reason
+	w.mu.Unlock()
+	if reason == "" {
+		sugar.Infof("[%s] watch mode: %s, path: %s", w.source.Name, mode, w.source.Path)
+	} else {
+		sugar.Warnf("[%s] watch mode: %s, path: %s, reason: %s", w.source.Name, mode, w.source.Path, reason)
+	}
+}
+
+func (w *Watcher) setScanResult(found, pending, held int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.LastScan = time.Now()
+	w.status.FilesFound = found
+	w.status.ProcessedFiles = len(w.processed)
+	w.status.PendingFiles = pending
+	w.status.HeldFiles = held
+	w.status.LastNotificationNumber = w.lastNotification
+	w.status.LastError = ""
+	if err != nil {
+		w.status.LastError = err.Error()
+	}
+}
+
+// Watch 新しいファイルを取込ジョブに追加し、wakeで通知する。ctxがキャンセルされると終了する
+func (w *Watcher) Watch(ctx context.Context, wake chan<- struct{}) {
+	sugar.Infof("[%s] created watch go routine", w.source.Name)
+	defer w.files.Close()
+	// DBから監視元の処理済みファイル台帳を取得する
+	baseline, ok := w.loadProcessedFiles(ctx)
+	if !ok {
+		sugar.Infof("[%s] finish Watch goroutine", w.source.Name)
+		return
+	}
+
+	// inotifyが使える場合はイベントで、使えない場合はポーリングで監視する。
+	// イベントの取りこぼしに備え、fsnotifyでもPOLLING_INTERVALごとにスキャンする
+	notifier := w.newNotifier()
+	var events <-chan fsnotify.Event
+	var notifyErrors <-chan error
+	if notifier != nil {
+		defer notifier.Close()
+		events, notifyErrors = notifier.Events, notifier.Errors
+	}
+	fallback := func(reason string) {
+		notifier.Close()
+		events, notifyErrors = nil, nil
+		w.setMode(config.WatchModePolling, reason)
+	}
+
+	ticker := time.NewTicker(w.pollingInterval())
 	defer ticker.Stop()
+	// schedulesがある場合は、スキャン間隔ではなくcron式の時刻にスキャンする
+	tick := ticker.C
+	var scheduled *time.Timer
+	if len(w.schedules) > 0 {
+		ticker.Stop()
+		scheduled = time.NewTimer(w.untilNextScan(time.Now()))
+		defer scheduled.Stop()
+		tick = scheduled.C
+	}
+
+	delay := time.NewTimer(notifyDelay)
+	delay.Stop()
+	defer delay.Stop()
+
+	scan := func() {
+		sugar.Infof("[%s] start watch %s", w.source.Name, w.source.Path)
+		defer sugar.Infof("[%s] finish watch %s", w.source.Name, w.source.Path)
+		// ファイルリストの取得
+		// 切断された共有フォルダは空に見えることがあるため、ファイルがないとはみなさない
+		if err := w.checkMount(); err != nil {
+			w.setScanResult(0, 0, 0, xerrors.Errorf("watch directory is not available: %w", err))
+			return
+		}
+		newFileList, found, pending, held, err := w.newFiles(ctx, baseline)
+		// 台帳導入前の基準日時は最初のスキャンだけに使う
+		baseline = time.Time{}
+		if len(newFileList) > 0 {
+			// 取込ジョブを処理するワーカーに通知する
+			sugar.Infof("[%s] queued %d files", w.source.Name, len(newFileList))
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+		w.setScanResult(found, pending, held, err)
+		w.checkArrival(time.Now())
+		if err != nil {
+			sugar.Errorf("[%s] %v", w.source.Name, err)
+		}
+		if pending > 0 {
+			// 書き込み中のファイルは待ってから再スキャンする
+			sugar.Infof("[%s] %d files may be being written, rescan after %v", w.source.Name, pending, w.recheckDelay())
+			delay.Reset(w.recheckDelay())
+		}
+	}
 
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGTERM)
+	// autoScan 一時停止中は自動のスキャンを行わず、監視ディレクトリの状態だけを確認する
+	autoScan := func() {
+		if w.paused() {
+			w.checkMount()
+			return
+		}
+		scan()
+	}
 
 	for {
 		select {
-		case s := <-signalCh:
-			sugar.Infof("received signal: %s", s.String())
+		case <-tick:
+			autoScan()
+			if scheduled != nil {
+				scheduled.Reset(w.untilNextScan(time.Now()))
+			}
+		case <-w.scanNow:
+			scan()
+		case <-w.intervalChanged:
+			ticker.Reset(w.pollingInterval())
+		case event, ok := <-events:
+			if !ok {
+				fallback("fsnotify event channel closed")
+				continue
+			}
+			sugar.Debugf("[%s] fsnotify event: %v", w.source.Name, event)
+			// 作成されたサブディレクトリも監視する
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addRecursive(notifier, event.Name); err != nil {
+						sugar.Warnf("[%s] failed to watch %s: %v", w.source.Name, event.Name, err)
+					}
+				}
+			}
+			delay.Reset(notifyDelay)
+		case <-delay.C:
+			autoScan()
+		case err, ok := <-notifyErrors:
+			if !ok {
+				fallback("fsnotify error channel closed")
+				continue
+			}
+			sugar.Errorf("[%s] fsnotify error: %v", w.source.Name, err)
+			fallback(fmt.Sprintf("fsnotify error: %v", err))
+			// 取りこぼしたイベントの分をスキャンする
+			autoScan()
+		case <-ctx.Done():
+			sugar.Infof("[%s] finish Watch goroutine", w.source.Name)
 			return
-		case <-ticker.C:
-			sugar.Infof("start watch %s", env.MountPath)
-			// ファイルリストの取得
-			sugar.Infof("latest file created time: %v", latestFileCreatedTime)
-			newFileList, err := file.GetFileList(&latestFileCreatedTime, env.MountPath)
-			if err != nil {
-				// log.Errorf("cannot get file list in %v: %v", watchDirPath, err)
-				goto L
+		}
+	}
+}
+
+// loadProcessedFiles 処理済みファイル台帳を読み込む。
+// 台帳が空の場合は、台帳導入前に取り込んだファイルの最新の作成日時を返す。ctxがキャンセルされた場合はfalseを返す
+func (w *Watcher) loadProcessedFiles(ctx context.Context) (time.Time, bool) {
+	for {
+		err := w.loadLedger(ctx)
+		if err == nil {
+			break
+		}
+		// 台帳を読めないまま監視すると全てのファイルを取り込むため、読めるまで待つ
+		sugar.Errorf("[%s] %v", w.source.Name, err)
+		w.setScanResult(0, 0, 0, err)
+		select {
+		case <-time.After(w.pollingInterval()):
+		case <-w.scanNow:
+		case <-ctx.Done():
+			return time.Time{}, false
+		}
+	}
+	if len(w.processed) > 0 {
+		return time.Time{}, true
+	}
+	baseline, err := w.db.GetLatestFileCreatedTime(ctx, w.source.Name)
+	if err != nil {
+		sugar.Errorf("[%s] %v", w.source.Name, err)
+	}
+	if !baseline.IsZero() {
+		sugar.Infof("[%s] processed file ledger is empty, files created until %v are regarded as processed", w.source.Name, baseline)
+	}
+	return baseline, true
+}
+
+// loadLedger 処理済みファイル台帳のキー、最大の通知番号、最後にファイルを検知した日時を読み込む
+func (w *Watcher) loadLedger(ctx context.Context) error {
+	processed, err := w.db.GetProcessedFileKeys(ctx, w.source.Name)
+	if err != nil {
+		return err
+	}
+	lastNotification, err := w.db.GetLastNotificationNumber(ctx, w.source.Name)
+	if err != nil {
+		return err
+	}
+	lastArrival, err := w.db.GetLastArrivalTime(ctx, w.source.Name)
+	if err != nil {
+		return err
+	}
+	w.processed = processed
+	w.lastNotification = lastNotification
+	w.setLastArrival(lastArrival)
+	return nil
+}
+
+// newFiles 書き込みが終わり、台帳にない（パスか内容が新しい）ファイルを台帳と取込ジョブに登録して返す。
+// baselineより前に作成されたファイルは取り込まずに台帳にだけ登録する。
+// 見つかったファイルの数、書き込み中のファイルの数と、通知番号の欠番のため保留したファイルの数も返す
+func (w *Watcher) newFiles(ctx context.Context, baseline time.Time) (file.Files, int, int, int, error) {
+	filter, err := file.NewFilter(w.source.Include, w.source.Exclude, w.source.MaxDepth, w.source.MaxAge)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	fileList, err := w.files.List(filter)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	// 取込後に移動したファイルは取り込まない
+	var candidates file.Files
+	for _, f := range fileList {
+		if !w.archived(f) {
+			candidates = append(candidates, f)
+		}
+	}
+	now := time.Now()
+	fileList, pending := w.stableFiles(candidates, now)
+
+	var unprocessed file.Files
+	var lastErr error
+	seen := map[string]bool{}
+	for _, f := range fileList {
+		seen[f.Path] = true
+		hash, err := w.hash(f)
+		if err != nil {
+			lastErr = xerrors.Errorf("failed to hash %s: %w", f.Path, err)
+			continue
+		}
+		f.Hash = hash
+		if w.processed[database.ProcessedFileKey(f.Path, f.Hash)] {
+			// 前回取得元からの削除（移動）に失敗したファイルは再度削除（移動）する
+			if err := w.files.Complete(f); err != nil {
+				lastErr = err
 			}
+			continue
+		}
+		// SFTPの場合はダウンロードし、通知番号はダウンロードしたファイルから読み込む
+		if err := w.files.Fetch(f); err != nil {
+			// 次のスキャンで再度処理する
+			lastErr = err
+			continue
+		}
+		unprocessed = append(unprocessed, f)
+	}
+	unprocessed, held := w.holdGaps(unprocessed, now)
 
-			if len(newFileList) == 0 {
-				goto L
+	var newFileList file.Files
+	for _, f := range unprocessed {
+		key := database.ProcessedFileKey(f.Path, f.Hash)
+		if !baseline.IsZero() && !f.CreatedTime.After(baseline) {
+			if _, err := w.db.InsertProcessedFile(ctx, w.source.Name, f); err != nil {
+				// 次のスキャンで再度処理する
+				lastErr = err
+				continue
 			}
+			w.processed[key] = true
+			// 取り込まないファイルはダウンロードしたファイルを残さない
+			if w.source.Type == config.SourceTypeSFTP {
+				if err := os.Remove(localFilePath(w.source, f)); err != nil && !os.IsNotExist(err) {
+					lastErr = err
+				}
+			}
+			continue
+		}
+		job, err := w.db.EnqueueDetectedFile(ctx, w.source, f)
+		if err != nil {
+			// 次のスキャンで再度処理する
+			lastErr = err
+			continue
+		}
+		w.processed[key] = true
+		if err := w.files.Complete(f); err != nil {
+			lastErr = err
+		}
+		w.setLastArrival(now)
+		f.LedgerID = job.LedgerID.Int
+		newFileList = append(newFileList, f)
+	}
+	// 削除されたファイルのハッシュは破棄する
+	for path := range w.hashes {
+		if !seen[path] {
+			delete(w.hashes, path)
+		}
+	}
+	return newFileList, len(candidates), pending, held, lastErr
+}
+
+func (w *Watcher) hash(f *file.File) (string, error) {
+	if cache, ok := w.hashes[f.Path]; ok && cache.size == f.Size && cache.modTime.Equal(f.CreatedTime) {
+		return cache.hash, nil
+	}
+	hash, err := w.files.Hash(f)
+	if err != nil {
+		return "", err
+	}
+	w.hashes[f.Path] = hashCache{size: f.Size, modTime: f.CreatedTime, hash: hash}
+	return hash, nil
+}
 
-			// ファイル登録処理へ渡す
-			list <- newFileList
+// newNotifier watch_modeとファイルシステムから監視方法を決める。ポーリングの場合はnilを返す
+func (w *Watcher) newNotifier() *fsnotify.Watcher {
+	if w.source.Type == config.SourceTypeSFTP {
+		w.setMode(config.WatchModePolling, "sftp source does not support inotify")
+		return nil
+	}
+	path := w.source.Path
+	fileSystem, fsErr := fileSystemType(path)
+	w.mu.Lock()
+	w.status.FileSystem = fileSystem
+	w.mu.Unlock()
 
-			// 最新ファイルの更新
-			latestFileCreatedTime = newFileList[0].CreatedTime
-		case <-done:
-			goto END
+	switch w.source.WatchMode {
+	case config.WatchModePolling:
+		w.setMode(config.WatchModePolling, "watch_mode is polling")
+		return nil
+	case config.WatchModeAuto:
+		// FUSE（smbnetfs等）やネットワークファイルシステムではリモートの変更がinotifyで通知されない
+		if fsErr != nil {
+			sugar.Warnf("[%s] failed to detect file system of %s: %v", w.source.Name, path, fsErr)
+		} else if !supportsNotify(fileSystem) {
+			w.setMode(config.WatchModePolling, fmt.Sprintf("file system %s does not support inotify", fileSystem))
+			return nil
 		}
-	L:
-		sugar.Infof("finish watch %s", env.MountPath)
 	}
-END:
-	sugar.Info("finish Watch goroutine")
+
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.setMode(config.WatchModePolling, fmt.Sprintf("failed to create fsnotify watcher: %v", err))
+		return nil
+	}
+	if err := addRecursive(notifier, path); err != nil {
+		notifier.Close()
+		w.setMode(config.WatchModePolling, fmt.Sprintf("failed to watch %s: %v", path, err))
+		return nil
+	}
+	w.setMode(config.WatchModeFsnotify, "")
+	return notifier
+}
+
+// addRecursive inotifyはサブディレクトリを監視しないため、ディレクトリごとに登録する
+func addRecursive(notifier *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return notifier.Add(path)
+	})
 }
@@ -0,0 +1,40 @@
+package fileController
+
+import (
+	"testing"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+func TestWatcherControl(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWatcher(nil, &config.WatchSource{Name: "test", Path: dir, PollingInterval: 5}, alert.NewAlerts())
+	watchers := Watchers{w}
+	if watchers.Find("test") != w || watchers.Find("other") != nil {
+		t.Errorf("Find returned unexpected watcher")
+	}
+
+	if err := w.SetPollingInterval(0); err == nil {
+		t.Errorf("SetPollingInterval(0) should fail")
+	}
+	if err := w.SetPollingInterval(1); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := w.Status().PollingInterval; got != 1 {
+		t.Errorf("polling interval: got %d, want 1", got)
+	}
+
+	w.Pause()
+	if !w.Status().Paused {
+		t.Errorf("watcher should be paused")
+	}
+	w.Resume()
+	if w.Status().Paused {
+		t.Errorf("watcher should be resumed")
+	}
+	// 要求済みの場合もブロックしない
+	w.ScanNow()
+	if len(w.scanNow) != 1 {
+		t.Errorf("scan should be requested")
+	}
+}
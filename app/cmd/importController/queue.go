@@ -0,0 +1,352 @@
+package importController
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/cmd/fileController"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+	"ui-backend-for-omotebako-site-controller/pkg"
+
+	"golang.org/x/xerrors"
+)
+
+var sugar = pkg.NewSugaredLogger()
+
+// ErrClosed 停止中のため取込ジョブを受け付けない
+var ErrClosed = xerrors.New("import queue is closed")
+
+// pollInterval 通知がなくても待機中のジョブを確認する間隔
+const pollInterval = 30 * time.Second
+
+// jobStore 取込ジョブと取込結果の保存先（database.Database）
+type jobStore interface {
+	EnqueueUploadedFile(ctx context.Context, dir string, f *file.File, csvID int, siteControllerName string) (*models.ImportJob, error)
+	GetQueuedImportJobs(ctx context.Context) (models.ImportJobSlice, error)
+	StartImportJob(ctx context.Context, job *models.ImportJob) (bool, error)
+	SetImportJobCsvID(ctx context.Context, job *models.ImportJob, csvID int) error
+	FinishImportJob(ctx context.Context, job *models.ImportJob, importErr error) error
+	RetryImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int, retryAt time.Time) error
+	QuarantineImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int) error
+	GetQuarantinedImportJobs(ctx context.Context) (models.ImportJobSlice, error)
+	GetQuarantinedImportJob(ctx context.Context, id int) (*models.ImportJob, error)
+	ReleaseImportJob(ctx context.Context, id int, restart bool) error
+	DiscardImportJob(ctx context.Context, id int) error
+	InterruptImportJob(ctx context.Context, job *models.ImportJob, next int) error
+	RequeueRunningImportJobs(ctx context.Context) (int64, error)
+	CreateCsvUploadTransaction(ctx context.Context, fileName string, createdTime time.Time, timestamp string, path string, source string, siteControllerName string) (*models.CSVUploadTransaction, error)
+	SetProcessedFileCsvID(ctx context.Context, ledgerID int, csvID int) error
+	RegisterCSVDataToDB(ctx context.Context, file file.File, path string, id int, siteControllerName string, importMode string, start int) (*database.ImportResult, error)
+}
+
+// Queue 取込ジョブ（import_jobs）をワーカーで取り込む。
+// 同じ監視元のジョブは登録順に1つずつ取り込み、異なる監視元のジョブは並行して取り込む。
+// 異なる監視元の取込が同じ在庫を確認する場合は、在庫の行のロックで順番に取り込む
+type Queue struct {
+	db      jobStore
+	sources map[string]*config.WatchSource
+	workers int
+	alerts  *alert.Alerts
+	// location quiet_hours、アーカイブの日付のタイムゾーン
+	location *time.Location
+	// pollInterval 通知がなくても待機中のジョブを確認する間隔
+	pollInterval time.Duration
+	// quarantineAfter, retryInterval ファイル全体の取込に失敗したジョブを隔離する失敗回数と、再試行の間隔
+	quarantineAfter int
+	retryInterval   time.Duration
+
+	// wake 待機中のジョブが追加された（ワーカーが空いた）ことの通知
+	wake chan struct{}
+	jobs chan *models.ImportJob
+
+	mu sync.Mutex
+	// running 実行中のジョブがある監視元
+	running map[string]bool
+	// closed 停止を始めた
+	closed bool
+}
+
+func NewQueue(db *database.Database, env *config.Env, alerts *alert.Alerts) *Queue {
+	return newQueue(db, db.Location, env, alerts)
+}
+
+func newQueue(db jobStore, location *time.Location, env *config.Env, alerts *alert.Alerts) *Queue {
+	sources := map[string]*config.WatchSource{}
+	for i := range env.Sources {
+		sources[env.Sources[i].Name] = &env.Sources[i]
+	}
+	workers := env.ImportWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Queue{
+		db:              db,
+		sources:         sources,
+		workers:         workers,
+		alerts:          alerts,
+		location:        location,
+		pollInterval:    pollInterval,
+		quarantineAfter: env.QuarantineAfter,
+		retryInterval:   env.RetryInterval,
+		wake:            make(chan struct{}, 1),
+		jobs:            make(chan *models.ImportJob, workers),
+		running:         map[string]bool{},
+	}
+}
+
+// Wake 監視で取込ジョブを追加した後に通知するチャネル
+func (q *Queue) Wake() chan<- struct{} {
+	return q.wake
+}
+
+// Notify 待機中のジョブがあることを通知する（ブロックしない）
+func (q *Queue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Accepting 取込ジョブを受け付けているか。停止を始めた後はfalse
+func (q *Queue) Accepting() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return !q.closed
+}
+
+// EnqueueUpload 画面から登録したファイルの取込ジョブを追加する。停止を始めた後はErrClosedを返す
+func (q *Queue) EnqueueUpload(ctx context.Context, dir string, f *file.File, csvID int, siteControllerName string) error {
+	if !q.Accepting() {
+		return ErrClosed
+	}
+	job, err := q.db.EnqueueUploadedFile(ctx, dir, f, csvID, siteControllerName)
+	if err != nil {
+		return err
+	}
+	sugar.Infof("[%s] queued import job %d: %s", job.Source, job.ID, job.FilePath)
+	q.Notify()
+	return nil
+}
+
+// Run 中断したジョブを待機中に戻し、ctxがキャンセルされるまで待機中のジョブをワーカーに渡す。
+// 終了時は実行中のジョブが終わるまで待つ。importCtxをキャンセルすると、実行中の取込を行の区切りで中断し、次の起動時に再開する
+func (q *Queue) Run(ctx context.Context, importCtx context.Context) {
+	if n, err := q.db.RequeueRunningImportJobs(ctx); err != nil {
+		sugar.Error(err)
+	} else if n > 0 {
+		sugar.Infof("resume %d interrupted import jobs", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range q.jobs {
+				q.process(importCtx, job)
+				q.mu.Lock()
+				delete(q.running, job.Source)
+				q.mu.Unlock()
+				q.Notify()
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		q.dispatch(ctx)
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.closed = true
+			q.mu.Unlock()
+			close(q.jobs)
+			wg.Wait()
+			sugar.Info("finish import queue")
+			return
+		}
+	}
+}
+
+// dispatch 実行中のジョブがなく、quiet_hoursの時間帯外の監視元の、最も古い待機中のジョブを空いているワーカーに渡す
+func (q *Queue) dispatch(ctx context.Context) {
+	jobs, err := q.db.GetQueuedImportJobs(ctx)
+	if err != nil {
+		sugar.Error(err)
+		return
+	}
+	now := time.Now().In(q.location)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range jobs {
+		if q.closed || len(q.running) >= q.workers {
+			return
+		}
+		if q.running[job.Source] {
+			continue
+		}
+		// quiet_hoursの間は取り込まず、時間帯が終わってから取り込む
+		if source, ok := q.sources[job.Source]; ok && source.InQuietHours(now) {
+			continue
+		}
+		started, err := q.db.StartImportJob(ctx, job)
+		if err != nil {
+			sugar.Error(err)
+			return
+		}
+		if !started {
+			continue
+		}
+		q.running[job.Source] = true
+		q.jobs <- job
+	}
+}
+
+func (q *Queue) process(ctx context.Context, job *models.ImportJob) {
+	// 停止を始めた後は、まだ始めていないジョブを待機中に戻す
+	if ctx.Err() != nil || !q.Accepting() {
+		if err := q.db.InterruptImportJob(context.Background(), job, job.NextRow); err != nil {
+			sugar.Error(err)
+		}
+		return
+	}
+	sugar.Infof("[%s] start import job %d: %s", job.Source, job.ID, job.FilePath)
+	f := file.File{
+		Name:        job.FileName,
+		CreatedTime: job.CreatedTime,
+		Path:        job.FilePath,
+	}
+	result, err := q.importFile(ctx, job, &f)
+	if err != nil {
+		sugar.Errorf("[%s] import job %d: %v", job.Source, job.ID, err)
+	}
+	// ジョブの記録はキャンセルされないようにする
+	if result != nil && result.Interrupted {
+		if err := q.db.InterruptImportJob(context.Background(), job, result.Next); err != nil {
+			sugar.Error(err)
+		}
+		sugar.Infof("[%s] interrupt import job %d: %s", job.Source, job.ID, job.FilePath)
+		return
+	}
+	// ファイル全体の取込に失敗した場合は、ファイルを移動せずに再試行し、繰り返し失敗したら隔離する
+	if err != nil {
+		q.fail(job, result, err)
+		return
+	}
+	q.archive(job, &f, result, nil)
+	if err := q.db.FinishImportJob(context.Background(), job, nil); err != nil {
+		sugar.Error(err)
+	}
+	sugar.Infof("[%s] finish import job %d: %s", job.Source, job.ID, job.FilePath)
+}
+
+// fail ファイル全体の取込に失敗したジョブを、失敗回数がquarantineAfterになるまで間隔を空けて再試行し、なったら隔離する
+func (q *Queue) fail(job *models.ImportJob, result *database.ImportResult, importErr error) {
+	next := job.NextRow
+	if result != nil && result.Next > next {
+		next = result.Next
+	}
+	failures := job.Failures + 1
+	if failures < q.quarantineAfter {
+		retryAt := time.Now().Add(retryDelay(q.retryInterval, failures))
+		if err := q.db.RetryImportJob(context.Background(), job, importErr, next, retryAt); err != nil {
+			sugar.Error(err)
+		}
+		sugar.Infof("[%s] import job %d failed %d times, retry at %s: %s", job.Source, job.ID, failures, retryAt.Format(time.RFC3339), job.FilePath)
+		return
+	}
+	if err := q.db.QuarantineImportJob(context.Background(), job, importErr, next); err != nil {
+		sugar.Error(err)
+		return
+	}
+	message := fmt.Sprintf("import job %d is quarantined after %d failures: %s: %v", job.ID, failures, job.FilePath, importErr)
+	q.alerts.Publish(alert.Event{Source: job.Source, Kind: alert.KindImportQuarantined, Message: message})
+}
+
+// retryDelay failures回目の失敗の後に再試行するまでの間隔。失敗するごとに長くする
+func retryDelay(interval time.Duration, failures int) time.Duration {
+	return interval * time.Duration(failures)
+}
+
+// archive 取込後のファイルをprocessed/、failed/に移動する（画面から登録したファイルは移動しない）
+func (q *Queue) archive(job *models.ImportJob, f *file.File, result *database.ImportResult, importErr error) {
+	source, ok := q.sources[job.Source]
+	if !ok {
+		return
+	}
+	dest, err := fileController.Archive(source, f, result, importErr, time.Now().In(q.location))
+	if err != nil {
+		sugar.Errorf("[%s] failed to archive %s: %v", job.Source, f.Path, err)
+	} else if dest != "" {
+		sugar.Infof("[%s] archived %s to %s", job.Source, f.Path, dest)
+	}
+}
+
+// Quarantined 隔離したジョブを隔離した順に返す
+func (q *Queue) Quarantined(ctx context.Context) (models.ImportJobSlice, error) {
+	return q.db.GetQuarantinedImportJobs(ctx)
+}
+
+// Release 隔離したジョブを失敗回数を0に戻して再試行する。途中で止まった場合はその行から再開し、
+// restartの場合（ファイルを置き換えた場合）は最初の行から取り込む。隔離したジョブではない場合はdatabase.ErrImportJobNotQuarantinedを返す
+func (q *Queue) Release(ctx context.Context, id int, restart bool) error {
+	job, err := q.db.GetQuarantinedImportJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := q.db.ReleaseImportJob(ctx, id, restart); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("import job %d is released for retry: %s", job.ID, job.FilePath)
+	q.alerts.Publish(alert.Event{Source: job.Source, Kind: alert.KindImportQuarantined, Message: message, Resolved: true})
+	q.Notify()
+	return nil
+}
+
+// Discard 隔離したジョブを破棄し、ファイルを取込に失敗したファイルとしてfailed/に移動する。
+// 隔離したジョブではない場合はdatabase.ErrImportJobNotQuarantinedを返す
+func (q *Queue) Discard(ctx context.Context, id int) error {
+	job, err := q.db.GetQuarantinedImportJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := q.db.DiscardImportJob(ctx, id); err != nil {
+		return err
+	}
+	f := file.File{
+		Name:        job.FileName,
+		CreatedTime: job.CreatedTime,
+		Path:        job.FilePath,
+	}
+	q.archive(job, &f, &database.ImportResult{Failed: true}, xerrors.New(job.LastError.String))
+	message := fmt.Sprintf("import job %d is discarded: %s", job.ID, job.FilePath)
+	q.alerts.Publish(alert.Event{Source: job.Source, Kind: alert.KindImportQuarantined, Message: message, Resolved: true})
+	return nil
+}
+
+func (q *Queue) importFile(ctx context.Context, job *models.ImportJob, f *file.File) (*database.ImportResult, error) {
+	// csv登録...status＝before（再開したジョブは前回のcsv_upload_transactionに記録する）
+	if !job.CSVID.Valid {
+		model, err := q.db.CreateCsvUploadTransaction(ctx, f.Name, f.CreatedTime, "", "", job.Source, job.SiteController)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to insert record to database: %w", err)
+		}
+		if err := q.db.SetImportJobCsvID(ctx, job, model.ID); err != nil {
+			return nil, err
+		}
+		if job.LedgerID.Valid {
+			if err := q.db.SetProcessedFileCsvID(ctx, job.LedgerID.Int, model.ID); err != nil {
+				sugar.Error(err)
+			}
+		}
+	}
+	return q.db.RegisterCSVDataToDB(ctx, *f, job.Dir, job.CSVID.Int, job.SiteController, job.ImportMode, job.NextRow)
+}
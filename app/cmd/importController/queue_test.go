@@ -0,0 +1,480 @@
+package importController
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/volatiletech/null/v8"
+	"golang.org/x/xerrors"
+)
+
+// fakeStore import_jobsをメモリに持つjobStore
+type fakeStore struct {
+	mu     sync.Mutex
+	jobs   map[int]*models.ImportJob
+	nextID int
+	// register ファイル名ごとの取込。nilの場合は成功する
+	register func(ctx context.Context, name string, start int) (*database.ImportResult, error)
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{jobs: map[int]*models.ImportJob{}}
+}
+
+func (s *fakeStore) add(source, name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	s.jobs[s.nextID] = &models.ImportJob{ID: s.nextID, Source: source, FileName: name, FilePath: name, Status: database.ImportJobQueued}
+	return s.nextID
+}
+
+func (s *fakeStore) job(id int) models.ImportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.jobs[id]
+}
+
+func (s *fakeStore) update(id int, f func(job *models.ImportJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.jobs[id])
+}
+
+func (s *fakeStore) EnqueueUploadedFile(ctx context.Context, dir string, f *file.File, csvID int, siteControllerName string) (*models.ImportJob, error) {
+	id := s.add(config.ManualSourceName, f.Name)
+	s.update(id, func(job *models.ImportJob) { job.CSVID = null.IntFrom(csvID) })
+	job := s.job(id)
+	return &job, nil
+}
+
+func (s *fakeStore) GetQueuedImportJobs(ctx context.Context) (models.ImportJobSlice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs models.ImportJobSlice
+	for _, job := range s.jobs {
+		if job.Status == database.ImportJobQueued && (!job.RetryAt.Valid || !job.RetryAt.Time.After(time.Now())) {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func (s *fakeStore) StartImportJob(ctx context.Context, job *models.ImportJob) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.jobs[job.ID]
+	if stored.Status != database.ImportJobQueued {
+		return false, nil
+	}
+	stored.Status = database.ImportJobRunning
+	stored.Attempts++
+	job.Status = database.ImportJobRunning
+	job.Attempts++
+	return true, nil
+}
+
+func (s *fakeStore) SetImportJobCsvID(ctx context.Context, job *models.ImportJob, csvID int) error {
+	s.update(job.ID, func(stored *models.ImportJob) { stored.CSVID = null.IntFrom(csvID) })
+	job.CSVID = null.IntFrom(csvID)
+	return nil
+}
+
+func (s *fakeStore) FinishImportJob(ctx context.Context, job *models.ImportJob, importErr error) error {
+	s.update(job.ID, func(stored *models.ImportJob) {
+		stored.Status = database.ImportJobDone
+		if importErr != nil {
+			stored.Status = database.ImportJobFailed
+		}
+	})
+	return nil
+}
+
+func (s *fakeStore) RetryImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int, retryAt time.Time) error {
+	s.update(job.ID, func(stored *models.ImportJob) {
+		stored.Status = database.ImportJobQueued
+		stored.NextRow = next
+		stored.Failures = job.Failures + 1
+		stored.LastError = null.StringFrom(importErr.Error())
+		stored.RetryAt = null.TimeFrom(retryAt)
+	})
+	return nil
+}
+
+func (s *fakeStore) QuarantineImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int) error {
+	s.update(job.ID, func(stored *models.ImportJob) {
+		stored.Status = database.ImportJobQuarantined
+		stored.NextRow = next
+		stored.Failures = job.Failures + 1
+		stored.LastError = null.StringFrom(importErr.Error())
+		stored.RetryAt = null.Time{}
+	})
+	return nil
+}
+
+func (s *fakeStore) GetQuarantinedImportJobs(ctx context.Context) (models.ImportJobSlice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs models.ImportJobSlice
+	for _, job := range s.jobs {
+		if job.Status == database.ImportJobQuarantined {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+func (s *fakeStore) GetQuarantinedImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.Status != database.ImportJobQuarantined {
+		return nil, database.ErrImportJobNotQuarantined
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (s *fakeStore) ReleaseImportJob(ctx context.Context, id int, restart bool) error {
+	if _, err := s.GetQuarantinedImportJob(ctx, id); err != nil {
+		return err
+	}
+	s.update(id, func(stored *models.ImportJob) {
+		stored.Status = database.ImportJobQueued
+		stored.Failures = 0
+		stored.RetryAt = null.Time{}
+		if restart {
+			stored.NextRow = 0
+			stored.CSVID = null.Int{}
+		}
+	})
+	return nil
+}
+
+func (s *fakeStore) DiscardImportJob(ctx context.Context, id int) error {
+	if _, err := s.GetQuarantinedImportJob(ctx, id); err != nil {
+		return err
+	}
+	s.update(id, func(stored *models.ImportJob) { stored.Status = database.ImportJobDiscarded })
+	return nil
+}
+
+func (s *fakeStore) InterruptImportJob(ctx context.Context, job *models.ImportJob, next int) error {
+	s.update(job.ID, func(stored *models.ImportJob) {
+		stored.Status = database.ImportJobQueued
+		stored.NextRow = next
+	})
+	return nil
+}
+
+func (s *fakeStore) RequeueRunningImportJobs(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, job := range s.jobs {
+		if job.Status == database.ImportJobRunning {
+			job.Status = database.ImportJobQueued
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeStore) CreateCsvUploadTransaction(ctx context.Context, fileName string, createdTime time.Time, timestamp string, path string, source string, siteControllerName string) (*models.CSVUploadTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return &models.CSVUploadTransaction{ID: s.nextID}, nil
+}
+
+func (s *fakeStore) SetProcessedFileCsvID(ctx context.Context, ledgerID int, csvID int) error {
+	return nil
+}
+
+func (s *fakeStore) RegisterCSVDataToDB(ctx context.Context, f file.File, path string, id int, siteControllerName string, importMode string, start int) (*database.ImportResult, error) {
+	if s.register == nil {
+		return &database.ImportResult{}, nil
+	}
+	return s.register(ctx, f.Name, start)
+}
+
+func newTestQueue(store *fakeStore, workers int, sources ...config.WatchSource) *Queue {
+	env := &config.Env{
+		WatchEnv:  &config.WatchEnv{Sources: sources},
+		ImportEnv: &config.ImportEnv{ImportWorkers: workers, QuarantineAfter: 3, RetryInterval: time.Minute},
+	}
+	return newQueue(store, time.UTC, env, alert.NewAlerts())
+}
+
+// dispatched ワーカーに渡したジョブのID
+func dispatched(q *Queue) []int {
+	var ids []int
+	for {
+		select {
+		case job := <-q.jobs:
+			ids = append(ids, job.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	a1 := store.add("a", "a1.csv")
+	a2 := store.add("a", "a2.csv")
+	b1 := store.add("b", "b1.csv")
+	c1 := store.add("c", "c1.csv")
+	q := newTestQueue(store, 2)
+
+	// 同じ監視元のジョブは1つずつ、ワーカーの数まで渡す
+	q.dispatch(ctx)
+	if got := dispatched(q); len(got) != 2 || got[0] != a1 || got[1] != b1 {
+		t.Fatalf("got %v, want [%d %d]", got, a1, b1)
+	}
+	if !q.running["a"] || !q.running["b"] {
+		t.Errorf("got running %v, want a and b", q.running)
+	}
+	q.dispatch(ctx)
+	if got := dispatched(q); len(got) != 0 {
+		t.Errorf("got %v while all workers are busy, want none", got)
+	}
+
+	// 監視元のジョブが終わると、その監視元の次のジョブを渡す
+	store.update(a1, func(job *models.ImportJob) { job.Status = database.ImportJobDone })
+	delete(q.running, "a")
+	q.dispatch(ctx)
+	if got := dispatched(q); len(got) != 1 || got[0] != a2 {
+		t.Errorf("got %v, want [%d]", got, a2)
+	}
+	if store.job(c1).Status != database.ImportJobQueued {
+		t.Errorf("got %s, want c1 queued", store.job(c1).Status)
+	}
+
+	// 停止を始めた後は渡さない
+	delete(q.running, "b")
+	q.closed = true
+	q.dispatch(ctx)
+	if got := dispatched(q); len(got) != 0 {
+		t.Errorf("got %v after close, want none", got)
+	}
+}
+
+func TestDispatchQuietHours(t *testing.T) {
+	store := newFakeStore()
+	quiet := store.add("quiet", "quiet.csv")
+	other := store.add("other", "other.csv")
+	// 終日quiet_hours
+	source := config.WatchSource{Name: "quiet", QuietHours: []config.QuietHours{{From: "00:00", To: "00:00"}}}
+	q := newTestQueue(store, 2, source)
+
+	q.dispatch(context.Background())
+	if got := dispatched(q); len(got) != 1 || got[0] != other {
+		t.Errorf("got %v, want [%d]", got, other)
+	}
+	if store.job(quiet).Status != database.ImportJobQueued {
+		t.Errorf("got %s, want queued", store.job(quiet).Status)
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := newFakeStore()
+	// a1の取込はreleaseを閉じるまで終わらない
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	store.register = func(ctx context.Context, name string, start int) (*database.ImportResult, error) {
+		started <- name
+		if name == "a1.csv" {
+			<-release
+		}
+		return &database.ImportResult{}, nil
+	}
+	a1 := store.add("a", "a1.csv")
+	a2 := store.add("a", "a2.csv")
+	b1 := store.add("b", "b1.csv")
+	q := newTestQueue(store, 2)
+	q.pollInterval = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, context.Background())
+		close(done)
+	}()
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case name := <-started:
+			if name != want {
+				t.Fatalf("got %s, want %s", name, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is not started", want)
+		}
+	}
+	startedNames := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-started:
+			startedNames[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("jobs are not started")
+		}
+	}
+	if !startedNames["a1.csv"] || !startedNames["b1.csv"] {
+		t.Fatalf("got %v, want a1.csv and b1.csv", startedNames)
+	}
+
+	// a1の取込中はa2を取り込まない
+	select {
+	case name := <-started:
+		t.Fatalf("%s is started while a1.csv is running", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	wait("a2.csv")
+
+	// 通知のないジョブはポーリングで取り込む
+	c1 := store.add("c", "c1.csv")
+	wait("c1.csv")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue is not stopped")
+	}
+	for _, id := range []int{a1, a2, b1, c1} {
+		if status := store.job(id).Status; status != database.ImportJobDone {
+			t.Errorf("job %d: got %s, want done", id, status)
+		}
+	}
+	if q.Accepting() {
+		t.Error("queue accepts jobs after stop")
+	}
+}
+
+func TestFail(t *testing.T) {
+	store := newFakeStore()
+	id := store.add("a", "a.csv")
+	q := newTestQueue(store, 1)
+	importErr := xerrors.New("database is unavailable")
+
+	// quarantineAfterになるまでは、途中で止まった行から間隔を空けて再試行する
+	for failures := 1; failures < q.quarantineAfter; failures++ {
+		job := store.job(id)
+		before := time.Now()
+		q.fail(&job, &database.ImportResult{Failed: true, Next: failures}, importErr)
+		got := store.job(id)
+		if got.Status != database.ImportJobQueued || got.Failures != failures || got.NextRow != failures {
+			t.Fatalf("failure %d: got %s, %d failures, next row %d", failures, got.Status, got.Failures, got.NextRow)
+		}
+		if wait := got.RetryAt.Time.Sub(before); wait < retryDelay(q.retryInterval, failures) {
+			t.Errorf("failure %d: retry after %v, want %v", failures, wait, retryDelay(q.retryInterval, failures))
+		}
+		if len(q.alerts.Events()) != 0 {
+			t.Errorf("failure %d: alert is published before quarantine", failures)
+		}
+	}
+
+	// 結果がない（ファイルを読めなかった）場合は、前回の行から再試行する
+	job := store.job(id)
+	q.fail(&job, nil, importErr)
+	got := store.job(id)
+	if got.Status != database.ImportJobQuarantined || got.Failures != q.quarantineAfter || got.NextRow != q.quarantineAfter-1 {
+		t.Fatalf("got %s, %d failures, next row %d, want quarantined", got.Status, got.Failures, got.NextRow)
+	}
+	events := q.alerts.Events()
+	if len(events) != 1 || events[0].Kind != alert.KindImportQuarantined || events[0].Resolved {
+		t.Errorf("got alerts %+v, want a quarantine alert", events)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	quarantined := func(name string) int {
+		id := store.add("a", name)
+		store.update(id, func(job *models.ImportJob) {
+			job.Status = database.ImportJobQuarantined
+			job.Failures = 3
+			job.NextRow = 5
+			job.CSVID = null.IntFrom(100)
+		})
+		return id
+	}
+	resume := quarantined("resume.csv")
+	restart := quarantined("restart.csv")
+	queued := store.add("a", "queued.csv")
+	q := newTestQueue(store, 1)
+
+	// 止まった行から同じcsv_upload_transactionで再開する
+	if err := q.Release(ctx, resume, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.job(resume); got.Status != database.ImportJobQueued || got.Failures != 0 || got.NextRow != 5 || got.CSVID.Int != 100 {
+		t.Errorf("got %s, %d failures, next row %d, csv %v", got.Status, got.Failures, got.NextRow, got.CSVID)
+	}
+	// ファイルを置き換えた場合は最初の行から新しいcsv_upload_transactionで取り込む
+	if err := q.Release(ctx, restart, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.job(restart); got.Status != database.ImportJobQueued || got.NextRow != 0 || got.CSVID.Valid {
+		t.Errorf("got %s, next row %d, csv %v", got.Status, got.NextRow, got.CSVID)
+	}
+	events := q.alerts.Events()
+	if len(events) != 2 || !events[0].Resolved {
+		t.Errorf("got alerts %+v, want resolved alerts", events)
+	}
+	select {
+	case <-q.wake:
+	default:
+		t.Error("queue is not woken up")
+	}
+
+	// 隔離したジョブではない
+	if err := q.Release(ctx, queued, false); !xerrors.Is(err, database.ErrImportJobNotQuarantined) {
+		t.Errorf("got %v, want ErrImportJobNotQuarantined", err)
+	}
+	if err := q.Release(ctx, resume, false); !xerrors.Is(err, database.ErrImportJobNotQuarantined) {
+		t.Errorf("got %v for a released job, want ErrImportJobNotQuarantined", err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	id := store.add("a", "a.csv")
+	store.update(id, func(job *models.ImportJob) { job.Status = database.ImportJobQuarantined })
+	queued := store.add("a", "queued.csv")
+	q := newTestQueue(store, 1)
+
+	if err := q.Discard(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.job(id); got.Status != database.ImportJobDiscarded {
+		t.Errorf("got %s, want discarded", got.Status)
+	}
+	if events := q.alerts.Events(); len(events) != 1 || !events[0].Resolved {
+		t.Errorf("got alerts %+v, want a resolved alert", events)
+	}
+	if err := q.Discard(ctx, queued); !xerrors.Is(err, database.ErrImportJobNotQuarantined) {
+		t.Errorf("got %v, want ErrImportJobNotQuarantined", err)
+	}
+	if got := store.job(queued); got.Status != database.ImportJobQueued {
+		t.Errorf("got %s, want queued", got.Status)
+	}
+}
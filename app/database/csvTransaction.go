@@ -0,0 +1,324 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"golang.org/x/xerrors"
+)
+
+// SourceAuto 監視で検知したファイルの取込結果に絞り込むときの監視元
+const SourceAuto = "auto"
+
+// CsvUploadTransactionFilter 取込結果（csv_upload_transaction）の一覧の絞り込み条件。空の項目では絞り込まない
+type CsvUploadTransactionFilter struct {
+	Statuses []string
+	// Source auto（監視で検知したファイル）、manual（画面から登録したファイル）か監視元の名前
+	Source         string
+	SiteController string
+	// From, To ファイルの作成日時（画面から登録したファイルは登録日時）の範囲（Toは含まない）
+	From time.Time
+	To   time.Time
+	// FileName ファイル名の部分一致
+	FileName string
+}
+
+// CsvUploadTransactionSummary 取込結果と、エラー・警告の行数
+type CsvUploadTransactionSummary struct {
+	*models.CSVUploadTransaction
+	FailedLines int
+	WarnedLines int
+}
+
+// SucceededLines エラーなく取り込んだ（検証した）行数。取り込んだ行数を記録する前の取込結果は不明
+func (s CsvUploadTransactionSummary) SucceededLines() null.Int {
+	if !s.ProcessedLines.Valid {
+		return null.Int{}
+	}
+	succeeded := s.ProcessedLines.Int - s.FailedLines
+	if succeeded < 0 {
+		succeeded = 0
+	}
+	return null.IntFrom(succeeded)
+}
+
+// whereClause 絞り込み条件のWHERE句と引数
+type whereClause struct {
+	clause string
+	args   []interface{}
+}
+
+// whereClauses 絞り込み条件をWHERE句にする
+func (f CsvUploadTransactionFilter) whereClauses() []whereClause {
+	var clauses []whereClause
+	if len(f.Statuses) > 0 {
+		statuses := make([]interface{}, 0, len(f.Statuses))
+		for _, status := range f.Statuses {
+			statuses = append(statuses, status)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+		clauses = append(clauses, whereClause{models.CSVUploadTransactionColumns.Status + " IN (" + placeholders + ")", statuses})
+	}
+	source := models.CSVUploadTransactionColumns.Source
+	switch f.Source {
+	case "":
+	case SourceAuto:
+		// 監視元を記録する前のファイル（source is null）は含めない。画面から登録したものはmisc/sql/013でmanualにする
+		clauses = append(clauses, whereClause{source + " <> ?", []interface{}{config.ManualSourceName}})
+	case config.DefaultWatchSourceName:
+		clauses = append(clauses, whereClause{"(" + source + " = ? or " + source + " is null)", []interface{}{f.Source}})
+	default:
+		clauses = append(clauses, whereClause{source + " = ?", []interface{}{f.Source}})
+	}
+	if f.SiteController != "" {
+		clauses = append(clauses, whereClause{models.CSVUploadTransactionColumns.SiteController + " = ?", []interface{}{f.SiteController}})
+	}
+	// 画面から登録したファイルは登録日時を記録している
+	if !f.From.IsZero() {
+		clauses = append(clauses, whereClause{models.CSVUploadTransactionColumns.CreatedTimeInWindows + " >= ?", []interface{}{f.From}})
+	}
+	if !f.To.IsZero() {
+		clauses = append(clauses, whereClause{models.CSVUploadTransactionColumns.CreatedTimeInWindows + " < ?", []interface{}{f.To}})
+	}
+	if f.FileName != "" {
+		clauses = append(clauses, whereClause{models.CSVUploadTransactionColumns.FileName + " LIKE ?", []interface{}{"%" + escapeLike(f.FileName) + "%"}})
+	}
+	return clauses
+}
+
+func (f CsvUploadTransactionFilter) queryMods() []qm.QueryMod {
+	var mods []qm.QueryMod
+	for _, w := range f.whereClauses() {
+		mods = append(mods, qm.Where(w.clause, w.args...))
+	}
+	return mods
+}
+
+// escapeLike LIKEのワイルドカードを文字として検索する
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// GetCsvUploadTransactions 絞り込んだ取込結果を、ファイルの作成日時（画面から登録したファイルは登録日時）の新しい順にoffset件目からlimit件と、絞り込んだ件数を返す
+func (d *Database) GetCsvUploadTransactions(ctx context.Context, filter CsvUploadTransactionFilter, limit, offset int) ([]CsvUploadTransactionSummary, int64, error) {
+	total, err := models.CSVUploadTransactions(filter.queryMods()...).Count(ctx, d.DB)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("failed to count csv_upload_transaction: %w", err)
+	}
+	mods := append(filter.queryMods(),
+		qm.OrderBy(models.CSVUploadTransactionColumns.CreatedTimeInWindows+" DESC, "+models.CSVUploadTransactionColumns.ID+" DESC"),
+		qm.Limit(limit),
+		qm.Offset(offset),
+	)
+	rows, err := models.CSVUploadTransactions(mods...).All(ctx, d.DB)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("failed to get csv_upload_transaction: %w", err)
+	}
+	summaries, err := d.summarize(ctx, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return summaries, total, nil
+}
+
+// summarize 取込結果ごとに、エラー・警告のある行数を数える
+func (d *Database) summarize(ctx context.Context, rows models.CSVUploadTransactionSlice) ([]CsvUploadTransactionSummary, error) {
+	if len(rows) == 0 {
+		return []CsvUploadTransactionSummary{}, nil
+	}
+	ids := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	failed, err := d.countErrorLines(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	warned, err := d.countWarningLines(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]CsvUploadTransactionSummary, 0, len(rows))
+	for _, row := range rows {
+		summaries = append(summaries, CsvUploadTransactionSummary{
+			CSVUploadTransaction: row,
+			FailedLines:          failed[row.ID],
+			WarnedLines:          warned[row.ID],
+		})
+	}
+	return summaries, nil
+}
+
+// countErrorLines csv_upload_transactionごとのエラーのある行数（1行に複数のエラーがあっても1行と数える）。
+// 中断・異常終了した取込のエラーはcsv_execution_linesにだけ記録されていることがあるため、失敗した行も数える
+func (d *Database) countErrorLines(ctx context.Context, csvIDs []interface{}) (map[int]int, error) {
+	rows, err := models.CSVExecutionErrors(
+		qm.Select(models.CSVExecutionErrorColumns.CSVID, models.CSVExecutionErrorColumns.LineNumber),
+		qm.WhereIn(models.CSVExecutionErrorColumns.CSVID+" IN ?", csvIDs...),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get csv_execution_errors: %w", err)
+	}
+	failedRows, err := models.CSVExecutionLines(
+		qm.Select(models.CSVExecutionLineColumns.CSVID, models.CSVExecutionLineColumns.LineNumber),
+		qm.WhereIn(models.CSVExecutionLineColumns.CSVID+" IN ?", csvIDs...),
+		models.CSVExecutionLineWhere.Result.EQ(LineFailed),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get failed csv_execution_lines: %w", err)
+	}
+	lines := map[int]map[int]bool{}
+	addLine := func(csvID, lineNumber int) {
+		if lines[csvID] == nil {
+			lines[csvID] = map[int]bool{}
+		}
+		lines[csvID][lineNumber] = true
+	}
+	for _, row := range rows {
+		addLine(row.CSVID, row.LineNumber)
+	}
+	for _, row := range failedRows {
+		addLine(row.CSVID, row.LineNumber)
+	}
+	return countLines(lines), nil
+}
+
+// countWarningLines csv_upload_transactionごとの警告のある行数
+func (d *Database) countWarningLines(ctx context.Context, csvIDs []interface{}) (map[int]int, error) {
+	rows, err := models.CSVExecutionWarnings(
+		qm.Select(models.CSVExecutionWarningColumns.CSVID, models.CSVExecutionWarningColumns.LineNumber),
+		qm.WhereIn(models.CSVExecutionWarningColumns.CSVID+" IN ?", csvIDs...),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get csv_execution_warnings: %w", err)
+	}
+	lines := map[int]map[int]bool{}
+	for _, row := range rows {
+		if lines[row.CSVID] == nil {
+			lines[row.CSVID] = map[int]bool{}
+		}
+		lines[row.CSVID][row.LineNumber] = true
+	}
+	return countLines(lines), nil
+}
+
+func countLines(lines map[int]map[int]bool) map[int]int {
+	counts := map[int]int{}
+	for id, numbers := range lines {
+		counts[id] = len(numbers)
+	}
+	return counts
+}
+
+// updateCsvUploadLines ファイルの行数と、取込順で何行目まで取り込んだかを記録する
+func (d *Database) updateCsvUploadLines(ctx context.Context, id int, total, processed int) error {
+	if _, err := models.CSVUploadTransactions(
+		models.CSVUploadTransactionWhere.ID.EQ(id),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.CSVUploadTransactionColumns.TotalLines:     total,
+		models.CSVUploadTransactionColumns.ProcessedLines: processed,
+	}); err != nil {
+		return xerrors.Errorf("failed to update lines of csv_upload_transaction %d: %w", id, err)
+	}
+	return nil
+}
+
+// 行ごとの取込結果（csv_execution_lines.result）
+const (
+	LineSucceeded = "succeeded"
+	LineFailed    = "failed"
+)
+
+// ErrCsvUploadTransactionNotFound 指定した取込結果がない
+var ErrCsvUploadTransactionNotFound = xerrors.New("csv upload transaction is not found")
+
+// newCsvExecutionLine 行の取込結果。iは0始まりの行
+func newCsvExecutionLine(csvID, i int, reservation *scCsv.ReservationData, succeeded bool, reservationID, guestID null.Int) *models.CSVExecutionLine {
+	result := LineSucceeded
+	if !succeeded {
+		result = LineFailed
+	}
+	return &models.CSVExecutionLine{
+		CSVID:         csvID,
+		LineNumber:    i + 1,
+		Notice:        null.StringFrom(reservation.Notice),
+		Result:        result,
+		ReservationID: reservationID,
+		GuestID:       guestID,
+		CustomerName:  null.StringFrom(reservation.Name),
+		CreateDate:    null.TimeFrom(time.Now()),
+	}
+}
+
+// insertCsvExecutionLine 行の取込結果を記録する。途中で止まった取込を再試行した場合は記録し直す
+func insertCsvExecutionLine(ctx context.Context, exec boil.ContextExecutor, line *models.CSVExecutionLine) error {
+	if err := line.Upsert(ctx, exec, boil.Infer(), boil.Infer()); err != nil {
+		return xerrors.Errorf("failed to insert record to csv_execution_lines: line number: %d: %w", line.LineNumber, err)
+	}
+	return nil
+}
+
+// reservationGuestID 予約の顧客のID。取得できない場合はnull
+func reservationGuestID(ctx context.Context, exec boil.ContextExecutor, reservationID int) null.Int {
+	reservation, err := models.Reservations(
+		qm.Select(models.ReservationColumns.ReservationID, models.ReservationColumns.GuestID),
+		qm.Where(models.ReservationColumns.ReservationID+" = ?", reservationID),
+	).One(ctx, exec)
+	if err != nil {
+		sugar.Errorf("failed to get guest of reservation %d: %v", reservationID, err)
+		return null.Int{}
+	}
+	return reservation.GuestID
+}
+
+// GetCsvUploadTransaction 取込結果と、エラー・警告の行数を返す。ない場合はErrCsvUploadTransactionNotFoundを返す
+func (d *Database) GetCsvUploadTransaction(ctx context.Context, id int) (*CsvUploadTransactionSummary, error) {
+	row, err := models.CSVUploadTransactions(
+		models.CSVUploadTransactionWhere.ID.EQ(id),
+	).One(ctx, d.DB)
+	if xerrors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCsvUploadTransactionNotFound
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get csv_upload_transaction %d: %w", id, err)
+	}
+	summaries, err := d.summarize(ctx, models.CSVUploadTransactionSlice{row})
+	if err != nil {
+		return nil, err
+	}
+	return &summaries[0], nil
+}
+
+// GetCsvExecutionLines 取込結果の行ごとの結果、エラー、警告を行番号順に返す
+func (d *Database) GetCsvExecutionLines(ctx context.Context, csvID int) (models.CSVExecutionLineSlice, models.CSVExecutionErrorSlice, models.CSVExecutionWarningSlice, error) {
+	lines, err := models.CSVExecutionLines(
+		models.CSVExecutionLineWhere.CSVID.EQ(csvID),
+		qm.OrderBy(models.CSVExecutionLineColumns.LineNumber),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to get csv_execution_lines of %d: %w", csvID, err)
+	}
+	errors, err := models.CSVExecutionErrors(
+		models.CSVExecutionErrorWhere.CSVID.EQ(csvID),
+		qm.OrderBy(models.CSVExecutionErrorColumns.LineNumber+", "+models.CSVExecutionErrorColumns.ID),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to get csv_execution_errors of %d: %w", csvID, err)
+	}
+	warnings, err := models.CSVExecutionWarnings(
+		models.CSVExecutionWarningWhere.CSVID.EQ(csvID),
+		qm.OrderBy(models.CSVExecutionWarningColumns.LineNumber+", "+models.CSVExecutionWarningColumns.ID),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("failed to get csv_execution_warnings of %d: %w", csvID, err)
+	}
+	return lines, errors, warnings, nil
+}
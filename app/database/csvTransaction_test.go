@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`予約_100%\a.csv`), `予約\_100\%\\a.csv`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSucceededLines(t *testing.T) {
+	tests := []struct {
+		processed null.Int
+		failed    int
+		want      null.Int
+	}{
+		{null.IntFrom(10), 3, null.IntFrom(7)},
+		// 取り込んだ行数を記録する前の取込結果
+		{null.Int{}, 3, null.Int{}},
+		{null.IntFrom(2), 3, null.IntFrom(0)},
+	}
+	for _, tt := range tests {
+		summary := CsvUploadTransactionSummary{
+			CSVUploadTransaction: &models.CSVUploadTransaction{ProcessedLines: tt.processed},
+			FailedLines:          tt.failed,
+		}
+		if got := summary.SucceededLines(); got != tt.want {
+			t.Errorf("processed %v, failed %d: got %v, want %v", tt.processed, tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestWhereClauses(t *testing.T) {
+	from := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 7, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter CsvUploadTransactionFilter
+		want   []whereClause
+	}{
+		{name: "絞り込みなし", filter: CsvUploadTransactionFilter{}, want: nil},
+		{
+			name:   "ステータス",
+			filter: CsvUploadTransactionFilter{Statuses: []string{"complete", "ERROR"}},
+			want:   []whereClause{{"status IN (?,?)", []interface{}{"complete", "ERROR"}}},
+		},
+		{
+			name:   "監視で検知したファイル",
+			filter: CsvUploadTransactionFilter{Source: SourceAuto},
+			want:   []whereClause{{"source <> ?", []interface{}{config.ManualSourceName}}},
+		},
+		{
+			name:   "デフォルトの監視元",
+			filter: CsvUploadTransactionFilter{Source: config.DefaultWatchSourceName},
+			want:   []whereClause{{"(source = ? or source is null)", []interface{}{config.DefaultWatchSourceName}}},
+		},
+		{
+			name:   "画面から登録したファイル",
+			filter: CsvUploadTransactionFilter{Source: config.ManualSourceName, SiteController: "lincoln"},
+			want: []whereClause{
+				{"source = ?", []interface{}{config.ManualSourceName}},
+				{"site_controller = ?", []interface{}{"lincoln"}},
+			},
+		},
+		{
+			name:   "作成日時とファイル名",
+			filter: CsvUploadTransactionFilter{From: from, To: to, FileName: "予約_1"},
+			want: []whereClause{
+				{"created_time_in_windows >= ?", []interface{}{from}},
+				{"created_time_in_windows < ?", []interface{}{to}},
+				{"file_name LIKE ?", []interface{}{`%予約\_1%`}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.whereClauses()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if len(tt.filter.queryMods()) != len(tt.want) {
+				t.Errorf("got %d query mods, want %d", len(tt.filter.queryMods()), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestNewCsvExecutionLine(t *testing.T) {
+	reservation := &scCsv.ReservationData{Notice: "予約", Name: "山田"}
+	line := newCsvExecutionLine(1, 0, reservation, true, null.IntFrom(10), null.IntFrom(20))
+	if line.CSVID != 1 || line.LineNumber != 1 || line.Result != LineSucceeded || line.Notice.String != "予約" || line.CustomerName.String != "山田" || line.ReservationID.Int != 10 || line.GuestID.Int != 20 {
+		t.Errorf("got %+v", line)
+	}
+	if line := newCsvExecutionLine(1, 4, reservation, false, null.Int{}, null.Int{}); line.LineNumber != 5 || line.Result != LineFailed || line.ReservationID.Valid {
+		t.Errorf("got %+v", line)
+	}
+}
+
+func TestExistsCsvExecutionFailures(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	const csvID = 999999003
+	reservation := &scCsv.ReservationData{Notice: "予約", Name: "山田"}
+
+	// 中断前に失敗した行はcsv_execution_linesだけにある
+	line := newCsvExecutionLine(csvID, 1, reservation, true, null.Int{}, null.Int{})
+	if err := insertCsvExecutionLine(ctx, db.DB, line); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		models.CSVExecutionLines(models.CSVExecutionLineWhere.CSVID.EQ(csvID)).DeleteAll(ctx, db.DB)
+	})
+	if exists, err := db.existsCsvExecutionFailures(ctx, csvID); err != nil || exists {
+		t.Fatalf("got %v, %v, want false", exists, err)
+	}
+	failed := newCsvExecutionLine(csvID, 2, reservation, false, null.Int{}, null.Int{})
+	if err := insertCsvExecutionLine(ctx, db.DB, failed); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := db.existsCsvExecutionFailures(ctx, csvID); err != nil || !exists {
+		t.Errorf("got %v, %v, want true", exists, err)
+	}
+}
+
+func TestInsertCsvExecutionRow(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	tx := beginTestTx(t, ctx, db)
+	// csv_execution_warningsはcsv_upload_transactionを参照する
+	transaction := &models.CSVUploadTransaction{FileName: null.StringFrom("test.csv"), Status: null.StringFrom("before")}
+	if err := transaction.Insert(ctx, tx, boil.Infer()); err != nil {
+		t.Fatal(err)
+	}
+	csvID := transaction.ID
+	reservation := &scCsv.ReservationData{Notice: "予約", Name: "山田"}
+	line := newCsvExecutionLine(csvID, 0, reservation, true, null.Int{}, null.Int{})
+	warnings := []WarningStruct{{CustomerName: "山田", WarningMsg: "警告1"}, {CustomerName: "山田", WarningMsg: "警告2"}}
+
+	// 再開して同じ行を記録し直しても警告は重複しない
+	for n := 0; n < 2; n++ {
+		if err := insertCsvExecutionRow(ctx, tx, line, warnings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := models.CSVExecutionWarnings(models.CSVExecutionWarningWhere.CSVID.EQ(csvID)).Count(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d warnings, want 2", count)
+	}
+}
+
+func TestCountErrorLines(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	const csvID = 999999005
+	reservation := &scCsv.ReservationData{Notice: "予約", Name: "山田"}
+	t.Cleanup(func() {
+		models.CSVExecutionLines(models.CSVExecutionLineWhere.CSVID.EQ(csvID)).DeleteAll(ctx, db.DB)
+		models.CSVExecutionErrors(models.CSVExecutionErrorWhere.CSVID.EQ(csvID)).DeleteAll(ctx, db.DB)
+	})
+	// 2行目はエラーと失敗した行の両方、3行目は失敗した行だけを記録した（中断した取込）
+	db.InsertCSVExecutionError(ctx, map[int][]ErrorStruct{1: {{CustomerName: "山田", ErrorMsg: "エラー"}}}, csvID)
+	for _, i := range []int{1, 2} {
+		if err := insertCsvExecutionLine(ctx, db.DB, newCsvExecutionLine(csvID, i, reservation, false, null.Int{}, null.Int{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := insertCsvExecutionLine(ctx, db.DB, newCsvExecutionLine(csvID, 0, reservation, true, null.Int{}, null.Int{})); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.countErrorLines(ctx, []interface{}{csvID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[csvID] != 2 {
+		t.Errorf("got %d error lines, want 2", counts[csvID])
+	}
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"golang.org/x/xerrors"
+)
+
+// 取込ジョブ（import_jobs）のステータス
+const (
+	ImportJobQueued  = "queued"
+	ImportJobRunning = "running"
+	ImportJobDone    = "done"
+	ImportJobFailed  = "failed"
+	// ImportJobQuarantined ファイル全体の取込に繰り返し失敗したため、再試行か破棄されるまで取り込まない
+	ImportJobQuarantined = "quarantined"
+	// ImportJobDiscarded 隔離したジョブを破棄した
+	ImportJobDiscarded = "discarded"
+)
+
+// ErrImportJobNotQuarantined 指定したジョブがないか、隔離したジョブではない
+var ErrImportJobNotQuarantined = xerrors.New("import job is not quarantined")
+
+// EnqueueDetectedFile 監視で検知したファイルを処理済みファイル台帳に登録し、取込ジョブを追加する。
+// 台帳とジョブは同じトランザクションで登録するため、登録後に停止してもファイルは再起動後に取り込まれる
+func (d *Database) EnqueueDetectedFile(ctx context.Context, source *config.WatchSource, f *file.File) (*models.ImportJob, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to begin transaction: %w", err)
+	}
+	ledgerID, err := insertProcessedFile(ctx, tx, source.Name, f)
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			return nil, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+		}
+		return nil, err
+	}
+	job := NewDetectedImportJob(source, f, ledgerID)
+	if err := job.Insert(ctx, tx, boil.Infer()); err != nil {
+		if err := tx.Rollback(); err != nil {
+			return nil, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+		}
+		return nil, xerrors.Errorf("failed to insert import job %s: %w", f.Path, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, xerrors.Errorf("Database Commit is uncompleted: %w", err)
+	}
+	return job, nil
+}
+
+// NewDetectedImportJob 監視で検知したファイルの取込ジョブを作る。
+// ファイルはsource.LocalPath()（sftpの場合はダウンロードしたディレクトリ）から読み込み、サブディレクトリのファイルはそのディレクトリから読み込む
+func NewDetectedImportJob(source *config.WatchSource, f *file.File, ledgerID int) *models.ImportJob {
+	return &models.ImportJob{
+		Source:         source.Name,
+		SiteController: source.SiteController,
+		ImportMode:     source.ImportMode,
+		Dir:            filepath.Join(source.LocalPath(), filepath.Dir(filepath.FromSlash(f.Path))),
+		FileName:       f.Name,
+		FilePath:       f.Path,
+		CreatedTime:    f.CreatedTime,
+		LedgerID:       null.IntFrom(ledgerID),
+		Status:         ImportJobQueued,
+		CreateDate:     null.TimeFrom(time.Now()),
+	}
+}
+
+// EnqueueUploadedFile 画面から登録したファイルの取込ジョブを追加する。csvIDは登録時に作ったcsv_upload_transactionのID
+func (d *Database) EnqueueUploadedFile(ctx context.Context, dir string, f *file.File, csvID int, siteControllerName string) (*models.ImportJob, error) {
+	job := &models.ImportJob{
+		Source:         config.ManualSourceName,
+		SiteController: siteControllerName,
+		ImportMode:     config.ImportModeImport,
+		Dir:            dir,
+		FileName:       f.Name,
+		FilePath:       f.Name,
+		CreatedTime:    f.CreatedTime,
+		CSVID:          null.IntFrom(csvID),
+		Status:         ImportJobQueued,
+		CreateDate:     null.TimeFrom(time.Now()),
+	}
+	if err := job.Insert(ctx, d.DB, boil.Infer()); err != nil {
+		return nil, xerrors.Errorf("failed to insert import job %s: %w", f.Name, err)
+	}
+	return job, nil
+}
+
+// GetQueuedImportJobs 待機中の取込ジョブを登録順に返す。再試行を待っているジョブは再試行日時になるまで返さない
+func (d *Database) GetQueuedImportJobs(ctx context.Context) (models.ImportJobSlice, error) {
+	jobs, err := models.ImportJobs(
+		models.ImportJobWhere.Status.EQ(ImportJobQueued),
+		qm.Expr(
+			models.ImportJobWhere.RetryAt.IsNull(),
+			qm.Or2(models.ImportJobWhere.RetryAt.LTE(null.TimeFrom(time.Now()))),
+		),
+		qm.OrderBy(models.ImportJobColumns.ID),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get queued import jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// StartImportJob 待機中のジョブを実行中にする。他で実行中になっていた場合はfalseを返す
+func (d *Database) StartImportJob(ctx context.Context, job *models.ImportJob) (bool, error) {
+	now := time.Now()
+	n, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+		models.ImportJobWhere.Status.EQ(ImportJobQueued),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:     ImportJobRunning,
+		models.ImportJobColumns.Attempts:   job.Attempts + 1,
+		models.ImportJobColumns.StartedAt:  now,
+		models.ImportJobColumns.UpdateDate: now,
+	})
+	if err != nil {
+		return false, xerrors.Errorf("failed to start import job %d: %w", job.ID, err)
+	}
+	job.Status = ImportJobRunning
+	job.Attempts++
+	job.StartedAt = null.TimeFrom(now)
+	return n == 1, nil
+}
+
+// SetImportJobCsvID ジョブにcsv_upload_transactionを紐付ける。再開した場合は同じcsv_upload_transactionに記録する
+func (d *Database) SetImportJobCsvID(ctx context.Context, job *models.ImportJob, csvID int) error {
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+	).UpdateAll(ctx, d.DB, models.M{models.ImportJobColumns.CSVID: csvID}); err != nil {
+		return xerrors.Errorf("failed to update import job %d: %w", job.ID, err)
+	}
+	job.CSVID = null.IntFrom(csvID)
+	return nil
+}
+
+// FinishImportJob ジョブを完了（失敗）にする
+func (d *Database) FinishImportJob(ctx context.Context, job *models.ImportJob, importErr error) error {
+	now := time.Now()
+	cols := models.M{
+		models.ImportJobColumns.Status:     ImportJobDone,
+		models.ImportJobColumns.LastError:  nil,
+		models.ImportJobColumns.FinishedAt: now,
+		models.ImportJobColumns.UpdateDate: now,
+	}
+	if importErr != nil {
+		cols[models.ImportJobColumns.Status] = ImportJobFailed
+		cols[models.ImportJobColumns.LastError] = importErr.Error()
+	}
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+	).UpdateAll(ctx, d.DB, cols); err != nil {
+		return xerrors.Errorf("failed to finish import job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// RetryImportJob ファイル全体の取込に失敗したジョブの失敗回数を数え、retryAtに再試行する待機中に戻す。
+// nextは次に取り込む行で、途中で失敗した場合はその行から再試行する
+func (d *Database) RetryImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int, retryAt time.Time) error {
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:     ImportJobQueued,
+		models.ImportJobColumns.NextRow:    next,
+		models.ImportJobColumns.Failures:   job.Failures + 1,
+		models.ImportJobColumns.LastError:  importErr.Error(),
+		models.ImportJobColumns.RetryAt:    retryAt,
+		models.ImportJobColumns.UpdateDate: time.Now(),
+	}); err != nil {
+		return xerrors.Errorf("failed to retry import job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// QuarantineImportJob ファイル全体の取込に繰り返し失敗したジョブを隔離する
+func (d *Database) QuarantineImportJob(ctx context.Context, job *models.ImportJob, importErr error, next int) error {
+	now := time.Now()
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:        ImportJobQuarantined,
+		models.ImportJobColumns.NextRow:       next,
+		models.ImportJobColumns.Failures:      job.Failures + 1,
+		models.ImportJobColumns.LastError:     importErr.Error(),
+		models.ImportJobColumns.RetryAt:       nil,
+		models.ImportJobColumns.FinishedAt:    now,
+		models.ImportJobColumns.QuarantinedAt: now,
+		models.ImportJobColumns.UpdateDate:    now,
+	}); err != nil {
+		return xerrors.Errorf("failed to quarantine import job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// GetQuarantinedImportJobs 隔離したジョブを隔離した順に返す
+func (d *Database) GetQuarantinedImportJobs(ctx context.Context) (models.ImportJobSlice, error) {
+	jobs, err := models.ImportJobs(
+		models.ImportJobWhere.Status.EQ(ImportJobQuarantined),
+		qm.OrderBy(models.ImportJobColumns.QuarantinedAt+", "+models.ImportJobColumns.ID),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get quarantined import jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// GetQuarantinedImportJob 隔離したジョブを返す。ない場合はErrImportJobNotQuarantinedを返す
+func (d *Database) GetQuarantinedImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	job, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(id),
+		models.ImportJobWhere.Status.EQ(ImportJobQuarantined),
+	).One(ctx, d.DB)
+	if xerrors.Is(err, sql.ErrNoRows) {
+		return nil, ErrImportJobNotQuarantined
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get import job %d: %w", id, err)
+	}
+	return job, nil
+}
+
+// ReleaseImportJob 隔離したジョブの失敗回数を0に戻し、すぐに再試行する待機中にする。
+// restartの場合（ファイルを置き換えた場合）は、止まった行から再開せずに新しいcsv_upload_transactionで最初の行から取り込む。
+// 隔離したジョブではない場合はErrImportJobNotQuarantinedを返す
+func (d *Database) ReleaseImportJob(ctx context.Context, id int, restart bool) error {
+	cols := models.M{
+		models.ImportJobColumns.Status:        ImportJobQueued,
+		models.ImportJobColumns.Failures:      0,
+		models.ImportJobColumns.RetryAt:       nil,
+		models.ImportJobColumns.QuarantinedAt: nil,
+		models.ImportJobColumns.UpdateDate:    time.Now(),
+	}
+	if restart {
+		cols[models.ImportJobColumns.NextRow] = 0
+		cols[models.ImportJobColumns.CSVID] = nil
+	}
+	n, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(id),
+		models.ImportJobWhere.Status.EQ(ImportJobQuarantined),
+	).UpdateAll(ctx, d.DB, cols)
+	if err != nil {
+		return xerrors.Errorf("failed to release import job %d: %w", id, err)
+	}
+	if n == 0 {
+		return ErrImportJobNotQuarantined
+	}
+	return nil
+}
+
+// DiscardImportJob 隔離したジョブを破棄し、取り込まない。隔離したジョブではない場合はErrImportJobNotQuarantinedを返す
+func (d *Database) DiscardImportJob(ctx context.Context, id int) error {
+	n, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(id),
+		models.ImportJobWhere.Status.EQ(ImportJobQuarantined),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:     ImportJobDiscarded,
+		models.ImportJobColumns.UpdateDate: time.Now(),
+	})
+	if err != nil {
+		return xerrors.Errorf("failed to discard import job %d: %w", id, err)
+	}
+	if n == 0 {
+		return ErrImportJobNotQuarantined
+	}
+	return nil
+}
+
+// InterruptImportJob 中断したジョブを待機中に戻す。nextは次に取り込む行（取込順）で、再開時はその行から取り込む
+func (d *Database) InterruptImportJob(ctx context.Context, job *models.ImportJob, next int) error {
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.ID.EQ(job.ID),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:     ImportJobQueued,
+		models.ImportJobColumns.NextRow:    next,
+		models.ImportJobColumns.UpdateDate: time.Now(),
+	}); err != nil {
+		return xerrors.Errorf("failed to interrupt import job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// advanceImportJob csv_upload_transactionのジョブの再開位置（取込順で次に取り込む行）をnextにする。
+// 行を登録するトランザクションで更新するため、異常終了しても再開位置は登録済みの行と一致する
+func advanceImportJob(ctx context.Context, exec boil.ContextExecutor, csvID int, next int) error {
+	if _, err := models.ImportJobs(
+		models.ImportJobWhere.CSVID.EQ(null.IntFrom(csvID)),
+	).UpdateAll(ctx, exec, models.M{
+		models.ImportJobColumns.NextRow: next,
+	}); err != nil {
+		return xerrors.Errorf("failed to update next row of import job for csv %d: %w", csvID, err)
+	}
+	return nil
+}
+
+// RequeueRunningImportJobs 停止により中断した（実行中のままの）ジョブを待機中に戻し、件数を返す。
+// 異常終了した場合も、next_rowは最後にコミットした行の次になっている
+func (d *Database) RequeueRunningImportJobs(ctx context.Context) (int64, error) {
+	n, err := models.ImportJobs(
+		models.ImportJobWhere.Status.EQ(ImportJobRunning),
+	).UpdateAll(ctx, d.DB, models.M{
+		models.ImportJobColumns.Status:     ImportJobQueued,
+		models.ImportJobColumns.UpdateDate: time.Now(),
+	})
+	if err != nil {
+		return 0, xerrors.Errorf("failed to requeue running import jobs: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"golang.org/x/xerrors"
+)
+
+// insertTestImportJob テスト用の取込ジョブを登録する
+func insertTestImportJob(t *testing.T, ctx context.Context, exec boil.ContextExecutor, csvID int, status string) *models.ImportJob {
+	t.Helper()
+	job := &models.ImportJob{
+		Source:         "test",
+		SiteController: "lincoln",
+		ImportMode:     "import",
+		Dir:            "/tmp",
+		FileName:       "test.csv",
+		FilePath:       "test.csv",
+		CreatedTime:    time.Now(),
+		Status:         status,
+		CreateDate:     null.TimeFrom(time.Now()),
+	}
+	if csvID > 0 {
+		job.CSVID = null.IntFrom(csvID)
+	}
+	if err := job.Insert(ctx, exec, boil.Infer()); err != nil {
+		t.Fatalf("failed to insert import job: %v", err)
+	}
+	return job
+}
+
+func TestAdvanceImportJob(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	tx := beginTestTx(t, ctx, db)
+	const csvID = 999999001
+	job := insertTestImportJob(t, ctx, tx, csvID, ImportJobRunning)
+	other := insertTestImportJob(t, ctx, tx, 0, ImportJobRunning)
+
+	if err := advanceImportJob(ctx, tx, csvID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Reload(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if job.NextRow != 3 {
+		t.Errorf("got next row %d, want 3", job.NextRow)
+	}
+	// csv_upload_transactionの異なるジョブは更新しない
+	if err := other.Reload(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+	if other.NextRow != 0 {
+		t.Errorf("got next row %d of other job, want 0", other.NextRow)
+	}
+}
+
+func TestQuarantineImportJob(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	job := insertTestImportJob(t, ctx, db.DB, 999999002, ImportJobRunning)
+	t.Cleanup(func() { job.Delete(ctx, db.DB) })
+	reload := func() {
+		t.Helper()
+		if err := job.Reload(ctx, db.DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	importErr := xerrors.New("database is unavailable")
+
+	// 再試行する待機中に戻す
+	retryAt := time.Now().Add(time.Minute)
+	if err := db.RetryImportJob(ctx, job, importErr, 4, retryAt); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if job.Status != ImportJobQueued || job.Failures != 1 || job.NextRow != 4 || !job.RetryAt.Valid {
+		t.Fatalf("got %s, %d failures, next row %d, retry at %v", job.Status, job.Failures, job.NextRow, job.RetryAt)
+	}
+
+	// 隔離する
+	if err := db.QuarantineImportJob(ctx, job, importErr, 5); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if job.Status != ImportJobQuarantined || job.Failures != 2 || job.NextRow != 5 || job.RetryAt.Valid || !job.QuarantinedAt.Valid {
+		t.Fatalf("got %s, %d failures, next row %d, retry at %v", job.Status, job.Failures, job.NextRow, job.RetryAt)
+	}
+	if _, err := db.GetQuarantinedImportJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 止まった行から再開する
+	if err := db.ReleaseImportJob(ctx, job.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if job.Status != ImportJobQueued || job.Failures != 0 || job.NextRow != 5 || job.CSVID.Int != 999999002 {
+		t.Errorf("got %s, %d failures, next row %d, csv %v", job.Status, job.Failures, job.NextRow, job.CSVID)
+	}
+	if err := db.ReleaseImportJob(ctx, job.ID, false); !xerrors.Is(err, ErrImportJobNotQuarantined) {
+		t.Errorf("got %v, want ErrImportJobNotQuarantined", err)
+	}
+
+	// 最初の行から新しいcsv_upload_transactionで取り込む
+	if err := db.QuarantineImportJob(ctx, job, importErr, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReleaseImportJob(ctx, job.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if job.Status != ImportJobQueued || job.NextRow != 0 || job.CSVID.Valid {
+		t.Errorf("got %s, next row %d, csv %v", job.Status, job.NextRow, job.CSVID)
+	}
+
+	// 破棄する
+	if err := db.DiscardImportJob(ctx, job.ID); !xerrors.Is(err, ErrImportJobNotQuarantined) {
+		t.Errorf("got %v, want ErrImportJobNotQuarantined", err)
+	}
+	if err := db.QuarantineImportJob(ctx, job, importErr, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DiscardImportJob(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	reload()
+	if job.Status != ImportJobDiscarded {
+		t.Errorf("got %s, want discarded", job.Status)
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"golang.org/x/xerrors"
+)
+
+// roomUsage 予約の部屋タイプ別・日別の利用室数
+type roomUsage struct {
+	RoomType      string
+	StayDate      time.Time
+	NumberOfRooms int
+}
+
+// overbooking 在庫を超えた部屋タイプ・日付
+type overbooking struct {
+	roomUsage
+	RemainingRooms int
+}
+
+type RoomInventory struct {
+	RoomType      string    `json:"room_type"`
+	StayDate      time.Time `json:"stay_date"`
+	NumberOfRooms int       `json:"number_of_rooms"`
+}
+
+func roomUsages(reservation *scCsv.ReservationData, location *time.Location) ([]roomUsage, error) {
+	// 利用日・部屋タイプ・室数の組（1～12）
+	breakdown := []struct {
+		useOfDay     int
+		roomType     string
+		numberOfRoom int
+	}{
+		{int(reservation.UseOfDay1), reservation.RoomType1, int(reservation.NumberOfRoom1)},
+		{int(reservation.UseOfDay2), reservation.RoomType2, int(reservation.NumberOfRoom2)},
+		{int(reservation.UseOfDay3), reservation.RoomType3, int(reservation.NumberOfRoom3)},
+		{int(reservation.UseOfDay4), reservation.RoomType4, int(reservation.NumberOfRoom4)},
+		{int(reservation.UseOfDay5), reservation.RoomType5, int(reservation.NumberOfRoom5)},
+		{int(reservation.UseOfDay6), reservation.RoomType6, int(reservation.NumberOfRoom6)},
+		{int(reservation.UseOfDay7), reservation.RoomType7, int(reservation.NumberOfRoom7)},
+		{int(reservation.UseOfDay8), reservation.RoomType8, int(reservation.NumberOfRoom8)},
+		{int(reservation.UseOfDay9), reservation.RoomType9, int(reservation.NumberOfRoom9)},
+		{int(reservation.UseOfDay10), reservation.RoomType10, int(reservation.NumberOfRoom10)},
+		{int(reservation.UseOfDay11), reservation.RoomType11, int(reservation.NumberOfRoom11)},
+		{int(reservation.UseOfDay12), reservation.RoomType12, int(reservation.NumberOfRoom12)},
+	}
+
+	var usages []roomUsage
+	for _, b := range breakdown {
+		if b.useOfDay == 0 || b.roomType == "" || b.numberOfRoom == 0 {
+			continue
+		}
+		stayDate, err := time.ParseInLocation("20060102", strconv.Itoa(b.useOfDay), location)
+		if err != nil {
+			sugar.Errorf("failed to parse use of day %d: %v", b.useOfDay, err)
+			return nil, importerror.New(importerror.CodeInvalidUseOfDay, "UseOfDay", map[string]string{"value": strconv.Itoa(b.useOfDay)})
+		}
+		// 同じ部屋タイプ・日付は合算する
+		merged := false
+		for i := range usages {
+			if usages[i].RoomType == b.roomType && usages[i].StayDate.Equal(stayDate) {
+				usages[i].NumberOfRooms += b.numberOfRoom
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			usages = append(usages, roomUsage{
+				RoomType:      b.roomType,
+				StayDate:      stayDate,
+				NumberOfRooms: b.numberOfRoom,
+			})
+		}
+	}
+	// 在庫の行をロックする順番を揃え、並行する取込でデッドロックしないようにする
+	sort.Slice(usages, func(i, j int) bool {
+		if !usages[i].StayDate.Equal(usages[j].StayDate) {
+			return usages[i].StayDate.Before(usages[j].StayDate)
+		}
+		return usages[i].RoomType < usages[j].RoomType
+	})
+	return usages, nil
+}
+
+// checkRoomInventory 在庫を超える部屋タイプ・日付を返す。
+// 在庫と利用室数はロックして読み、並行する取込が同じ在庫を確認して両方とも在庫内と判定しないようにする
+func checkRoomInventory(usages []roomUsage, ctx context.Context, tx *sql.Tx) ([]overbooking, error) {
+	var overbookings []overbooking
+	for _, usage := range usages {
+		inventory, err := models.RoomInventories(
+			models.RoomInventoryWhere.RoomType.EQ(usage.RoomType),
+			models.RoomInventoryWhere.StayDate.EQ(usage.StayDate),
+			qm.For("UPDATE"),
+		).One(ctx, tx)
+		if err != nil {
+			if xerrors.Is(err, sql.ErrNoRows) {
+				// 在庫が登録されていない部屋タイプ・日付はチェックしない
+				sugar.Infof("no room inventory, room type: %s, stay date: %s", usage.RoomType, usage.StayDate.Format("2006-01-02"))
+				continue
+			}
+			return nil, err
+		}
+
+		rooms, err := models.ReservationRooms(
+			models.ReservationRoomWhere.RoomType.EQ(usage.RoomType),
+			models.ReservationRoomWhere.StayDate.EQ(usage.StayDate),
+			// 他の取込がコミットした利用室数を読む
+			qm.For("UPDATE"),
+		).All(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		booked := 0
+		for _, room := range rooms {
+			booked += room.NumberOfRooms
+		}
+
+		remaining := inventory.NumberOfRooms - booked
+		if usage.NumberOfRooms > remaining {
+			overbookings = append(overbookings, overbooking{
+				roomUsage:      usage,
+				RemainingRooms: remaining,
+			})
+		}
+	}
+	return overbookings, nil
+}
+
+func insertReservationRooms(reservationID int, usages []roomUsage, ctx context.Context, tx *sql.Tx) error {
+	for _, usage := range usages {
+		newReservationRoom := models.ReservationRoom{
+			ReservationID: reservationID,
+			RoomType:      usage.RoomType,
+			StayDate:      usage.StayDate,
+			NumberOfRooms: usage.NumberOfRooms,
+		}
+		if err := newReservationRoom.Insert(ctx, tx, boil.Infer()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertOverbookings(reservationID int, overbookings []overbooking, ctx context.Context, tx *sql.Tx) error {
+	currentTime := time.Now()
+	for _, o := range overbookings {
+		newOverbooking := models.Overbooking{
+			ReservationID:  reservationID,
+			RoomType:       o.RoomType,
+			StayDate:       o.StayDate,
+			RequestedRooms: o.NumberOfRooms,
+			RemainingRooms: o.RemainingRooms,
+			CreateDate:     null.TimeFrom(currentTime),
+		}
+		if err := newOverbooking.Insert(ctx, tx, boil.Infer()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReservationRooms キャンセルされた予約の利用室数とオーバーブッキングを削除する
+func deleteReservationRooms(reservationID int, ctx context.Context, tx *sql.Tx) error {
+	if _, err := models.ReservationRooms(
+		models.ReservationRoomWhere.ReservationID.EQ(reservationID),
+	).DeleteAll(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := models.Overbookings(
+		models.OverbookingWhere.ReservationID.EQ(reservationID),
+	).DeleteAll(ctx, tx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Database) GetRoomInventories(ctx context.Context, from, to time.Time) (models.RoomInventorySlice, error) {
+	rows, err := models.RoomInventories(
+		models.RoomInventoryWhere.StayDate.GTE(from),
+		models.RoomInventoryWhere.StayDate.LTE(to),
+		qm.OrderBy(models.RoomInventoryColumns.StayDate+", "+models.RoomInventoryColumns.RoomType),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (d *Database) UpsertRoomInventories(ctx context.Context, inventories []RoomInventory) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to begin transaction: %w", err)
+	}
+	currentTime := time.Now()
+	for _, inventory := range inventories {
+		record := models.RoomInventory{
+			RoomType:      inventory.RoomType,
+			StayDate:      inventory.StayDate,
+			NumberOfRooms: inventory.NumberOfRooms,
+			UpdateDate:    null.TimeFrom(currentTime),
+		}
+		if err := record.Upsert(ctx, tx, boil.Whitelist(
+			models.RoomInventoryColumns.NumberOfRooms,
+			models.RoomInventoryColumns.UpdateDate,
+		), boil.Infer()); err != nil {
+			if err := tx.Rollback(); err != nil {
+				return xerrors.Errorf("Rolleback is uncompleted: %w", err)
+			}
+			return xerrors.Errorf("failed to upsert room inventory: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("Database Commit is uncompleted: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetOverbookings(ctx context.Context, from, to time.Time) (models.OverbookingSlice, error) {
+	rows, err := models.Overbookings(
+		models.OverbookingWhere.StayDate.GTE(from),
+		models.OverbookingWhere.StayDate.LTE(to),
+		qm.OrderBy(models.OverbookingColumns.StayDate+", "+models.OverbookingColumns.RoomType),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
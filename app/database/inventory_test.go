@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+func TestRoomUsages(t *testing.T) {
+	reservation := &scCsv.ReservationData{
+		UseOfDay1: 20300102, RoomType1: "和室", NumberOfRoom1: 1,
+		UseOfDay2: 20300101, RoomType2: "洋室", NumberOfRoom2: 2,
+		UseOfDay3: 20300101, RoomType3: "和室", NumberOfRoom3: 1,
+		UseOfDay4: 20300102, RoomType4: "和室", NumberOfRoom4: 2,
+	}
+	location := time.FixedZone("JST", 9*60*60)
+	usages, err := roomUsages(reservation, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じ部屋タイプ・日付は合算し、日付、部屋タイプの順に並べる
+	want := []string{"2030-01-01 和室 1", "2030-01-01 洋室 2", "2030-01-02 和室 3"}
+	if len(usages) != len(want) {
+		t.Fatalf("got %v, want %v", usages, want)
+	}
+	for i, u := range usages {
+		if got := fmt.Sprintf("%s %s %d", u.StayDate.Format("2006-01-02"), u.RoomType, u.NumberOfRooms); got != want[i] {
+			t.Errorf("%d: got %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestCheckRoomInventory(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	tx := beginTestTx(t, ctx, db)
+	stayDate := time.Date(2030, 1, 1, 0, 0, 0, 0, db.Location)
+	prefix := fmt.Sprintf("test-%d-", time.Now().UnixNano())
+
+	tests := []struct {
+		name string
+		// inventory 在庫室数（-1は在庫の登録なし）
+		inventory int
+		booked    int
+		requested int
+		// remaining 在庫を超える場合の残室数
+		overbooked bool
+		remaining  int
+	}{
+		{name: "available", inventory: 3, booked: 1, requested: 2},
+		{name: "full", inventory: 3, booked: 3, requested: 1, overbooked: true, remaining: 0},
+		{name: "overbooked", inventory: 3, booked: 4, requested: 1, overbooked: true, remaining: -1},
+		{name: "no inventory", inventory: -1, booked: 5, requested: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomType := prefix + tt.name
+			if tt.inventory >= 0 {
+				inventory := models.RoomInventory{RoomType: roomType, StayDate: stayDate, NumberOfRooms: tt.inventory}
+				if err := inventory.Insert(ctx, tx, boil.Infer()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := insertReservationRooms(0, []roomUsage{{RoomType: roomType, StayDate: stayDate, NumberOfRooms: tt.booked}}, ctx, tx); err != nil {
+				t.Fatal(err)
+			}
+
+			overbookings, err := checkRoomInventory([]roomUsage{{RoomType: roomType, StayDate: stayDate, NumberOfRooms: tt.requested}}, ctx, tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.overbooked {
+				if len(overbookings) != 0 {
+					t.Errorf("got %v, want no overbooking", overbookings)
+				}
+				return
+			}
+			if len(overbookings) != 1 {
+				t.Fatalf("got %v, want 1 overbooking", overbookings)
+			}
+			if o := overbookings[0]; o.NumberOfRooms != tt.requested || o.RemainingRooms != tt.remaining {
+				t.Errorf("got requested %d, remaining %d, want %d, %d", o.NumberOfRooms, o.RemainingRooms, tt.requested, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestInsertOverbookings(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	tx := beginTestTx(t, ctx, db)
+	reservationID := -int(time.Now().Unix() % 1000000)
+	stayDate := time.Date(2030, 1, 1, 0, 0, 0, 0, db.Location)
+	overbookings := []overbooking{
+		{roomUsage: roomUsage{RoomType: "和室", StayDate: stayDate, NumberOfRooms: 2}, RemainingRooms: 1},
+		{roomUsage: roomUsage{RoomType: "洋室", StayDate: stayDate, NumberOfRooms: 1}, RemainingRooms: -1},
+	}
+	if err := insertOverbookings(reservationID, overbookings, ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := models.Overbookings(
+		models.OverbookingWhere.ReservationID.EQ(reservationID),
+		qm.OrderBy(models.OverbookingColumns.ID),
+	).All(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(overbookings) {
+		t.Fatalf("got %d overbookings, want %d", len(rows), len(overbookings))
+	}
+	for i, row := range rows {
+		o := overbookings[i]
+		if row.RoomType != o.RoomType || row.RequestedRooms != o.NumberOfRooms || row.RemainingRooms != o.RemainingRooms || !row.CreateDate.Valid {
+			t.Errorf("%d: got %+v, want %+v", i, row, o)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"golang.org/x/xerrors"
+)
+
+// ProcessedFileKey 処理済みファイル台帳のキー（パスと内容のハッシュ）
+func ProcessedFileKey(path, hash string) string {
+	return path + "\x00" + hash
+}
+
+// GetProcessedFileKeys 監視元の処理済みファイルのキーを返す
+func (d *Database) GetProcessedFileKeys(ctx context.Context, source string) (map[string]bool, error) {
+	rows, err := models.ProcessedFiles(
+		qm.Select(models.ProcessedFileColumns.Path, models.ProcessedFileColumns.ContentHash),
+		models.ProcessedFileWhere.Source.EQ(source),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get processed files of %s: %w", source, err)
+	}
+	keys := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		keys[ProcessedFileKey(row.Path, row.ContentHash)] = true
+	}
+	return keys, nil
+}
+
+// InsertProcessedFile ファイルを処理済みとして台帳に登録し、IDを返す
+func (d *Database) InsertProcessedFile(ctx context.Context, source string, f *file.File) (int, error) {
+	return insertProcessedFile(ctx, d.DB, source, f)
+}
+
+func insertProcessedFile(ctx context.Context, exec boil.ContextExecutor, source string, f *file.File) (int, error) {
+	record := models.ProcessedFile{
+		Source:      source,
+		Path:        f.Path,
+		ContentHash: f.Hash,
+		Size:        f.Size,
+		ModTime:     f.CreatedTime,
+		CreateDate:  null.TimeFrom(time.Now()),
+	}
+	if f.LastNotificationNumber > 0 {
+		record.FirstNotificationNumber = null.IntFrom(f.FirstNotificationNumber)
+		record.LastNotificationNumber = null.IntFrom(f.LastNotificationNumber)
+	}
+	if err := record.Insert(ctx, exec, boil.Infer()); err != nil {
+		return 0, xerrors.Errorf("failed to insert processed file %s: %w", f.Path, err)
+	}
+	return record.ID, nil
+}
+
+// SetProcessedFileCsvID 台帳のファイルに取込（csv_upload_transaction）を紐付ける
+func (d *Database) SetProcessedFileCsvID(ctx context.Context, id int, csvID int) error {
+	if _, err := models.ProcessedFiles(
+		models.ProcessedFileWhere.ID.EQ(id),
+	).UpdateAll(ctx, d.DB, models.M{models.ProcessedFileColumns.CSVID: csvID}); err != nil {
+		return xerrors.Errorf("failed to update processed file %d: %w", id, err)
+	}
+	return nil
+}
+
+// GetLastNotificationNumber 監視元で検知したファイルの最大の通知番号。ない場合は0を返す
+func (d *Database) GetLastNotificationNumber(ctx context.Context, source string) (int, error) {
+	row, err := models.ProcessedFiles(
+		qm.Select(models.ProcessedFileColumns.ID, models.ProcessedFileColumns.LastNotificationNumber),
+		models.ProcessedFileWhere.Source.EQ(source),
+		models.ProcessedFileWhere.LastNotificationNumber.IsNotNull(),
+		qm.OrderBy(models.ProcessedFileColumns.LastNotificationNumber+" DESC"),
+	).One(ctx, d.DB)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, xerrors.Errorf("failed to get last notification number of %s: %w", source, err)
+	}
+	return row.LastNotificationNumber.Int, nil
+}
+
+// GetLastArrivalTime 監視元で最後にファイルを検知した日時。ない場合はゼロ値を返す
+func (d *Database) GetLastArrivalTime(ctx context.Context, source string) (time.Time, error) {
+	row, err := models.ProcessedFiles(
+		qm.Select(models.ProcessedFileColumns.ID, models.ProcessedFileColumns.CreateDate),
+		models.ProcessedFileWhere.Source.EQ(source),
+		models.ProcessedFileWhere.CreateDate.IsNotNull(),
+		qm.OrderBy(models.ProcessedFileColumns.CreateDate+" DESC"),
+	).One(ctx, d.DB)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, xerrors.Errorf("failed to get last arrival time of %s: %w", source, err)
+	}
+	return row.CreateDate.Time, nil
+}
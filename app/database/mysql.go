@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"time"
 	"ui-backend-for-omotebako-site-controller/config"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -9,7 +10,10 @@ import (
 )
 
 type Database struct {
-	DB *sql.DB
+	DB        *sql.DB
+	ImportEnv *config.ImportEnv
+	// 施設のタイムゾーン。取込データの日付の解釈とAPIレスポンスに使う
+	Location *time.Location
 }
 
 func NewDatabase(mysqlEnv *config.MysqlEnv) (*Database, error) {
@@ -20,7 +24,13 @@ func NewDatabase(mysqlEnv *config.MysqlEnv) (*Database, error) {
 	if err = db.Ping(); err != nil {
 		return nil, xerrors.Errorf(`failed to connection database: %w`, err)
 	}
+	location := mysqlEnv.Location
+	if location == nil {
+		location = config.DefaultLocation()
+	}
 	return &Database{
-		DB: db,
+		DB:        db,
+		ImportEnv: &config.ImportEnv{},
+		Location:  location,
 	}, nil
 }
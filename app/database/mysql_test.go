@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"ui-backend-for-omotebako-site-controller/config"
+)
+
+// newTestDatabase MYSQL_*の環境変数のデータベースに接続する。接続できない場合はテストをスキップする
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	db, err := NewDatabase(config.NewMysqlEnv(nil))
+	if err != nil {
+		t.Skipf("database is not available: %v", err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+// beginTestTx テストの終了時にロールバックするトランザクションを始める
+func beginTestTx(t *testing.T, ctx context.Context, db *Database) *sql.Tx {
+	t.Helper()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"sort"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+
+	"golang.org/x/xerrors"
+)
+
+var errUnavailableSiteController = xerrors.New("site controller name is not available")
+
+// readReservations サイトコントローラーごとの形式でファイルを読み込む
+func readReservations(csvPath string, siteControllerName string) ([]*scCsv.ReservationData, error) {
+	switch siteControllerName {
+	case "xxxx":
+		return scCsv.ImportFromLincoln(csvPath)
+	case "xxxx":
+		// TODO xxxx用のインポート関数を作る
+		return scCsv.ImportFromLincoln(csvPath)
+	case "xxxx":
+		// TODO xxxx用のインポート関数を作る
+		return scCsv.ImportFromLincoln(csvPath)
+	default:
+		return nil, xerrors.Errorf("site controller name '%s': %w", siteControllerName, errUnavailableSiteController)
+	}
+}
+
+// NotificationNumberRange ファイル内の通知番号の最小・最大を返す。通知番号がない場合は0
+func NotificationNumberRange(csvPath string, siteControllerName string) (int, int, error) {
+	reservations, err := readReservations(csvPath, siteControllerName)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("path: %s, failed to read notification numbers: %w", csvPath, err)
+	}
+	first, last := 0, 0
+	for _, reservation := range reservations {
+		n := reservation.NotificationNumber
+		if n <= 0 {
+			continue
+		}
+		if first == 0 || n < first {
+			first = n
+		}
+		if n > last {
+			last = n
+		}
+	}
+	return first, last, nil
+}
+
+// processingOrder 行を取り込む順番（行のインデックス）を返す。
+// 通知番号のある行は、それらの行の位置の中で通知番号順に並び替え、通知番号のない行は位置を変えない
+func processingOrder(reservations []*scCsv.ReservationData) []int {
+	order := make([]int, len(reservations))
+	var numbered []int
+	for i, reservation := range reservations {
+		order[i] = i
+		if reservation.NotificationNumber > 0 {
+			numbered = append(numbered, i)
+		}
+	}
+	sorted := append([]int(nil), numbered...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return reservations[sorted[a]].NotificationNumber < reservations[sorted[b]].NotificationNumber
+	})
+	for k, i := range numbered {
+		order[i] = sorted[k]
+	}
+	return order
+}
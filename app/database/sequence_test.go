@@ -0,0 +1,20 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+)
+
+func TestProcessingOrder(t *testing.T) {
+	numbers := []int{12, 0, 10, 11, 0}
+	var reservations []*scCsv.ReservationData
+	for _, n := range numbers {
+		reservations = append(reservations, &scCsv.ReservationData{NotificationNumber: n})
+	}
+
+	// 通知番号のない行（1, 4）は位置を変えず、通知番号のある行を番号順にする
+	if got, want := processingOrder(reservations), []int{2, 1, 3, 0, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
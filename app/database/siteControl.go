@@ -3,13 +3,16 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
 	"ui-backend-for-omotebako-site-controller/app/file"
 	"ui-backend-for-omotebako-site-controller/app/helper"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
 	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
 	"ui-backend-for-omotebako-site-controller/pkg"
 
 	"github.com/volatiletech/null/v8"
@@ -21,6 +24,10 @@ import (
 type reservationGuest struct {
 	ReservationID xxx
 	GuestID       xxx
+	// StayDateFrom, StayDateTo 宿泊期間。Cancelledは同じファイルの後の行で取り消した
+	StayDateFrom time.Time
+	StayDateTo   time.Time
+	Cancelled    bool
 	*scCsv.ReservationData
 }
 
@@ -28,68 +35,335 @@ type ErrorStruct struct {
 	CustomerName        string
 	CustomerPhoneNumber string
 	ErrorMsg            string
+	Code                importerror.Code
+	Field               string
+	Params              map[string]string
+}
+
+type WarningStruct struct {
+	CustomerName        string
+	CustomerPhoneNumber string
+	WarningMsg          string
+	Code                importerror.Code
+	Field               string
+	Params              map[string]string
 }
 
 var sugar = pkg.NewSugaredLogger()
 
-func (d *Database) TransactionReservationInfo(reservations []*scCsv.ReservationData, ctx context.Context) (map[int]ErrorStruct, error) {
-	var reservationGuests []*reservationGuest
-	errorMap := map[int]ErrorStruct{}
+// newErrorStructs 取込エラーを行のエラーに変換する。コードのないエラーはUNKNOWNにする
+func newErrorStructs(reservation *scCsv.ReservationData, err error) []ErrorStruct {
+	var list importerror.List
+	var importErr *importerror.ImportError
+	if !xerrors.As(err, &list) {
+		if xerrors.As(err, &importErr) {
+			list = importerror.List{importErr}
+		} else {
+			list = importerror.List{importerror.New(importerror.CodeUnknown, "", map[string]string{"detail": err.Error()})}
+		}
+	}
+
+	var errors []ErrorStruct
+	for _, e := range list {
+		errors = append(errors, ErrorStruct{
+			CustomerName:        reservation.ReservationHolder,
+			CustomerPhoneNumber: reservation.ReservationHolderPhoneNumber,
+			ErrorMsg:            e.Message(importerror.Japanese),
+			Code:                e.Code,
+			Field:               e.Field,
+			Params:              e.Params,
+		})
+	}
+	return errors
+}
+
+func newWarningStruct(reservation *scCsv.ReservationData, warning *importerror.ImportError) WarningStruct {
+	return WarningStruct{
+		CustomerName:        reservation.ReservationHolder,
+		CustomerPhoneNumber: reservation.ReservationHolderPhoneNumber,
+		WarningMsg:          warning.Message(importerror.Japanese),
+		Code:                warning.Code,
+		Field:               warning.Field,
+		Params:              warning.Params,
+	}
+}
 
-	for i, reservation := range reservations {
+// TransactionReservationInfo 1行ずつトランザクションで登録する。dryRunの場合は検証のみ行い、ロールバックする
+func (d *Database) TransactionReservationInfo(reservations []*scCsv.ReservationData, siteControllerName string, dryRun bool, ctx context.Context) (map[int][]ErrorStruct, map[int][]WarningStruct, error) {
+	errorMap, warningMap, _, err := d.transactionReservationInfo(reservations, siteControllerName, dryRun, 0, 0, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return errorMap, warningMap, nil
+}
+
+// transactionReservationInfo 取込順でstart番目の行から登録し、次に登録する行の順番を返す。
+// csvIDがある場合は行ごとの取込結果と警告を記録し、記録できなかった警告だけを返す。
+// ctxがキャンセルされた場合は、登録中の行をロールバックし、それまでのエラー・警告とキャンセルのエラーを返す
+func (d *Database) transactionReservationInfo(reservations []*scCsv.ReservationData, siteControllerName string, dryRun bool, start int, csvID int, ctx context.Context) (map[int][]ErrorStruct, map[int][]WarningStruct, int, error) {
+	errorMap := map[int][]ErrorStruct{}
+	warningMap := map[int][]WarningStruct{}
+	interrupted := func(tx *sql.Tx, next int) (map[int][]ErrorStruct, map[int][]WarningStruct, int, error) {
+		if tx != nil {
+			// キャンセルされたトランザクションはロールバック済み
+			_ = tx.Rollback()
+		}
+		return errorMap, warningMap, next, ctx.Err()
+	}
+
+	// 通知番号順に取り込む。エラー・警告は元の行に記録する
+	order := processingOrder(reservations)
+	// 再開した場合は、中断前に取り込んだ行から同じファイルで登録・取消した予約を復元する
+	reservationGuests, err := d.resumedReservationGuests(ctx, reservations, order, start, csvID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interrupted(nil, start)
+		}
+		return nil, nil, start, err
+	}
+	for k := start; k < len(order); k++ {
+		if ctx.Err() != nil {
+			return interrupted(nil, k)
+		}
+		i := order[k]
+		reservation := reservations[i]
 		tx, err := d.DB.BeginTx(ctx, nil)
 		if err != nil {
-			return nil, xerrors.Errorf("failed to begin transaction: %w", err)
+			if ctx.Err() != nil {
+				return interrupted(nil, k)
+			}
+			return nil, nil, k, xerrors.Errorf("failed to begin transaction: %w", err)
+		}
+		// 電話番号・郵便番号・メールアドレスの正規化。不正な値は警告にする
+		var rowWarnings []WarningStruct
+		for _, warning := range normalizeReservationData(reservation) {
+			rowWarnings = append(rowWarnings, newWarningStruct(reservation, warning))
+		}
+		rules := d.ImportEnv.ValidationRules.Rules(siteControllerName, reservation.Notice)
+		// rowLine 行の取込結果。登録・取消した予約と顧客を記録する（検証のみの場合は予約を登録していない）
+		rowLine := func(succeeded bool, reservationID, guestID null.Int) *models.CSVExecutionLine {
+			if csvID == 0 {
+				return nil
+			}
+			if dryRun {
+				reservationID, guestID = null.Int{}, null.Int{}
+			}
+			return newCsvExecutionLine(csvID, i, reservation, succeeded, reservationID, guestID)
 		}
 		switch reservation.Notice {
-		case "予約":
-			reservationGuest, err := d.addReservationInfoToDB(reservation, tx, ctx)
+		case config.NoticeReservation:
+			reservationGuest, warnings, err := d.addReservationInfoToDB(reservation, reservationGuests, rules, tx, ctx)
 			if err != nil {
-				errorMap[i] = ErrorStruct{
-					CustomerName:        reservation.ReservationHolder,
-					CustomerPhoneNumber: reservation.ReservationHolderPhoneNumber,
-					ErrorMsg:            fmt.Sprint(err),
+				if ctx.Err() != nil {
+					return interrupted(tx, k)
 				}
+				errorMap[i] = newErrorStructs(reservation, err)
 				if err := tx.Rollback(); err != nil {
-					return nil, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+					return nil, nil, k, xerrors.Errorf("Rolleback is uncompleted: %w", err)
 				}
-				continue
+				break
+			}
+			for _, warning := range warnings {
+				rowWarnings = append(rowWarnings, newWarningStruct(reservation, warning))
 			}
-			if err := tx.Commit(); err != nil {
-				return nil, xerrors.Errorf("Database Commit is uncompleted: %w", err)
+			line := rowLine(true, null.IntFrom(reservationGuest.ReservationID), null.IntFrom(reservationGuest.GuestID))
+			if err := d.commitRow(ctx, tx, dryRun, line, rowWarnings, k+1); err != nil {
+				if ctx.Err() != nil {
+					return interrupted(nil, k)
+				}
+				return nil, nil, k, err
 			}
 			reservationGuests = append(reservationGuests, reservationGuest)
-		case "取消":
-			err := deleteReservationInfoFromDB(reservation, reservationGuests, tx, ctx)
+		case config.NoticeCancel:
+			targetID, err := deleteReservationInfoFromDB(reservation, reservationGuests, rules, tx, ctx)
 			if err != nil {
-				errorMap[i] = ErrorStruct{
-					CustomerName:        reservation.ReservationHolder,
-					CustomerPhoneNumber: reservation.ReservationHolderPhoneNumber,
-					ErrorMsg:            fmt.Sprint(err),
+				if ctx.Err() != nil {
+					return interrupted(tx, k)
 				}
+				errorMap[i] = newErrorStructs(reservation, err)
 				if err := tx.Rollback(); err != nil {
-					return nil, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+					return nil, nil, k, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+				}
+				break
+			}
+			var reservationID, guestID null.Int
+			if targetID > 0 {
+				reservationID, guestID = null.IntFrom(targetID), reservationGuestID(ctx, tx, targetID)
+				// 取り消した予約は、後の行の重複予約の確認に含めない
+				for _, reservationGuest := range reservationGuests {
+					if reservationGuest.ReservationID == targetID {
+						reservationGuest.Cancelled = true
+					}
 				}
-				continue
 			}
-			if err := tx.Commit(); err != nil {
-				return nil, xerrors.Errorf("Database Commit is uncompleted: %w", err)
+			if err := d.commitRow(ctx, tx, dryRun, rowLine(true, reservationID, guestID), rowWarnings, k+1); err != nil {
+				if ctx.Err() != nil {
+					return interrupted(nil, k)
+				}
+				return nil, nil, k, err
 			}
 		default:
-			errorMap[i] = ErrorStruct{
-				CustomerName:        reservation.ReservationHolder,
-				CustomerPhoneNumber: reservation.ReservationHolderPhoneNumber,
-				ErrorMsg:            fmt.Sprintf("unknown reservation type :%v", reservation.Notice),
+			errorMap[i] = newErrorStructs(reservation, importerror.New(importerror.CodeUnknownNotice, "Notice", map[string]string{"notice": reservation.Notice}))
+			if err := tx.Rollback(); err != nil {
+				return nil, nil, k, xerrors.Errorf("Rolleback is uncompleted: %w", err)
+			}
+		}
+		// 取込結果を記録しない場合は警告を返す。中断した行の警告は再開時に記録する
+		if csvID == 0 && len(rowWarnings) > 0 {
+			warningMap[i] = rowWarnings
+		}
+		// エラーの行はロールバックした後に記録する。再開した場合は記録し直す
+		if line := rowLine(false, null.Int{}, null.Int{}); line != nil && errorMap[i] != nil {
+			if err := d.recordRow(ctx, line, rowWarnings); err != nil {
+				if ctx.Err() != nil {
+					// 行のエラー・警告は中断時に記録する
+					if len(rowWarnings) > 0 {
+						warningMap[i] = rowWarnings
+					}
+					return interrupted(nil, k+1)
+				}
+				return nil, nil, k, err
 			}
 		}
 	}
 
+	if len(warningMap) == 0 {
+		warningMap = nil
+	}
+
 	// エラーが１件でも存在したらその情報を返す
 	if len(errorMap) != 0 {
-		return errorMap, nil
+		return errorMap, warningMap, len(order), nil
 	}
 
-	return nil, nil
+	return nil, warningMap, len(order), nil
+}
+
+// resumedReservationGuests 取込順でstart番目より前の行のうち、取込に成功した行の予約を返す。
+// 行はDBに登録せず正規化だけ行い、取消の行で取り消した予約はCancelledにする
+func (d *Database) resumedReservationGuests(ctx context.Context, reservations []*scCsv.ReservationData, order []int, start int, csvID int) ([]*reservationGuest, error) {
+	if start == 0 || csvID == 0 {
+		return nil, nil
+	}
+	lines, err := models.CSVExecutionLines(
+		models.CSVExecutionLineWhere.CSVID.EQ(csvID),
+		models.CSVExecutionLineWhere.Result.EQ(LineSucceeded),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get csv_execution_lines of %d: %w", csvID, err)
+	}
+	succeeded := map[int]*models.CSVExecutionLine{}
+	for _, line := range lines {
+		succeeded[line.LineNumber] = line
+	}
+	return restoreReservationGuests(reservations, order[:start], succeeded, d.Location), nil
+}
+
+// restoreReservationGuests 取込順orderの行のうち、succeeded（行番号ごとの成功した行の取込結果）にある行の予約を返す
+func restoreReservationGuests(reservations []*scCsv.ReservationData, order []int, succeeded map[int]*models.CSVExecutionLine, location *time.Location) []*reservationGuest {
+	var reservationGuests []*reservationGuest
+	for _, i := range order {
+		reservation := reservations[i]
+		// 正規化の警告は中断前に記録済み
+		normalizeReservationData(reservation)
+		line, ok := succeeded[i+1]
+		if !ok {
+			continue
+		}
+		switch reservation.Notice {
+		case config.NoticeReservation:
+			stayDateFrom, err := checkInTime(reservation, location)
+			if err != nil {
+				continue
+			}
+			stayDateTo, err := time.ParseInLocation("20060102", reservation.StayDateTo, location)
+			if err != nil {
+				continue
+			}
+			reservationGuests = append(reservationGuests, &reservationGuest{
+				ReservationID:   line.ReservationID.Int,
+				GuestID:         line.GuestID.Int,
+				StayDateFrom:    stayDateFrom,
+				StayDateTo:      stayDateTo,
+				ReservationData: reservation,
+			})
+		case config.NoticeCancel:
+			if !line.ReservationID.Valid {
+				continue
+			}
+			for _, reservationGuest := range reservationGuests {
+				if reservationGuest.ReservationID == line.ReservationID.Int {
+					reservationGuest.Cancelled = true
+				}
+			}
+		}
+	}
+	return reservationGuests
+}
+
+// commitRow 行を登録したトランザクションをコミットする（dryRunの場合はロールバックし、行の取込結果と警告だけを記録する）。
+// lineがある場合は同じトランザクションで行の取込結果と警告を記録してジョブの再開位置をnextにし、
+// 異常終了後に再開しても登録済みの行を再び登録せず、取込結果も失わないようにする
+func (d *Database) commitRow(ctx context.Context, tx *sql.Tx, dryRun bool, line *models.CSVExecutionLine, warnings []WarningStruct, next int) error {
+	if dryRun {
+		if err := tx.Rollback(); err != nil {
+			return xerrors.Errorf("Rolleback is uncompleted: %w", err)
+		}
+		if line != nil {
+			return d.recordRow(ctx, line, warnings)
+		}
+		return nil
+	}
+	if line != nil {
+		if err := insertCsvExecutionRow(ctx, tx, line, warnings); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := advanceImportJob(ctx, tx, line.CSVID, next); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("Database Commit is uncompleted: %w", err)
+	}
+	return nil
+}
+
+// recordRow ロールバックした行（エラーの行、検証のみの行）の取込結果と警告を1つのトランザクションで記録する
+func (d *Database) recordRow(ctx context.Context, line *models.CSVExecutionLine, warnings []WarningStruct) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := insertCsvExecutionRow(ctx, tx, line, warnings); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("Database Commit is uncompleted: %w", err)
+	}
+	return nil
+}
+
+// insertCsvExecutionRow 行の取込結果と警告を記録する。再開して同じ行を記録し直す場合は警告を入れ替える
+func insertCsvExecutionRow(ctx context.Context, exec boil.ContextExecutor, line *models.CSVExecutionLine, warnings []WarningStruct) error {
+	if err := insertCsvExecutionLine(ctx, exec, line); err != nil {
+		return err
+	}
+	if _, err := models.CSVExecutionWarnings(
+		models.CSVExecutionWarningWhere.CSVID.EQ(line.CSVID),
+		models.CSVExecutionWarningWhere.LineNumber.EQ(line.LineNumber),
+	).DeleteAll(ctx, exec); err != nil {
+		return xerrors.Errorf("failed to delete csv_execution_warnings of line %d: %w", line.LineNumber, err)
+	}
+	for _, warning := range warnings {
+		newCSVExecutionWarning := newCsvExecutionWarning(line.CSVID, line.LineNumber-1, warning)
+		if err := newCSVExecutionWarning.Insert(ctx, exec, boil.Infer()); err != nil {
+			return xerrors.Errorf("failed to insert csv_execution_warnings of line %d: %w", line.LineNumber, err)
+		}
+	}
+	return nil
 }
 
 func (d *Database) GetCsvExecutionErrorsWithCsvUploadTransactionByStatus(ctx context.Context, status int) (models.CSVExecutionErrorSlice, error) {
@@ -104,6 +378,26 @@ func (d *Database) GetCsvExecutionErrorsWithCsvUploadTransactionByStatus(ctx con
 	return rows, nil
 }
 
+func (d *Database) GetCsvExecutionWarningsWithCsvUploadTransactionByStatus(ctx context.Context, status int) (models.CSVExecutionWarningSlice, error) {
+	rows, err := models.CSVExecutionWarnings(
+		models.CSVExecutionWarningWhere.Status.EQ(status),
+		qm.Load(models.CSVExecutionWarningRels.CSV),
+	).All(ctx, d.DB)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (d *Database) UpdateCsvExecutionWarningsStatus(ctx context.Context, from, to int) error {
+	if _, err := models.CSVExecutionWarnings(
+		models.CSVExecutionWarningWhere.Status.EQ(from),
+	).UpdateAll(ctx, d.DB, models.M{models.CSVExecutionWarningColumns.Status: to}); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (d *Database) GetCsvUploadTransactionByTimeStamp(ctx context.Context, timestamp string) (models.CSVUploadTransactionSlice, error) {
 	queries_csv_transaction := []qm.QueryMod{
 		qm.Where(models.CSVUploadTransactionColumns.Timestamp+"=?", timestamp),
@@ -125,7 +419,8 @@ func (d *Database) GetCsvExecutionErrorsByStatus(ctx context.Context, status int
 	return rows, nil
 }
 
-func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx *sql.Tx, ctx context.Context) (*reservationGuest, error) {
+// addReservationInfoToDB 予約を登録する。reservationGuestsは同じファイルの前の行で登録した予約
+func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, rules []config.ValidationRule, tx *sql.Tx, ctx context.Context) (*reservationGuest, []*importerror.ImportError, error) {
 	currentTime := time.Now()
 	newReservationGuest := reservationGuest{
 		//ReservationID:   0,
@@ -133,50 +428,127 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 		ReservationData: reservation,
 	}
 
-	stayDateFrom, err := checkInTime(reservation)
+	stayDateFrom, err := checkInTime(reservation, d.Location)
 	if err != nil {
 		sugar.Errorf("failed to parse reservationDate: %v\n", err)
 		// エラーメッセージ：チェックイン日エラー
-		return &newReservationGuest, fmt.Errorf("チェックイン日が不正か入力されていません。")
+		return &newReservationGuest, nil, importerror.New(importerror.CodeInvalidCheckInDate, "StayDateFrom", nil)
 	}
 
-	stayDateTo, err := time.Parse("20060102", reservation.StayDateTo)
+	stayDateTo, err := time.ParseInLocation("20060102", reservation.StayDateTo, d.Location)
 	if err != nil {
 		sugar.Errorf("failed to parse stayDateTo: %v\n", err)
 		// エラーメッセージ：チェックアウト日エラー
-		return &newReservationGuest, fmt.Errorf("チェックアウト日が不正か入力されていません。")
+		return &newReservationGuest, nil, importerror.New(importerror.CodeInvalidCheckOutDate, "StayDateTo", nil)
 	}
 
-	reservationDate, err := time.Parse("20060102", reservation.ReservatioinDate)
+	reservationDate, err := time.ParseInLocation("20060102", reservation.ReservatioinDate, d.Location)
 	if err != nil {
 		sugar.Errorf("failed to parse reservationDate: %v\n", err)
 		// エラーメッセージ：予約受信日エラー
-		return &newReservationGuest, fmt.Errorf("予約受信日が不正か入力されていません。")
+		return &newReservationGuest, nil, importerror.New(importerror.CodeInvalidReservationDate, "ReservatioinDate", nil)
 	}
 
 	reservationMethodId, err := checkReservationMethod(reservation, ctx, tx)
 	if err != nil {
 		sugar.Errorf("invalid reservation method: %v", err)
 		// エラーメッセージ：予約経路エラー
-		return &newReservationGuest, fmt.Errorf("予約経路が不正か入力されていません。")
+		return &newReservationGuest, nil, importerror.New(importerror.CodeInvalidReservationMethod, "SalesAgentShopName", nil)
 	}
 
+	if Results := validateReservationData(reservation, rules); Results != nil {
+		sugar.Errorf("validation reservation data error: %v", Results)
+		//	エラーメッセージ：validation エラー（違反したルールごと）
+		return &newReservationGuest, nil, Results
+	}
+
+	// 取込は続けるが、推測・欠落した内容は警告にする
+	var warnings []*importerror.ImportError
+
 	paymentMethodId, err := checkPaymentMethod(reservation.PaymentMethodName, ctx, tx)
 	if err != nil {
 		sugar.Errorf("failed to insert payment method error: %v", err)
+		paymentMethodName := reservation.PaymentMethodName
+		if paymentMethodName == "" {
+			paymentMethodName = defaultPaymentMethodName
+		}
+		warnings = append(warnings, importerror.New(importerror.CodePaymentMethodFailed, "PaymentMethodName", map[string]string{"payment_method": paymentMethodName}))
 	}
 
 	planId := checkProductMaster(reservation.ProductCode, reservation.ProductName, ctx, tx)
+	if planId == nil && reservation.ProductCode != "" {
+		sugar.Warnf("product not found, product code: %s, product name: %s", reservation.ProductCode, reservation.ProductName)
+		warnings = append(warnings, importerror.New(importerror.CodeProductNotFound, "ProductCode", map[string]string{
+			"product_code": reservation.ProductCode,
+			"product_name": reservation.ProductName,
+		}))
+	}
 
-	if Results := validateReservationData(reservation); Results != nil {
-		sugar.Errorf("validation reservation data error: %v", Results)
-		//	エラーメッセージ：validation エラー
-		ResultsStr := fmt.Sprint(strings.Join(Results, ", "))
-		return &newReservationGuest, fmt.Errorf("必要な項目が入力されていません。: %v", ResultsStr)
+	counts := newGuestCount(reservation)
+	// 検証ルールで合計を照合した場合は警告しない
+	if !hasSumRule(rules, "NumberOfGuests") {
+		if warning := checkGuestCount(reservation, counts); warning != nil {
+			sugar.Warnf("guest count mismatch: %v", warning)
+			warnings = append(warnings, warning)
+		}
+	}
+	// お客様総合計人数は連携された値を使い、未入力の場合は内訳の合計にする
+	numberOfGuests := reservation.NumberOfGuests
+	if numberOfGuests == 0 {
+		numberOfGuests = counts.Total
+	}
+
+	usages, err := roomUsages(reservation, d.Location)
+	if err != nil {
+		sugar.Errorf("failed to parse room usages: %v", err)
+		// エラーメッセージ：利用日エラー
+		return &newReservationGuest, nil, err
+	}
+
+	// 在庫チェック：オーバーブッキングは警告として取り込む
+	overbookings, err := checkRoomInventory(usages, ctx, tx)
+	if err != nil {
+		sugar.Errorf("failed to check room inventory: %v", err)
+		// エラーメッセージ：在庫確認エラー
+		return &newReservationGuest, nil, importerror.New(importerror.CodeRoomInventoryCheckFailed, "", nil)
+	}
+	for _, o := range overbookings {
+		sugar.Warnf("overbooking room type: %s, stay date: %s, requested: %d, remaining: %d", o.RoomType, o.StayDate.Format("2006-01-02"), o.NumberOfRooms, o.RemainingRooms)
+		warnings = append(warnings, importerror.New(importerror.CodeOverbooking, "RoomType", map[string]string{
+			"room_type":       o.RoomType,
+			"stay_date":       o.StayDate.Format("2006/01/02"),
+			"requested_rooms": strconv.Itoa(o.NumberOfRooms),
+			"remaining_rooms": strconv.Itoa(o.RemainingRooms),
+		}))
 	}
 
 	guest := checkNewGuest(reservation, ctx, tx)
 
+	// 宿泊期間が重複する予約（OTAの再送や複数経路からの二重予約の疑い）
+	var guestID int
+	if guest != nil {
+		guestID = guest.GuestID
+	}
+	overlaps, err := overlappingReservations(guestID, reservation, reservationGuests, stayDateFrom, stayDateTo, ctx, tx)
+	if err != nil {
+		sugar.Errorf("failed to check overlapping reservations: %v", err)
+		// エラーメッセージ：重複予約の確認エラー
+		return &newReservationGuest, nil, importerror.New(importerror.CodeDuplicateCheckFailed, "", nil)
+	}
+	for _, overlap := range overlaps {
+		sugar.Warnf("overlapping reservation name: %s, reservation_id: %d", reservation.Name, overlap.ReservationID)
+		params := map[string]string{
+			"reservation_id": strconv.Itoa(overlap.ReservationID),
+			"stay_date_from": overlap.StayDateFrom.Format("2006/01/02"),
+			"stay_date_to":   overlap.StayDateTo.Format("2006/01/02"),
+		}
+		if d.ImportEnv.BlockDuplicateReservation {
+			// エラーメッセージ：重複予約エラー
+			return &newReservationGuest, nil, importerror.New(importerror.CodeDuplicateReservation, "StayDateFrom", params)
+		}
+		warnings = append(warnings, importerror.New(importerror.CodeSuspectedDuplicate, "StayDateFrom", params))
+	}
+
 	// Insert reservationの準備
 	newReservation := models.Reservation{
 		// ReservationID:         int
@@ -187,12 +559,18 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 		StayDateTo:            null.TimeFrom(stayDateTo),
 		StayDays:              null.Int16From(reservation.StayDays),
 		NumberOfRooms:         null.Int16From(reservation.NumberOfRooms),
-		NumberOfGuests:        null.Int16From(reservation.NumberOfGuestsMale + reservation.NumberOfGuestsFemale),
-		NumberOfGuestsMale:    null.Int16From(reservation.NumberOfGuestsMale),
-		NumberOfGuestsFemale:  null.Int16From(reservation.NumberOfGuestsFemale),
-		HasChild:              null.Int8From(checkChild(reservation)),
+		NumberOfGuests:        null.Int16From(numberOfGuests),
+		NumberOfGuestsAdult:   null.Int16From(counts.Adult),
+		NumberOfGuestsMale:    null.Int16From(counts.Male),
+		NumberOfGuestsFemale:  null.Int16From(counts.Female),
+		NumberOfGuestsChildA:  null.Int16From(counts.ChildA),
+		NumberOfGuestsChildB:  null.Int16From(counts.ChildB),
+		NumberOfGuestsChildC:  null.Int16From(counts.ChildC),
+		NumberOfGuestsChildD:  null.Int16From(counts.ChildD),
+		NumberOfGuide:         null.Int16From(counts.Guide),
+		HasChild:              null.Int8From(checkChild(counts)),
 		ProductID:             null.StringFromPtr(planId),
-		ReservationMethod:     null.IntFrom(reservationMethodId), 
+		ReservationMethod:     null.IntFrom(reservationMethodId),
 		PaymentMethod:         null.IntFrom(paymentMethodId),
 		Coupon:                null.IntFrom(0), //【要検討】0:未, 1:有, 2:無
 		// StatusCode:            null.Int8From(0),   // default:0が指定される
@@ -209,11 +587,12 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 		//	新規顧客
 		sugar.Info("新規顧客")
 		// Insert guest
+		gender, genderProvided := guestGender(counts)
 		newGuests := models.Guest{
 			// GuestID:       int
 			Name:     null.StringFrom(reservation.Name),
 			NameKana: null.StringFrom(reservation.NameKana),
-			Gender:   null.IntFrom(1), // defaultとして1:女性を指定
+			Gender:   null.IntFrom(gender), // 男女別の人数からわからない場合は1:女性を指定
 			// GenderByFace:  null.StringFrom(),
 			// AgeByFace:     null.Float32From(),
 			// BirthDate:     null.TimeFrom(),
@@ -231,14 +610,19 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 		if err := newGuests.Insert(ctx, tx, boil.Infer()); err != nil {
 			sugar.Errorf("failed to insert Guset record: %v", err)
 			// エラーメッセージ：顧客登録エラー
-			return &newReservationGuest, fmt.Errorf("顧客情報の登録に失敗しました。")
+			return &newReservationGuest, nil, importerror.New(importerror.CodeGuestInsertFailed, "", nil)
+		}
+
+		if !genderProvided {
+			// 連携ファイルから性別がわからないため、デフォルトの性別で登録したことを警告する
+			warnings = append(warnings, importerror.New(importerror.CodeGenderDefaulted, "Gender", nil))
 		}
 
-		//
 		newReservation.GuestID = null.IntFrom(newGuests.GuestID)
 	} else {
 		//	既存顧客
 		sugar.Infof("既存顧客 guest_id: %d", guest.GuestID)
+
 		guest.GuestEmail = null.StringFrom(reservation.Email)
 		guest.PhoneNumber = null.StringFrom(reservation.PhoneNumber)
 		guest.PostalCode = null.StringFrom(helper.PostalCodeFormat(reservation.PostalCode))
@@ -252,7 +636,7 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 		)); err != nil {
 			sugar.Errorf("failed to update Guset record: %v", err)
 			// エラーメッセージ：顧客更新エラー
-			return &newReservationGuest, fmt.Errorf("顧客情報の更新に失敗しました。")
+			return &newReservationGuest, nil, importerror.New(importerror.CodeGuestUpdateFailed, "", nil)
 		}
 
 		newReservation.GuestID = null.IntFrom(guest.GuestID)
@@ -262,27 +646,41 @@ func (d *Database) addReservationInfoToDB(reservation *scCsv.ReservationData, tx
 	if err := newReservation.Insert(ctx, tx, boil.Infer()); err != nil {
 		sugar.Errorf("failed to insert Reservation record: %v", err)
 		// エラーメッセージ：予約登録エラー
-		return &newReservationGuest, fmt.Errorf("予約情報の登録に失敗しました。")
+		return &newReservationGuest, nil, importerror.New(importerror.CodeReservationInsertFailed, "", nil)
+	}
+	if err := insertReservationRooms(newReservation.ReservationID, usages, ctx, tx); err != nil {
+		sugar.Errorf("failed to insert ReservationRoom record: %v", err)
+		// エラーメッセージ：利用室数登録エラー
+		return &newReservationGuest, nil, importerror.New(importerror.CodeReservationRoomFailed, "", nil)
+	}
+	if err := insertOverbookings(newReservation.ReservationID, overbookings, ctx, tx); err != nil {
+		sugar.Errorf("failed to insert Overbooking record: %v", err)
+		// エラーメッセージ：オーバーブッキング登録エラー
+		return &newReservationGuest, nil, importerror.New(importerror.CodeOverbookingInsertFailed, "", nil)
 	}
 	sugar.Infof("added reservation ID: %v, Name: %v\n", newReservation.GuestID, newReservation.ReservationHolder)
 	newReservationGuest = reservationGuest{
 		ReservationID:   newReservation.ReservationID,
+		GuestID:         newReservation.GuestID.Int,
+		StayDateFrom:    stayDateFrom,
+		StayDateTo:      stayDateTo,
 		ReservationData: reservation,
 	}
 	sugar.Debugf("reservation guest: %v", newReservationGuest)
 
-	return &newReservationGuest, nil
+	return &newReservationGuest, warnings, nil
 }
 
-func deleteReservationInfoFromDB(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, tx *sql.Tx, ctx context.Context) error {
+// deleteReservationInfoFromDB 取消の予約を削除（delete_flag）し、削除した予約のIDを返す
+func deleteReservationInfoFromDB(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, rules []config.ValidationRule, tx *sql.Tx, ctx context.Context) (int, error) {
 	// Set updating columns
 	updCols := map[string]interface{}{
 		models.ReservationColumns.DeleteFlag: 1,
 	}
 
-	targetID, err := selectDeleteReservationID(reservation, reservationGuests, tx, ctx)
+	targetID, err := selectDeleteReservationID(reservation, reservationGuests, rules, tx, ctx)
 	if err != nil || targetID == 0 {
-		return err
+		return 0, err
 	}
 
 	query := qm.Where(models.ReservationColumns.ReservationID+"=?", targetID)
@@ -292,18 +690,23 @@ func deleteReservationInfoFromDB(reservation *scCsv.ReservationData, reservation
 	if err != nil {
 		sugar.Errorf("failed to update reservation delete flag: %v", err)
 		// エラーメッセージ：reservationのdelete_flag更新エラー
-		return fmt.Errorf("予約のキャンセルに失敗しました。")
+		return 0, importerror.New(importerror.CodeCancelFailed, "", nil)
 	}
 
-	return nil
+	if err := deleteReservationRooms(targetID, ctx, tx); err != nil {
+		sugar.Errorf("failed to delete reservation rooms: %v", err)
+		// エラーメッセージ：利用室数の削除エラー
+		return 0, importerror.New(importerror.CodeCancelRoomDeleteFailed, "", nil)
+	}
+
+	return targetID, nil
 }
 
-func selectDeleteReservationID(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, tx *sql.Tx, ctx context.Context) (int, error) {
-	if Results := validateDeleteReservationData(reservation); Results != nil {
+func selectDeleteReservationID(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, rules []config.ValidationRule, tx *sql.Tx, ctx context.Context) (int, error) {
+	if Results := validateReservationData(reservation, rules); Results != nil {
 		sugar.Errorf("validation delete reservation data error: %v", Results)
-		//	エラーメッセージ：validation エラー
-		ResultsStr := fmt.Sprint(strings.Join(Results, ", "))
-		return 0, fmt.Errorf("必要な項目が入力されていません。: %v", ResultsStr)
+		//	エラーメッセージ：validation エラー（違反したルールごと）
+		return 0, Results
 	}
 
 	// 団体者名、電話番号、住所 → guestIDの特定
@@ -311,13 +714,14 @@ func selectDeleteReservationID(reservation *scCsv.ReservationData, reservationGu
 		//qm.Select("guest_id"),
 		qm.Where(models.GuestColumns.Name+"=?", reservation.Name),
 		qm.And(models.GuestColumns.NameKana+"=?", reservation.NameKana),
-		qm.And(models.GuestColumns.PhoneNumber+"=?", reservation.PhoneNumber),
+		// 正規化前に登録された電話番号はハイフンを含むことがある
+		qm.And("REPLACE("+models.GuestColumns.PhoneNumber+", '-', '')=?", reservation.PhoneNumber),
 		// qm.And(models.GuestColumns.HomeAddress+"=?", reservation.PostalCode + reservation.HomeAddress),
 	}
 	guests, err := models.Guests(queries_guest...).All(ctx, tx)
 	if err != nil {
 		sugar.Errorf("failed to get guest records: %v", err)
-		return 0, fmt.Errorf("キャンセルする顧客の取得に失敗しました。")
+		return 0, importerror.New(importerror.CodeCancelGuestFetchFailed, "", nil)
 	}
 
 	// WhereIn method needs to pass a slice of interface{}
@@ -342,36 +746,48 @@ func selectDeleteReservationID(reservation *scCsv.ReservationData, reservationGu
 	counts, err := models.Reservations(queries_reservation...).Count(ctx, tx)
 	if counts == 0 {
 		sugar.Debug("No reservation")
-		for _, reservationGuest := range reservationGuests {
-			sugar.Debugf("reservationGuest: %v, %v, %v", reservationGuest.Name, reservationGuest.NameKana, reservationGuest.PhoneNumber)
-			if reservation.Name == reservationGuest.Name && reservation.NameKana == reservationGuest.NameKana && reservation.PhoneNumber == reservationGuest.PhoneNumber {
-				return reservationGuest.ReservationID, nil
-			}
+		if reservationID := inFileReservationID(reservation, reservationGuests); reservationID != 0 {
+			return reservationID, nil
 		}
 		sugar.Errorf("no reservation, name: %s, phone number: %s", reservation.Name, reservation.PhoneNumber)
 		// エラーメッセージ：キャンセル予約が登録されていない
-		return 0, fmt.Errorf("キャンセルする予約が登録されていません。")
+		return 0, importerror.New(importerror.CodeCancelNotFound, "", nil)
 	} else if counts > 1 {
 		sugar.Errorf("multiple reservations exist, name: %v, phone number: %v", reservation.Name, reservation.PhoneNumber)
 		// エラーメッセージ：キャンセル予約が複数登録されている
-		return 0, fmt.Errorf("キャンセルする同一予約が複数登録されています。")
+		return 0, importerror.New(importerror.CodeCancelMultiple, "", nil)
 	} else if err != nil {
 		sugar.Errorf("cannot detect delete reservationID: %v\n", err)
 		// エラーメッセージ：キャンセル予約を特定できなかった
-		return 0, fmt.Errorf("キャンセルする予約を特定できませんでした。")
+		return 0, importerror.New(importerror.CodeCancelNotIdentified, "", nil)
 	}
 
 	reservationId, err := models.Reservations(queries_reservation...).All(ctx, tx)
 	if err != nil {
 		sugar.Errorf("failed to get reservation ID: %v", err)
 		// エラーメッセージ：reservationのgetエラー
-		return 0, fmt.Errorf("キャンセルする予約の取得に失敗しました。")
+		return 0, importerror.New(importerror.CodeCancelFetchFailed, "", nil)
 	}
 	sugar.Infof("delete ReservationID: %v, Name: %v\n", reservationId[0].ReservationID, reservationId[0].ReservationHolder)
 
 	return reservationId[0].ReservationID, nil
 }
 
+// inFileReservationID 同じファイルの前の行で登録した予約から、取消の予約のIDを探す。ない場合は0を返す。
+// 前の行で取り消した予約は、同じ予約を2回取り消さないよう探さない
+func inFileReservationID(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest) int {
+	for _, reservationGuest := range reservationGuests {
+		if reservationGuest.Cancelled {
+			continue
+		}
+		sugar.Debugf("reservationGuest: %v, %v, %v", reservationGuest.Name, reservationGuest.NameKana, reservationGuest.PhoneNumber)
+		if reservation.Name == reservationGuest.Name && reservation.NameKana == reservationGuest.NameKana && reservation.PhoneNumber == reservationGuest.PhoneNumber {
+			return reservationGuest.ReservationID
+		}
+	}
+	return 0
+}
+
 // TODO: websocket実装時に必要かも
 func (d *Database) SelectErrorCSVRowsWithIds(csvIds []int, ctx context.Context, tx *sql.Tx) (models.CSVExecutionErrorSlice, error) {
 	// whereinに合わせて型を変換する
@@ -398,58 +814,138 @@ func (d *Database) SelectErrorCSVRowsWithIds(csvIds []int, ctx context.Context,
 	return rows, nil
 }
 
-func (d *Database) RegisterCSVDataToDB(ctx context.Context, file file.File, path string, id int, siteControllerName string) error {
+// ImportResult ファイルの取込結果
+type ImportResult struct {
+	// Failed ファイルを読み込めなかったか、エラーの行がある
+	Failed bool
+	// Errors 行ごとのエラー（キーは0始まりの行）
+	Errors map[int][]ErrorStruct
+	// Interrupted ctxのキャンセルにより途中で止めた。Nextの行から再開する
+	Interrupted bool
+	// Next 次に取り込む行の取込順での順番。途中で止まった場合は止まった行
+	Next int
+}
+
+// RegisterCSVDataToDB ファイルを取込順でstart番目の行から取り込み、結果を返す。取込を始められなかった場合は結果がnilになる。
+// ctxがキャンセルされた場合は、取り込んだ行までのエラー・警告を記録し、csv_upload_transactionをinterruptedにする
+func (d *Database) RegisterCSVDataToDB(ctx context.Context, file file.File, path string, id int, siteControllerName string, importMode string, start int) (*ImportResult, error) {
 	// サイトコントローラー名
-	sugar.Infof("site controller name is %s", siteControllerName)
+	sugar.Infof("site controller name is %s, import mode is %s", siteControllerName, importMode)
+	dryRun := importMode == config.ImportModeValidate
 
 	// トランザクション：insertReservation, insertGuest
 	csvPath := fmt.Sprintf("%s/%s", path, file.Name)
-	var reservations []*scCsv.ReservationData
-	var err error
-	switch siteControllerName {
-	case "xxxx":
-		reservations, err = scCsv.ImportFromLincoln(csvPath)
-	case "xxxx":
-		// TODO xxxx用のインポート関数を作る
-		reservations, err = scCsv.ImportFromLincoln(csvPath)
-	case "xxxx":
-		// TODO xxxx用のインポート関数を作る
-		reservations, err = scCsv.ImportFromLincoln(csvPath)
-	default:
-		return xerrors.Errorf("site controller name '%s' is not available", siteControllerName)
+	reservations, err := readReservations(csvPath, siteControllerName)
+	if xerrors.Is(err, errUnavailableSiteController) {
+		return nil, err
 	}
 	if err != nil {
+		result := &ImportResult{Failed: true}
 		if err := d.updateCsvUploadTransactionStatusToError(id, ctx); err != nil {
-			return xerrors.Errorf("failed to upload csv_upload_transaction status: %w", err)
+			return result, xerrors.Errorf("failed to upload csv_upload_transaction status: %w", err)
+		}
+		return result, xerrors.Errorf("path: %s, failed to import csv: %w", csvPath, err)
+	}
+
+	errors, warnings, next, err := d.transactionReservationInfo(reservations, siteControllerName, dryRun, start, id, ctx)
+	// 取込結果の一覧に行数を表示するため、ファイルの行数と取り込んだ行数を記録する（キャンセルされないようにする）
+	if linesErr := d.updateCsvUploadLines(context.Background(), id, len(reservations), next); linesErr != nil {
+		sugar.Error(linesErr)
+	}
+	if err != nil && ctx.Err() != nil {
+		// 取込結果の記録はキャンセルされないようにする
+		return d.interruptCsvUpload(context.Background(), id, errors, warnings, next)
+	}
+	// 再開した取込は、中断前に記録したエラーも結果に含める
+	if err == nil && errors == nil && start > 0 {
+		if exists, existsErr := d.existsCsvExecutionFailures(ctx, id); existsErr != nil {
+			sugar.Error(existsErr)
+		} else if exists {
+			errors = map[int][]ErrorStruct{}
 		}
-		return xerrors.Errorf("path: %s, failed to import csv: %w", csvPath, err)
 	}
 
-	errors, err := d.TransactionReservationInfo(reservations, ctx)
+	// 警告は取込結果に関わらずcsv_execution_warningsに入れる（行ごとに記録した警告は返らない）
+	if warnings != nil {
+		ids := d.InsertCSVExecutionWarning(ctx, warnings, id)
+		sugar.Debugf("warning ids: %v", ids)
+	}
 
 	// トランザクションOK...csvステータスをcompleteに変える
 	if err == nil && errors == nil {
-		if err := d.finishCsvUpload(id, ctx); err != nil {
-			return fmt.Errorf("failed to upload csv_upload_transaction status: %v", err)
+		result := &ImportResult{}
+		status := "complete"
+		if dryRun {
+			// 検証のみ：予約情報は登録していない
+			status = "validated"
+		}
+		if err := d.finishCsvUpload(id, status, ctx); err != nil {
+			return result, fmt.Errorf("failed to upload csv_upload_transaction status: %v", err)
 		} else {
 			sugar.Info("successful of csv uploading")
 		}
+		return result, nil
+	}
+
+	// トランザクションERROR...csvステータスをerrorに変える（途中で止まった場合はNextの行から再試行できる）
+	result := &ImportResult{Failed: true, Errors: errors, Next: next}
+	if err := d.updateCsvUploadTransactionStatusToError(id, ctx); err != nil {
+		return result, fmt.Errorf("failed to upload csv_upload_transaction status: %v", err)
 	} else {
-		// トランザクションERROR...csvステータスをerrorに変える
-		if err := d.updateCsvUploadTransactionStatusToError(id, ctx); err != nil {
-			return fmt.Errorf("failed to upload csv_upload_transaction status: %v", err)
-		} else {
-			sugar.Info("failed to upload csv")
-		}
-		// csv_execution_errorにerror内容を入れる
-		// TODO 戻り値のidsをチャネル使ってwebsocketに渡す
+		sugar.Info("failed to upload csv")
+	}
+	// csv_execution_errorにerror内容を入れる
+	// TODO 戻り値のidsをチャネル使ってwebsocketに渡す
+	ids := d.InsertCSVExecutionError(ctx, errors, id)
+	sugar.Debugf("error ids: %v", ids)
+	if err != nil {
+		// 途中で止まった場合はファイル全体のエラーとして返す
+		return result, xerrors.Errorf("path: %s, failed to register reservations: %w", csvPath, err)
+	}
+	return result, nil
+}
+
+// interruptCsvUpload 中断までのエラー・警告を記録し、csvステータスをinterruptedにする
+func (d *Database) interruptCsvUpload(ctx context.Context, id int, errors map[int][]ErrorStruct, warnings map[int][]WarningStruct, next int) (*ImportResult, error) {
+	result := &ImportResult{Interrupted: true, Next: next, Errors: errors}
+	if len(warnings) > 0 {
+		ids := d.InsertCSVExecutionWarning(ctx, warnings, id)
+		sugar.Debugf("warning ids: %v", ids)
+	}
+	if len(errors) > 0 {
 		ids := d.InsertCSVExecutionError(ctx, errors, id)
 		sugar.Debugf("error ids: %v", ids)
 	}
-	return nil
+	if err := d.finishCsvUpload(id, "interrupted", ctx); err != nil {
+		return result, fmt.Errorf("failed to upload csv_upload_transaction status: %v", err)
+	}
+	sugar.Infof("csv uploading is interrupted, resume from %d", next)
+	return result, nil
+}
+
+// existsCsvExecutionFailures 取込のエラーが記録されているか。
+// 中断前に失敗した行は、csv_execution_errorsになくてもcsv_execution_linesに失敗として記録されている
+func (d *Database) existsCsvExecutionFailures(ctx context.Context, csvID int) (bool, error) {
+	exists, err := models.CSVExecutionErrors(
+		models.CSVExecutionErrorWhere.CSVID.EQ(csvID),
+	).Exists(ctx, d.DB)
+	if err != nil {
+		return false, xerrors.Errorf("failed to check csv_execution_errors of %d: %w", csvID, err)
+	}
+	if exists {
+		return true, nil
+	}
+	exists, err = models.CSVExecutionLines(
+		models.CSVExecutionLineWhere.CSVID.EQ(csvID),
+		models.CSVExecutionLineWhere.Result.EQ(LineFailed),
+	).Exists(ctx, d.DB)
+	if err != nil {
+		return false, xerrors.Errorf("failed to check failed csv_execution_lines of %d: %w", csvID, err)
+	}
+	return exists, nil
 }
 
-func (d *Database) CreateCsvUploadTransaction(ctx context.Context, fileName string, createdTime time.Time, timestamp string, path string) (*models.CSVUploadTransaction, error) {
+func (d *Database) CreateCsvUploadTransaction(ctx context.Context, fileName string, createdTime time.Time, timestamp string, path string, source string, siteControllerName string) (*models.CSVUploadTransaction, error) {
 	// mysqlにinsertするデータを作成
 	newCSVUploadTransaction := models.CSVUploadTransaction{
 		FileName:             null.StringFrom(fileName),
@@ -457,6 +953,8 @@ func (d *Database) CreateCsvUploadTransaction(ctx context.Context, fileName stri
 		CreatedTimeInWindows: null.TimeFrom(createdTime),
 		Timestamp:            null.StringFrom(timestamp),
 		Path:                 null.StringFrom(path),
+		Source:               null.StringFrom(source),
+		SiteController:       null.StringFrom(siteControllerName),
 	}
 
 	// mysqlにinsertする
@@ -481,6 +979,28 @@ func (d *Database) GetCSVUpdateTransaction(ctx context.Context) (models.CSVUploa
 	return rows, nil
 }
 
+// GetLatestFileCreatedTime 監視元で最後に取り込んだファイルの作成日時。取込がない場合はゼロ値を返す。
+// 処理済みファイル台帳の導入前に取り込んだファイルを判定するために使う
+func (d *Database) GetLatestFileCreatedTime(ctx context.Context, source string) (time.Time, error) {
+	sourceQuery := qm.Where(models.CSVUploadTransactionColumns.Source+" = ?", source)
+	if source == config.DefaultWatchSourceName {
+		// 監視元を記録する前のファイルはデフォルトの監視元のものとする
+		sourceQuery = qm.Where("("+models.CSVUploadTransactionColumns.Source+" = ? or "+models.CSVUploadTransactionColumns.Source+" is null)", source)
+	}
+	row, err := models.CSVUploadTransactions(
+		qm.Select(models.CSVUploadTransactionColumns.ID, models.CSVUploadTransactionColumns.CreatedTimeInWindows),
+		sourceQuery,
+		qm.OrderBy(models.CSVUploadTransactionColumns.CreatedTimeInWindows+" DESC"),
+	).One(ctx, d.DB)
+	if err != nil {
+		if xerrors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, xerrors.Errorf("failed to get latest csv_upload_transaction of %s: %w", source, err)
+	}
+	return row.CreatedTimeInWindows.Time, nil
+}
+
 func (d *Database) updateCsvUploadTransactionStatusToError(id int, ctx context.Context) error {
 	record := models.CSVUploadTransaction{
 		ID:     id,
@@ -495,18 +1015,18 @@ func (d *Database) updateCsvUploadTransactionStatusToError(id int, ctx context.C
 	return nil
 }
 
-func (d *Database) finishCsvUpload(id int, ctx context.Context) error {
+func (d *Database) finishCsvUpload(id int, status string, ctx context.Context) error {
 	record, err := models.CSVUploadTransactions(
 		qm.Where(models.CSVUploadTransactionColumns.ID+" = ?", id),
 	).One(ctx, d.DB)
 	if err != nil {
 		return xerrors.Errorf("failed to get csv transaction data: %w", err)
 	}
-	if record.Status.String == "complete" {
-		return xerrors.New("csv upload status is already complete")
+	if record.Status.String == status {
+		return xerrors.Errorf("csv upload status is already %s", status)
 	}
 
-	record.Status = null.StringFrom("complete")
+	record.Status = null.StringFrom(status)
 	_, err = record.Update(ctx, d.DB, boil.Infer())
 	if err != nil {
 		return xerrors.Errorf("failed to get csv transaction data: %w", err)
@@ -514,26 +1034,90 @@ func (d *Database) finishCsvUpload(id int, ctx context.Context) error {
 	return nil
 }
 
-func (d *Database) InsertCSVExecutionError(ctx context.Context, mapError map[int]ErrorStruct, csvId int) []int {
+func (d *Database) InsertCSVExecutionError(ctx context.Context, mapError map[int][]ErrorStruct, csvId int) []int {
 	var ids []int
 	// mysqlにinsertするデータを生成
-	for i, errStruct := range mapError {
-		newCSVExecutionError := models.CSVExecutionError{
-			//ID:                  null.IntFrom(),
-			LineNumber:          i + 1,
-			CustomerName:        null.StringFrom(errStruct.CustomerName),
-			CustomerPhoneNumber: null.StringFrom(errStruct.CustomerPhoneNumber),
-			ErrorMessage:        errStruct.ErrorMsg,
-			Status:              0, //未対応は0
-			CSVID:               csvId,
-		}
+	for i, errStructs := range mapError {
+		for _, errStruct := range errStructs {
+			newCSVExecutionError := models.CSVExecutionError{
+				//ID:                  null.IntFrom(),
+				LineNumber:          i + 1,
+				CustomerName:        null.StringFrom(errStruct.CustomerName),
+				CustomerPhoneNumber: null.StringFrom(errStruct.CustomerPhoneNumber),
+				ErrorMessage:        errStruct.ErrorMsg,
+				Status:              0, //未対応は0
+				CSVID:               csvId,
+				ErrorCode:           null.StringFrom(string(errStruct.Code)),
+				FieldName:           null.StringFrom(errStruct.Field),
+				Severity:            null.StringFrom(string(errStruct.Code.Severity())),
+				Params:              null.StringFrom(marshalParams(errStruct.Params)),
+			}
+
+			if err := newCSVExecutionError.Insert(ctx, d.DB, boil.Infer()); err != nil {
+				sugar.Errorf("failed to insert new record to csv_execution_errors: line number: %d, error message: %v", i+1, err)
+			}
 
-		if err := newCSVExecutionError.Insert(ctx, d.DB, boil.Infer()); err != nil {
-			sugar.Errorf("failed to insert new record to csv_execution_errors: line number: %d, error message: %v", i+1, err)
+			// ID = 0はDB insertエラーを表します
+			ids = append(ids, newCSVExecutionError.ID)
 		}
+	}
+	return ids
+}
 
-		// ID = 0はDB insertエラーを表します
-		ids = append(ids, newCSVExecutionError.ID)
+// marshalParams エラーメッセージのパラメータをJSONにする
+func marshalParams(params map[string]string) string {
+	if len(params) == 0 {
+		return "{}"
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		sugar.Errorf("failed to marshal params: %v", err)
+		return "{}"
+	}
+	return string(b)
+}
+
+// UnmarshalParams csv_execution_errors, csv_execution_warningsのparamsを戻す
+func UnmarshalParams(params null.String) map[string]string {
+	m := map[string]string{}
+	if !params.Valid || params.String == "" {
+		return m
+	}
+	if err := json.Unmarshal([]byte(params.String), &m); err != nil {
+		sugar.Errorf("failed to unmarshal params: %v", err)
+	}
+	return m
+}
+
+// newCsvExecutionWarning i番目の行の警告
+func newCsvExecutionWarning(csvID, i int, warningStruct WarningStruct) *models.CSVExecutionWarning {
+	return &models.CSVExecutionWarning{
+		LineNumber:          i + 1,
+		CustomerName:        null.StringFrom(warningStruct.CustomerName),
+		CustomerPhoneNumber: null.StringFrom(warningStruct.CustomerPhoneNumber),
+		WarningMessage:      warningStruct.WarningMsg,
+		Status:              0, //未対応は0
+		CSVID:               csvID,
+		ErrorCode:           null.StringFrom(string(warningStruct.Code)),
+		FieldName:           null.StringFrom(warningStruct.Field),
+		Params:              null.StringFrom(marshalParams(warningStruct.Params)),
+	}
+}
+
+func (d *Database) InsertCSVExecutionWarning(ctx context.Context, mapWarning map[int][]WarningStruct, csvId int) []int {
+	var ids []int
+	// mysqlにinsertするデータを生成
+	for i, warnings := range mapWarning {
+		for _, warningStruct := range warnings {
+			newCSVExecutionWarning := newCsvExecutionWarning(csvId, i, warningStruct)
+
+			if err := newCSVExecutionWarning.Insert(ctx, d.DB, boil.Infer()); err != nil {
+				sugar.Errorf("failed to insert new record to csv_execution_warnings: line number: %d, warning message: %v", i+1, err)
+			}
+
+			// ID = 0はDB insertエラーを表します
+			ids = append(ids, newCSVExecutionWarning.ID)
+		}
 	}
 	return ids
 }
@@ -225,7 +225,7 @@ func TestAddReservationInfoToDB(t *testing.T) {
 	tx, _ := db.DB.Begin()
 
 	t.Run("test", func(t *testing.T) {
-		if _, err := db.addReservationInfoToDB(&SampleReservation, tx, ctx); err != nil {
+		if _, _, err := db.addReservationInfoToDB(&SampleReservation, nil, config.DefaultValidationRules().Rules("Lincoln", config.NoticeReservation), tx, ctx); err != nil {
 			t.Errorf("%v", err)
 		}
 	})
@@ -840,7 +840,7 @@ func TestTransactionReservationInfo(t *testing.T) {
 	defer cancel()
 
 	t.Run("test", func(t *testing.T) {
-		errors, err := db.TransactionReservationInfo(SampleReservations, ctx)
+		errors, _, err := db.TransactionReservationInfo(SampleReservations, "Lincoln", false, ctx)
 		if err != nil {
 			t.Errorf("failed to process transaction: %v", err)
 		}
@@ -1275,17 +1275,17 @@ func TestTransaction(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			model, err := db.CreateCsvUploadTransaction(ctx, "test.csv", time.Now(), "", "")
+			model, err := db.CreateCsvUploadTransaction(ctx, "test.csv", time.Now(), "", "", config.DefaultWatchSourceName, "Lincoln")
 			if err != nil {
 				t.Errorf("failed to insert record to database: %v", err)
 			}
 
 			// トランザクション：insertReservation, insertGuest
-			errors, err := db.TransactionReservationInfo(tt.reservationData, ctx)
+			errors, _, err := db.TransactionReservationInfo(tt.reservationData, "Lincoln", false, ctx)
 
 			// トランザクションOK...csvステータスをcompleteに変える
 			if err == nil && errors == nil {
-				if err := db.finishCsvUpload(model.ID, ctx); err != nil {
+				if err := db.finishCsvUpload(model.ID, "complete", ctx); err != nil {
 					t.Errorf("failed to upload csv_upload_transaction status: %v", err)
 				} else {
 					sugar.Info("successful of csv uploading")
@@ -3,10 +3,15 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
 	"ui-backend-for-omotebako-site-controller/app/helper"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
 	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
 
 	"context"
 
@@ -15,15 +20,15 @@ import (
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 )
 
-func checkInTime(reservation *scCsv.ReservationData) (time.Time, error) {
+func checkInTime(reservation *scCsv.ReservationData, location *time.Location) (time.Time, error) {
 	if len(reservation.CheckInTime) > 0 {
-		stayDateFrom, err := time.Parse("20060102 15:04", reservation.StayDateFrom+" "+reservation.CheckInTime)
+		stayDateFrom, err := time.ParseInLocation("20060102 15:04", reservation.StayDateFrom+" "+reservation.CheckInTime, location)
 		if err != nil {
 			return time.Time{}, fmt.Errorf("failed to parse checkInTime: %v\n", err)
 		}
 		return stayDateFrom, nil
 	} else {
-		stayDateFrom, err := time.Parse("20060102", reservation.StayDateFrom)
+		stayDateFrom, err := time.ParseInLocation("20060102", reservation.StayDateFrom, location)
 		if err != nil {
 			return time.Time{}, fmt.Errorf("failed to parse checkInTime: %v\n", err)
 		}
@@ -31,15 +36,71 @@ func checkInTime(reservation *scCsv.ReservationData) (time.Time, error) {
 	}
 }
 
-func checkChild(reservation *scCsv.ReservationData) int8 {
-	numChild := reservation.NumberOfGuestsChildA + reservation.NumberOfGuestsChildB + reservation.NumberOfGuestsChildC + reservation.NumberOfGuestsChildD
-	if numChild > 0 {
+// guestCount 予約の人数内訳。添乗員はTotalに含まない
+type guestCount struct {
+	Total  int16
+	Adult  int16
+	Male   int16
+	Female int16
+	ChildA int16
+	ChildB int16
+	ChildC int16
+	ChildD int16
+	Guide  int16
+}
+
+func newGuestCount(reservation *scCsv.ReservationData) guestCount {
+	count := guestCount{
+		Male:   reservation.NumberOfGuestsMale,
+		Female: reservation.NumberOfGuestsFemale,
+		ChildA: reservation.NumberOfGuestsChildA,
+		ChildB: reservation.NumberOfGuestsChildB,
+		ChildC: reservation.NumberOfGuestsChildC,
+		ChildD: reservation.NumberOfGuestsChildD,
+		Guide:  reservation.NumberOfGuide,
+	}
+	// 男女別の人数がない場合は大人人数を使う
+	count.Adult = count.Male + count.Female
+	if count.Adult == 0 {
+		count.Adult = reservation.NumberOfGuestsAdult
+	}
+	count.Total = count.Adult + count.children()
+	return count
+}
+
+func (g guestCount) children() int16 {
+	return g.ChildA + g.ChildB + g.ChildC + g.ChildD
+}
+
+func checkChild(count guestCount) int8 {
+	if count.children() > 0 {
 		return 1 //有
 	} else {
 		return 0
 	}
 }
 
+// 顧客（guest）の性別
+const (
+	genderMale   = 0
+	genderFemale = 1
+)
+
+// guestGender 男女別の人数から新規顧客の性別を決める。男性のみ、女性のみの場合以外は性別がわからないため、
+// デフォルトの女性とし、falseを返す
+func guestGender(count guestCount) (int, bool) {
+	switch {
+	case count.Male > 0 && count.Female == 0:
+		return genderMale, true
+	case count.Female > 0 && count.Male == 0:
+		return genderFemale, true
+	}
+	return genderFemale, false
+}
+
+// defaultPaymentMethodName 支払方法が連携されない場合に使う支払方法
+const defaultPaymentMethodName = "指定なし"
+
 func checkPaymentMethod(paymentMethodName string, ctx context.Context, tx *sql.Tx) (int, error) {
 	if paymentMethodName != "" {
 		id, err := getPaymentMethod(paymentMethodName, ctx, tx)
@@ -55,9 +116,10 @@ func checkPaymentMethod(paymentMethodName string, ctx context.Context, tx *sql.T
 		}
 		return id, nil
 	}
-	id, err := getPaymentMethod("指定なし", ctx, tx)
+	id, err := getPaymentMethod(defaultPaymentMethodName, ctx, tx)
 	if err != nil {
-		sugar.Infof("failed to get payment method id correspond to '指定なし' error: %v", err)
+		sugar.Infof("failed to get payment method id correspond to '%s' error: %v", defaultPaymentMethodName, err)
+		return 0, err
 	}
 	return id, nil
 }
@@ -156,42 +218,182 @@ func getProductMaster(productId, productName string, ctx context.Context, tx *sq
 	return &record.ProductID, nil
 }
 
-func validateReservationData(reservation *scCsv.ReservationData) []string {
-	var validationStr []string
-	if reservation.Name == "" {
-		validationStr = append(validationStr, "団体名または代表者氏名 漢字")
-	}
-	if reservation.NameKana == "" {
-		validationStr = append(validationStr, "団体名または代表者氏名(半角)")
-	}
-	if reservation.PhoneNumber == "" {
-		validationStr = append(validationStr, "団体または代表者番号")
-	}
-	if reservation.PostalCode == "" {
-		validationStr = append(validationStr, "団体または代表者郵便番号")
+// normalizeReservationData 電話番号、郵便番号、メールアドレスを正規化する。
+// 不正な値は入力値のまま残し、警告メッセージを返す
+func normalizeReservationData(reservation *scCsv.ReservationData) []*importerror.ImportError {
+	targets := []struct {
+		field     string
+		label     string
+		value     *string
+		normalize func(string) (string, error)
+		code      importerror.Code
+	}{
+		{"PhoneNumber", "団体または代表者番号", &reservation.PhoneNumber, helper.NormalizePhoneNumber, importerror.CodeInvalidPhoneNumber},
+		{"PostalCode", "団体または代表者郵便番号", &reservation.PostalCode, helper.NormalizePostalCode, importerror.CodeInvalidPostalCode},
+		{"Email", "団体または代表者Eメール", &reservation.Email, helper.NormalizeEmail, importerror.CodeInvalidEmail},
+		{"ReservationHolderPhoneNumber", "予約者・会員電話番号", &reservation.ReservationHolderPhoneNumber, helper.NormalizePhoneNumber, importerror.CodeInvalidPhoneNumber},
+		{"ReservationHolderPostalCode", "予約者・会員郵便番号", &reservation.ReservationHolderPostalCode, helper.NormalizePostalCode, importerror.CodeInvalidPostalCode},
+		{"ReservationHolderEmail", "予約者・会員Eメール", &reservation.ReservationHolderEmail, helper.NormalizeEmail, importerror.CodeInvalidEmail},
 	}
-	if reservation.HomeAddress == "" {
-		validationStr = append(validationStr, "団体または代表者住所")
-	}
-	if reservation.ReservationHolder == "" {
-		validationStr = append(validationStr, "予約者・会員名漢字")
+
+	var warnings []*importerror.ImportError
+	for _, target := range targets {
+		if *target.value == "" {
+			continue
+		}
+		normalized, err := target.normalize(*target.value)
+		if err != nil {
+			sugar.Warnf("failed to normalize: %v", err)
+			warnings = append(warnings, importerror.New(target.code, target.field, map[string]string{"label": target.label, "value": *target.value}))
+			continue
+		}
+		*target.value = normalized
 	}
-	if reservation.ReservationHolderKana == "" {
-		validationStr = append(validationStr, "予約者・会員名カタカナ")
+	return warnings
+}
+
+// validateReservationData 検証ルールに違反した項目をすべて返す
+func validateReservationData(reservation *scCsv.ReservationData, rules []config.ValidationRule) importerror.List {
+	return validateFields(reflect.ValueOf(reservation).Elem(), rules)
+}
+
+// validateFields 構造体valueの項目を検証する。
+// ポインタの項目はnilを未入力とし、0も値として検証する。数値の項目は未入力と0を区別できないため、
+// requiredでは0を未入力とみなすが、範囲（min、max）は0にも適用する
+func validateFields(value reflect.Value, rules []config.ValidationRule) importerror.List {
+	var validationErrors importerror.List
+	for i := range rules {
+		rule := &rules[i]
+		params := map[string]string{"label": rule.Label}
+		field := value.FieldByName(rule.Field)
+		if !field.IsValid() {
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeUnknownField, rule.Field, params))
+			continue
+		}
+		isPointer := field.Kind() == reflect.Ptr
+		if isPointer {
+			if field.IsNil() {
+				if rule.Required {
+					validationErrors = append(validationErrors, importerror.New(importerror.CodeRequired, rule.Field, params))
+				}
+				continue
+			}
+			field = field.Elem()
+		}
+
+		var str string
+		var num float64
+		isNumber := false
+		switch field.Kind() {
+		case reflect.String:
+			str = field.String()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			num, isNumber = float64(field.Int()), true
+			str = strconv.FormatInt(field.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			num, isNumber = float64(field.Uint()), true
+			str = strconv.FormatUint(field.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			num, isNumber = field.Float(), true
+			str = strconv.FormatFloat(field.Float(), 'f', -1, 64)
+		default:
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeUnsupportedField, rule.Field, params))
+			continue
+		}
+
+		if str == "" {
+			if rule.Required {
+				validationErrors = append(validationErrors, importerror.New(importerror.CodeRequired, rule.Field, params))
+			}
+			continue
+		}
+		// 数値の項目の0は未入力とし、範囲や合計と照合しない
+		if isNumber && num == 0 && !isPointer {
+			if rule.Required {
+				validationErrors = append(validationErrors, importerror.New(importerror.CodeRequired, rule.Field, params))
+			}
+			continue
+		}
+		if !rule.MatchFormat(str) {
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeInvalidFormat, rule.Field, params))
+		}
+		if rule.Min == nil && rule.Max == nil && len(rule.SumOf) == 0 {
+			continue
+		}
+		if !isNumber {
+			n, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				validationErrors = append(validationErrors, importerror.New(importerror.CodeNotNumber, rule.Field, params))
+				continue
+			}
+			num = n
+		}
+		if (rule.Min != nil && num < *rule.Min) || (rule.Max != nil && num > *rule.Max) {
+			params["min"], params["max"] = rangeParams(rule)
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeOutOfRange, rule.Field, params))
+		}
+		if len(rule.SumOf) == 0 {
+			continue
+		}
+		total, err := sumFields(value, rule.SumOf)
+		if err != nil {
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeUnknownField, rule.Field, params))
+			continue
+		}
+		if num != total {
+			params["value"] = strconv.FormatFloat(num, 'f', -1, 64)
+			params["total"] = strconv.FormatFloat(total, 'f', -1, 64)
+			params["fields"] = strings.Join(rule.SumOf, "+")
+			validationErrors = append(validationErrors, importerror.New(importerror.CodeSumMismatch, rule.Field, params))
+		}
 	}
-	if reservation.StayDays == 0 {
-		validationStr = append(validationStr, "泊数")
+	return validationErrors
+}
+
+// sumFields 構造体valueの数値の項目fieldsの合計。nilのポインタの項目は0とする
+func sumFields(value reflect.Value, fields []string) (float64, error) {
+	var total float64
+	for _, name := range fields {
+		field := value.FieldByName(name)
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			total += float64(field.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			total += float64(field.Uint())
+		case reflect.Float32, reflect.Float64:
+			total += field.Float()
+		default:
+			return 0, fmt.Errorf("%s is not a number field", name)
+		}
 	}
-	if reservation.NumberOfRooms == 0 {
-		validationStr = append(validationStr, "利用客室合計数")
+	return total, nil
+}
+
+// hasSumRule fieldの値を合計と照合するルールがあるか
+func hasSumRule(rules []config.ValidationRule, field string) bool {
+	for _, rule := range rules {
+		if rule.Field == field && len(rule.SumOf) > 0 {
+			return true
+		}
 	}
-	if reservation.NumberOfGuests == 0 {
-		validationStr = append(validationStr, "お客様総合計人数")
+	return false
+}
+
+func rangeParams(rule *config.ValidationRule) (string, string) {
+	var min, max string
+	if rule.Min != nil {
+		min = strconv.FormatFloat(*rule.Min, 'f', -1, 64)
 	}
-	if reservation.ProductName == "" {
-		validationStr = append(validationStr, "プラン名")
+	if rule.Max != nil {
+		max = strconv.FormatFloat(*rule.Max, 'f', -1, 64)
 	}
-	return validationStr
+	return min, max
 }
 
 func checkNewGuest(reservation *scCsv.ReservationData, ctx context.Context, tx *sql.Tx) *models.Guest {
@@ -199,7 +401,8 @@ func checkNewGuest(reservation *scCsv.ReservationData, ctx context.Context, tx *
 		qm.Select(models.GuestColumns.GuestID),
 		qm.Where(models.GuestColumns.Name+" = ?", reservation.Name),
 		qm.And(models.GuestColumns.NameKana+" = ?", reservation.NameKana),
-		qm.And(models.GuestColumns.PhoneNumber+" = ? or "+models.GuestColumns.PostalCode+" = ?", reservation.PhoneNumber, helper.PostalCodeFormat(reservation.PostalCode)),
+		// 正規化前に登録された電話番号はハイフンを含むことがある
+		qm.And("REPLACE("+models.GuestColumns.PhoneNumber+", '-', '') = ? or "+models.GuestColumns.PostalCode+" = ?", reservation.PhoneNumber, helper.PostalCodeFormat(reservation.PostalCode)),
 	).One(ctx, tx)
 	if err != nil {
 		sugar.Infof("failed to check new guest error: %v", err)
@@ -210,16 +413,88 @@ func checkNewGuest(reservation *scCsv.ReservationData, ctx context.Context, tx *
 	return guestRecord
 }
 
-func validateDeleteReservationData(reservation *scCsv.ReservationData) []string {
-	var validationStr []string
-	if reservation.Name == "" {
-		validationStr = append(validationStr, "団体名または代表者氏名 漢字")
+// checkGuestCount お客様総合計人数と大人・子供の人数の合計が一致しない場合は警告を返す。
+// 乳幼児や添乗員を総合計人数に含めるかはサイトコントローラーによって異なるため、取込は止めない。
+// 取込エラーにする場合は、検証ルールのsum_ofでサイトコントローラーごとに照合する項目を指定する
+func checkGuestCount(reservation *scCsv.ReservationData, count guestCount) *importerror.ImportError {
+	// 未入力の場合は内訳の合計を使うためチェックしない
+	if reservation.NumberOfGuests == 0 || reservation.NumberOfGuests == count.Total {
+		return nil
+	}
+	return importerror.New(importerror.CodeGuestCountMismatch, "NumberOfGuests", map[string]string{
+		"number_of_guests": strconv.Itoa(int(reservation.NumberOfGuests)),
+		"total":            strconv.Itoa(int(count.Total)),
+	})
+}
+
+// overlappingReservation 宿泊期間が重複する予約
+type overlappingReservation struct {
+	ReservationID int
+	StayDateFrom  time.Time
+	StayDateTo    time.Time
+}
+
+// overlappingReservations 同一顧客の宿泊期間が重複する予約を返す。guestIDが0（新規顧客）の場合は登録済みの予約を確認しない。
+// 同じファイルの前の行で登録した予約は、顧客がその行で登録された場合や検証のみでロールバックした場合もあるため、ファイル内でも確認する
+func overlappingReservations(guestID int, reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, stayDateFrom, stayDateTo time.Time, ctx context.Context, tx *sql.Tx) ([]overlappingReservation, error) {
+	var overlaps []overlappingReservation
+	found := map[int]bool{}
+	if guestID != 0 {
+		records, err := checkOverlappingReservations(guestID, stayDateFrom, stayDateTo, ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			found[record.ReservationID] = true
+			overlaps = append(overlaps, overlappingReservation{
+				ReservationID: record.ReservationID,
+				StayDateFrom:  record.StayDateFrom.Time,
+				StayDateTo:    record.StayDateTo.Time,
+			})
+		}
+	}
+	for _, reservationGuest := range checkOverlappingInFile(reservation, reservationGuests, stayDateFrom, stayDateTo) {
+		if found[reservationGuest.ReservationID] {
+			continue
+		}
+		overlaps = append(overlaps, overlappingReservation{
+			ReservationID: reservationGuest.ReservationID,
+			StayDateFrom:  reservationGuest.StayDateFrom,
+			StayDateTo:    reservationGuest.StayDateTo,
+		})
 	}
-	if reservation.NameKana == "" {
-		validationStr = append(validationStr, "団体名または代表者氏名(半角)")
+	return overlaps, nil
+}
+
+// checkOverlappingInFile 同じファイルの前の行で登録した、取り消していない同一顧客（氏名、氏名カナ、電話番号が同じ）の予約のうち、宿泊期間が重複するものを返す
+func checkOverlappingInFile(reservation *scCsv.ReservationData, reservationGuests []*reservationGuest, stayDateFrom, stayDateTo time.Time) []*reservationGuest {
+	var overlaps []*reservationGuest
+	for _, reservationGuest := range reservationGuests {
+		if reservationGuest.Cancelled ||
+			reservationGuest.Name != reservation.Name ||
+			reservationGuest.NameKana != reservation.NameKana ||
+			reservationGuest.PhoneNumber != reservation.PhoneNumber {
+			continue
+		}
+		if reservationGuest.StayDateFrom.Before(stayDateTo) && reservationGuest.StayDateTo.After(stayDateFrom) {
+			overlaps = append(overlaps, reservationGuest)
+		}
 	}
-	if reservation.PhoneNumber == "" {
-		validationStr = append(validationStr, "団体または代表者番号")
+	return overlaps
+}
+
+// checkOverlappingReservations 同一顧客の削除されていない予約のうち、宿泊期間が重複するものを返す。
+// 並行する取込（異なる監視元）が同じ顧客の予約を同時に登録しないよう、顧客の予約をロックして読む
+func checkOverlappingReservations(guestID int, stayDateFrom, stayDateTo time.Time, ctx context.Context, tx *sql.Tx) (models.ReservationSlice, error) {
+	records, err := models.Reservations(
+		qm.Where(models.ReservationColumns.GuestID+" = ?", guestID),
+		qm.And(models.ReservationColumns.DeleteFlag+" = ?", 0),
+		qm.And(models.ReservationColumns.StayDateFrom+" < ?", stayDateTo),
+		qm.And(models.ReservationColumns.StayDateTo+" > ?", stayDateFrom),
+		qm.For("UPDATE"),
+	).All(ctx, tx)
+	if err != nil {
+		return nil, err
 	}
-	return validationStr
+	return records, nil
 }
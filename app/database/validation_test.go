@@ -0,0 +1,436 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	scCsv "ui-backend-for-omotebako-site-controller/app/csv"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+func TestCheckInTime(t *testing.T) {
+	// チェックイン日時は施設のタイムゾーンで解釈し、コンテナのタイムゾーン（UTC）には依存しない
+	tests := []struct {
+		name        string
+		location    *time.Location
+		reservation scCsv.ReservationData
+		want        string
+	}{
+		{
+			name:        "チェックイン時刻あり(Asia/Tokyo)",
+			location:    config.DefaultLocation(),
+			reservation: scCsv.ReservationData{StayDateFrom: "20210701", CheckInTime: "15:00"},
+			want:        "2021-07-01T15:00:00+09:00",
+		},
+		{
+			name:        "チェックイン時刻なし(Asia/Tokyo)",
+			location:    config.DefaultLocation(),
+			reservation: scCsv.ReservationData{StayDateFrom: "20210701"},
+			want:        "2021-07-01T00:00:00+09:00",
+		},
+		{
+			name:        "チェックイン時刻あり(UTC-5)",
+			location:    time.FixedZone("EST", -5*60*60),
+			reservation: scCsv.ReservationData{StayDateFrom: "20211231", CheckInTime: "23:30"},
+			want:        "2021-12-31T23:30:00-05:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkInTime(&tt.reservation, tt.location)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if got.Format(time.RFC3339) != tt.want {
+				t.Errorf("got %s, want %s", got.Format(time.RFC3339), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReservationData(t *testing.T) {
+	min, max := 1.0, 30.0
+	rules := []config.ValidationRule{
+		{Field: "Name", Label: "団体名または代表者氏名 漢字", Required: true},
+		{Field: "PostalCode", Label: "団体または代表者郵便番号", Format: `^\d{3}-?\d{4}$`},
+		{Field: "StayDays", Label: "泊数", Required: true, Min: &min, Max: &max},
+	}
+	if err := (config.ValidationRules{"test": {config.NoticeReservation: rules}}).Compile(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests := []struct {
+		name        string
+		reservation scCsv.ReservationData
+		want        []string
+	}{
+		{
+			name:        "正常系",
+			reservation: scCsv.ReservationData{Name: "テスト", PostalCode: "123-4567", StayDays: 2},
+			want:        nil,
+		},
+		{
+			name:        "郵便番号は任意",
+			reservation: scCsv.ReservationData{Name: "テスト", StayDays: 2},
+			want:        nil,
+		},
+		{
+			name:        "全ての違反を返す",
+			reservation: scCsv.ReservationData{PostalCode: "12-34", StayDays: 31},
+			want: []string{
+				"団体名または代表者氏名 漢字(Name): 未入力です",
+				"団体または代表者郵便番号(PostalCode): 形式が不正です",
+				"泊数(StayDays): 1～30の範囲外です",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateReservationData(&tt.reservation, rules)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if msg := got[i].Message(importerror.Japanese); msg != tt.want[i] {
+					t.Errorf("got %s, want %s", msg, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFieldsZero(t *testing.T) {
+	min := 1.0
+	type reservation struct {
+		StayDays      int16
+		NumberOfRooms *int16
+	}
+	zero, one := int16(0), int16(1)
+
+	tests := []struct {
+		name        string
+		rules       []config.ValidationRule
+		reservation reservation
+		want        []importerror.Code
+	}{
+		{
+			name:        "必須の数値項目の0は未入力",
+			rules:       []config.ValidationRule{{Field: "StayDays", Required: true, Min: &min}},
+			reservation: reservation{StayDays: 0},
+			want:        []importerror.Code{importerror.CodeRequired},
+		},
+		{
+			name:        "任意の数値項目の0は範囲を確認しない",
+			rules:       []config.ValidationRule{{Field: "StayDays", Min: &min}},
+			reservation: reservation{StayDays: 0},
+			want:        nil,
+		},
+		{
+			name:        "ポインタの項目はnilが未入力",
+			rules:       []config.ValidationRule{{Field: "NumberOfRooms", Min: &min}},
+			reservation: reservation{},
+			want:        nil,
+		},
+		{
+			name:        "ポインタの項目は0も値",
+			rules:       []config.ValidationRule{{Field: "NumberOfRooms", Required: true, Min: &min}},
+			reservation: reservation{NumberOfRooms: &zero},
+			want:        []importerror.Code{importerror.CodeOutOfRange},
+		},
+		{
+			name:        "ポインタの項目の必須",
+			rules:       []config.ValidationRule{{Field: "NumberOfRooms", Required: true, Min: &min}},
+			reservation: reservation{},
+			want:        []importerror.Code{importerror.CodeRequired},
+		},
+		{
+			name:        "範囲内",
+			rules:       []config.ValidationRule{{Field: "StayDays", Min: &min}, {Field: "NumberOfRooms", Min: &min}},
+			reservation: reservation{StayDays: 1, NumberOfRooms: &one},
+			want:        nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []importerror.Code
+			for _, err := range validateFields(reflect.ValueOf(&tt.reservation).Elem(), tt.rules) {
+				got = append(got, err.Code)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFieldsSumOf(t *testing.T) {
+	rules := []config.ValidationRule{
+		{Field: "NumberOfGuests", Label: "お客様総合計人数", SumOf: []string{"NumberOfGuestsMale", "NumberOfGuestsFemale", "NumberOfGuestsChildA"}},
+	}
+	tests := []struct {
+		name        string
+		reservation scCsv.ReservationData
+		want        []importerror.Code
+	}{
+		{
+			name:        "一致",
+			reservation: scCsv.ReservationData{NumberOfGuests: 3, NumberOfGuestsMale: 1, NumberOfGuestsFemale: 1, NumberOfGuestsChildA: 1},
+		},
+		{
+			name:        "不一致",
+			reservation: scCsv.ReservationData{NumberOfGuests: 2, NumberOfGuestsMale: 1, NumberOfGuestsFemale: 1, NumberOfGuestsChildA: 1},
+			want:        []importerror.Code{importerror.CodeSumMismatch},
+		},
+		{
+			name:        "未入力の場合は照合しない",
+			reservation: scCsv.ReservationData{NumberOfGuestsMale: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []importerror.Code
+			for _, err := range validateReservationData(&tt.reservation, rules) {
+				got = append(got, err.Code)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	errs := validateReservationData(&scCsv.ReservationData{NumberOfGuests: 2, NumberOfGuestsMale: 3}, rules)
+	want := "お客様総合計人数(NumberOfGuests): 2がNumberOfGuestsMale+NumberOfGuestsFemale+NumberOfGuestsChildAの合計(3)と一致しません"
+	if len(errs) != 1 || errs[0].Message(importerror.Japanese) != want {
+		t.Errorf("got %v, want %s", errs, want)
+	}
+	if !hasSumRule(rules, "NumberOfGuests") || hasSumRule(rules, "StayDays") {
+		t.Errorf("hasSumRule should be true only for NumberOfGuests")
+	}
+}
+
+func TestCheckGuestCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		reservation scCsv.ReservationData
+		want        bool
+	}{
+		{
+			name:        "一致",
+			reservation: scCsv.ReservationData{NumberOfGuests: 4, NumberOfGuestsMale: 1, NumberOfGuestsFemale: 1, NumberOfGuestsChildA: 1, NumberOfGuestsChildD: 1},
+			want:        false,
+		},
+		{
+			name:        "男女別の人数がない場合は大人人数、添乗員は含めない",
+			reservation: scCsv.ReservationData{NumberOfGuests: 3, NumberOfGuestsAdult: 2, NumberOfGuestsChildC: 1, NumberOfGuide: 1},
+			want:        false,
+		},
+		{
+			name:        "子供の人数が含まれていない",
+			reservation: scCsv.ReservationData{NumberOfGuests: 2, NumberOfGuestsMale: 1, NumberOfGuestsFemale: 1, NumberOfGuestsChildB: 1},
+			want:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkGuestCount(&tt.reservation, newGuestCount(&tt.reservation))
+			if (got != nil) != tt.want {
+				t.Fatalf("got %v, want warning: %v", got, tt.want)
+			}
+			if got != nil && got.Severity() != importerror.SeverityWarning {
+				t.Errorf("got %s, want %s", got.Severity(), importerror.SeverityWarning)
+			}
+		})
+	}
+}
+
+func TestCheckOverlappingInFile(t *testing.T) {
+	location := config.DefaultLocation()
+	date := func(day int) time.Time {
+		return time.Date(2030, 1, day, 0, 0, 0, 0, location)
+	}
+	guest := scCsv.ReservationData{Name: "テスト", NameKana: "テスト", PhoneNumber: "0312345678"}
+	other := scCsv.ReservationData{Name: "テスト", NameKana: "テスト", PhoneNumber: "0398765432"}
+	reservationGuests := []*reservationGuest{
+		{ReservationID: 1, StayDateFrom: date(1), StayDateTo: date(3), ReservationData: &guest},
+		// 取り消した予約
+		{ReservationID: 2, StayDateFrom: date(2), StayDateTo: date(4), Cancelled: true, ReservationData: &guest},
+		// 別の顧客
+		{ReservationID: 3, StayDateFrom: date(2), StayDateTo: date(4), ReservationData: &other},
+		{ReservationID: 4, StayDateFrom: date(5), StayDateTo: date(6), ReservationData: &guest},
+	}
+
+	tests := []struct {
+		name string
+		from int
+		to   int
+		want []int
+	}{
+		{name: "重複", from: 2, to: 5, want: []int{1}},
+		{name: "チェックアウト日にチェックイン", from: 3, to: 5, want: nil},
+		{name: "複数の予約と重複", from: 1, to: 7, want: []int{1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, overlap := range checkOverlappingInFile(&guest, reservationGuests, date(tt.from), date(tt.to)) {
+				got = append(got, overlap.ReservationID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInFileReservationID(t *testing.T) {
+	guest := scCsv.ReservationData{Name: "テスト", NameKana: "テスト", PhoneNumber: "0312345678"}
+	reservationGuests := []*reservationGuest{
+		// 取り消した予約
+		{ReservationID: 1, Cancelled: true, ReservationData: &guest},
+		{ReservationID: 2, ReservationData: &guest},
+	}
+	if got := inFileReservationID(&guest, reservationGuests); got != 2 {
+		t.Errorf("got %d, want 2", got)
+	}
+	reservationGuests[1].Cancelled = true
+	if got := inFileReservationID(&guest, reservationGuests); got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+}
+
+func TestRestoreReservationGuests(t *testing.T) {
+	location := config.DefaultLocation()
+	newReservation := func(notice string) *scCsv.ReservationData {
+		return &scCsv.ReservationData{Notice: notice, Name: "テスト", NameKana: "テスト", PhoneNumber: "+81-3-1234-5678", StayDateFrom: "20300101", StayDateTo: "20300103"}
+	}
+	reservations := []*scCsv.ReservationData{
+		newReservation(config.NoticeReservation),
+		newReservation(config.NoticeReservation),
+		// 取込に失敗した行
+		newReservation(config.NoticeReservation),
+		newReservation(config.NoticeCancel),
+	}
+	succeeded := map[int]*models.CSVExecutionLine{
+		1: {LineNumber: 1, ReservationID: null.IntFrom(10), GuestID: null.IntFrom(20)},
+		2: {LineNumber: 2, ReservationID: null.IntFrom(11), GuestID: null.IntFrom(21)},
+		4: {LineNumber: 4, ReservationID: null.IntFrom(10), GuestID: null.IntFrom(20)},
+	}
+
+	got := restoreReservationGuests(reservations, []int{0, 1, 2, 3}, succeeded, location)
+	if len(got) != 2 {
+		t.Fatalf("got %d reservations, want 2", len(got))
+	}
+	if got[0].ReservationID != 10 || !got[0].Cancelled || got[1].ReservationID != 11 || got[1].Cancelled {
+		t.Errorf("got %+v, %+v", got[0], got[1])
+	}
+	if got[1].GuestID != 21 || !got[1].StayDateFrom.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, location)) || !got[1].StayDateTo.Equal(time.Date(2030, 1, 3, 0, 0, 0, 0, location)) {
+		t.Errorf("got %+v", got[1])
+	}
+	// 登録しない行も正規化する
+	if reservations[2].PhoneNumber != "0312345678" {
+		t.Errorf("got phone number %s, want normalized", reservations[2].PhoneNumber)
+	}
+}
+
+func TestOverlappingReservations(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	tx := beginTestTx(t, ctx, db)
+	date := func(day int) time.Time {
+		return time.Date(2030, 1, day, 0, 0, 0, 0, db.Location)
+	}
+
+	guest := models.Guest{Name: null.StringFrom("重複テスト"), NameKana: null.StringFrom("ジュウフクテスト"), PhoneNumber: null.StringFrom("0312345678")}
+	if err := guest.Insert(ctx, tx, boil.Infer()); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, r := range []struct {
+		from, to   int
+		deleteFlag int8
+	}{{1, 3, 0}, {2, 4, 1}, {5, 6, 0}} {
+		reservation := models.Reservation{
+			GuestID:      null.IntFrom(guest.GuestID),
+			StayDateFrom: null.TimeFrom(date(r.from)),
+			StayDateTo:   null.TimeFrom(date(r.to)),
+			DeleteFlag:   null.Int8From(r.deleteFlag),
+		}
+		if err := reservation.Insert(ctx, tx, boil.Infer()); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, reservation.ReservationID)
+	}
+
+	t.Run("登録済みの予約", func(t *testing.T) {
+		// 削除した予約は含めない
+		records, err := checkOverlappingReservations(guest.GuestID, date(2), date(6), ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, record := range records {
+			got = append(got, record.ReservationID)
+		}
+		if want := []int{ids[0], ids[2]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	reservation := scCsv.ReservationData{Name: "重複テスト", NameKana: "ジュウフクテスト", PhoneNumber: "0312345678"}
+	t.Run("同じファイルで登録した新規顧客の予約", func(t *testing.T) {
+		reservationGuests := []*reservationGuest{
+			{ReservationID: -1, StayDateFrom: date(1), StayDateTo: date(3), ReservationData: &reservation},
+		}
+		overlaps, err := overlappingReservations(0, &reservation, reservationGuests, date(2), date(3), ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(overlaps) != 1 || overlaps[0].ReservationID != -1 {
+			t.Errorf("got %v, want reservation -1", overlaps)
+		}
+	})
+	t.Run("同じファイルで登録済みの予約は重複して返さない", func(t *testing.T) {
+		reservationGuests := []*reservationGuest{
+			{ReservationID: ids[0], StayDateFrom: date(1), StayDateTo: date(3), ReservationData: &reservation},
+		}
+		overlaps, err := overlappingReservations(guest.GuestID, &reservation, reservationGuests, date(2), date(3), ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(overlaps) != 1 || overlaps[0].ReservationID != ids[0] {
+			t.Errorf("got %v, want reservation %d", overlaps, ids[0])
+		}
+	})
+}
+
+func TestGuestGender(t *testing.T) {
+	tests := []struct {
+		name         string
+		reservation  scCsv.ReservationData
+		want         int
+		wantProvided bool
+	}{
+		{name: "男性のみ", reservation: scCsv.ReservationData{NumberOfGuestsMale: 2}, want: genderMale, wantProvided: true},
+		{name: "女性のみ", reservation: scCsv.ReservationData{NumberOfGuestsFemale: 1, NumberOfGuestsChildA: 1}, want: genderFemale, wantProvided: true},
+		{name: "男女", reservation: scCsv.ReservationData{NumberOfGuestsMale: 1, NumberOfGuestsFemale: 1}, want: genderFemale, wantProvided: false},
+		{name: "男女別の人数なし", reservation: scCsv.ReservationData{NumberOfGuestsAdult: 2}, want: genderFemale, wantProvided: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, provided := guestGender(newGuestCount(&tt.reservation))
+			if got != tt.want || provided != tt.wantProvided {
+				t.Errorf("got %d, %v, want %d, %v", got, provided, tt.want, tt.wantProvided)
+			}
+		})
+	}
+}
@@ -1,13 +1,27 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"io/fs"
+	"os"
 	"time"
 )
 
 type File struct {
 	Name        string
 	CreatedTime time.Time
+	// Path 監視ディレクトリからの相対パス
+	Path string
+	Size int64
+	// Hash 内容のSHA-256
+	Hash string
+	// LedgerID 処理済みファイル台帳（processed_files）のID
+	LedgerID int
+	// FirstNotificationNumber, LastNotificationNumber ファイル内の通知番号の最小・最大（通知番号がない場合は0）
+	FirstNotificationNumber int
+	LastNotificationNumber  int
 }
 
 type Files []*File
@@ -16,5 +30,25 @@ func NewFile(file fs.FileInfo) *File {
 	return &File{
 		Name:        file.Name(),
 		CreatedTime: file.ModTime(),
+		Size:        file.Size(),
 	}
 }
+
+// Hash ファイルの内容のSHA-256を16進数で返す
+func Hash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return HashReader(f)
+}
+
+// HashReader rの内容のSHA-256を16進数で返す
+func HashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
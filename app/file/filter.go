@@ -0,0 +1,120 @@
+package file
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// RegexpPrefix この接頭辞で始まるパターンは正規表現として扱う（例：re:^予約_\d{8}\.csv$）
+const RegexpPrefix = "re:"
+
+// Pattern 監視対象のファイルのパターン。
+// globは「/」を含む場合は監視するディレクトリからの相対パスと、含まない場合はファイル（ディレクトリ）名と比較する。
+// 正規表現は相対パスと比較する
+type Pattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+func NewPattern(pattern string) (*Pattern, error) {
+	if strings.HasPrefix(pattern, RegexpPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, RegexpPrefix))
+		if err != nil {
+			return nil, xerrors.Errorf("invalid regexp %s: %w", pattern, err)
+		}
+		return &Pattern{regexp: re}, nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, xerrors.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	return &Pattern{glob: pattern}, nil
+}
+
+// Match pathは監視するディレクトリからの相対パス（区切りは/）
+func (p *Pattern) Match(path string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(path)
+	}
+	target := path
+	if !strings.Contains(p.glob, "/") {
+		target = filepath.Base(filepath.FromSlash(path))
+	}
+	matched, _ := filepath.Match(p.glob, target)
+	return matched
+}
+
+// Filter 監視対象にするファイルの条件
+type Filter struct {
+	// Include いずれかに一致するファイルを対象にする。空の場合は全て
+	Include []*Pattern
+	// Exclude いずれかに一致するファイル・ディレクトリは対象外
+	Exclude []*Pattern
+	// MaxDepth 1は監視するディレクトリ直下のみ、2はその1つ下のディレクトリまで。0は無制限
+	MaxDepth int
+	// MaxAge 更新日時がこれより古いファイルは対象外。0は無制限
+	MaxAge time.Duration
+}
+
+func NewFilter(include, exclude []string, maxDepth int, maxAge time.Duration) (*Filter, error) {
+	filter := &Filter{MaxDepth: maxDepth, MaxAge: maxAge}
+	for _, pattern := range include {
+		p, err := NewPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.Include = append(filter.Include, p)
+	}
+	for _, pattern := range exclude {
+		p, err := NewPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.Exclude = append(filter.Exclude, p)
+	}
+	return filter, nil
+}
+
+// skipDir ディレクトリをたどらないか
+func (f *Filter) skipDir(path string) bool {
+	if f == nil {
+		return false
+	}
+	if f.MaxDepth > 0 && depth(path) >= f.MaxDepth {
+		return true
+	}
+	return matchAny(f.Exclude, path)
+}
+
+// accept ファイルを対象にするか
+func (f *Filter) accept(path string, modTime time.Time, now time.Time) bool {
+	if f == nil {
+		return true
+	}
+	if f.MaxDepth > 0 && depth(path) > f.MaxDepth {
+		return false
+	}
+	if f.MaxAge > 0 && now.Sub(modTime) > f.MaxAge {
+		return false
+	}
+	if matchAny(f.Exclude, path) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, path)
+}
+
+func depth(path string) int {
+	return strings.Count(path, "/") + 1
+}
+
+func matchAny(patterns []*Pattern, path string) bool {
+	for _, p := range patterns {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
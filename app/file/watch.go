@@ -3,34 +3,74 @@ package file
 import (
 	"fmt"
 	"io/fs"
+	"io/ioutil"
+	"path"
 	"path/filepath"
 	"sort"
 	"time"
 )
 
-func GetFileList(latestFileCreatedTime *time.Time, watchDirPath string) (Files, error) {
-	var fileList Files
-	err := filepath.Walk(watchDirPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			if latestFileCreatedTime.Before(info.ModTime()) {
-				file := NewFile(info)
-				fileList = append(fileList, file)
-			}
-		}
-		return nil
-	})
+// FileSystem ListFilesでたどるファイルシステム（ローカルのディレクトリ、SFTP等）
+type FileSystem interface {
+	// ReadDir ディレクトリ内のファイルを名前順に返す。dirはルートからの相対パス（/区切り、ルートは"."）
+	ReadDir(dir string) ([]fs.FileInfo, error)
+}
+
+// Dir ローカルのディレクトリをルートにするFileSystem
+type Dir string
+
+func (d Dir) ReadDir(dir string) ([]fs.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(string(d), filepath.FromSlash(dir)))
+}
+
+// GetFileList filterの条件に一致するファイルを返す（filterがnilの場合は全て）。
+// 処理済みかどうかは処理済みファイル台帳で判定する
+func GetFileList(watchDirPath string, filter *Filter) (Files, error) {
+	fileList, err := ListFiles(Dir(watchDirPath), filter)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get file list in %v: %v", watchDirPath, err)
 	}
-	// 新しい順に並び替え
+	return fileList, nil
+}
+
+// ListFiles fsysをたどり、filterの条件に一致するファイルを古い順（同じ日時はパス順）に返す。Pathはルートからの相対パス
+func ListFiles(fsys FileSystem, filter *Filter) (Files, error) {
+	var fileList Files
+	if err := listFiles(fsys, ".", filter, time.Now(), &fileList); err != nil {
+		return nil, err
+	}
+	// 予約より先に取消を取り込まないよう、古い順に並び替え（同じ日時はパス順）
 	sort.Slice(fileList, func(i, j int) bool {
-		return fileList[i].CreatedTime.Unix() > fileList[j].CreatedTime.Unix()
+		if !fileList[i].CreatedTime.Equal(fileList[j].CreatedTime) {
+			return fileList[i].CreatedTime.Before(fileList[j].CreatedTime)
+		}
+		return fileList[i].Path < fileList[j].Path
 	})
-	for _, file := range fileList {
-		fmt.Printf("%s\n", file.Name)
-	}
 	return fileList, nil
 }
+
+func listFiles(fsys FileSystem, dir string, filter *Filter, now time.Time, fileList *Files) error {
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		rel := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if filter.skipDir(rel) {
+				continue
+			}
+			if err := listFiles(fsys, rel, filter, now, fileList); err != nil {
+				return err
+			}
+			continue
+		}
+		if !filter.accept(rel, info.ModTime(), now) {
+			continue
+		}
+		file := NewFile(info)
+		file.Path = rel
+		*fileList = append(*fileList, file)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetFileList(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"a.csv", "b.txt", "sub/c.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("予約,"+name), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	filter, err := NewFilter([]string{"*.csv"}, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	files, err := GetFileList(dir, filter)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	paths := map[string]bool{}
+	for _, f := range files {
+		paths[f.Path] = true
+	}
+	if len(paths) != 2 || !paths["a.csv"] || !paths["sub/c.csv"] {
+		t.Errorf("got %v, want a.csv and sub/c.csv", paths)
+	}
+
+	// 内容が同じファイルは同じハッシュになる
+	if err := os.WriteFile(filepath.Join(dir, "copy.csv"), []byte("予約,a.csv"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h1, err := Hash(filepath.Join(dir, "a.csv"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	h2, err := Hash(filepath.Join(dir, "copy.csv"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	h3, err := Hash(filepath.Join(dir, "sub/c.csv"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if h1 != h2 || h1 == h3 {
+		t.Errorf("unexpected hashes: %s, %s, %s", h1, h2, h3)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter(
+		[]string{"*.csv", `re:^lincoln/\d{8}\.txt$`},
+		[]string{"~$*", `re:(?i)(^|/)desktop\.ini$`, "other/*"},
+		2, 24*time.Hour,
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+	cases := []struct {
+		path    string
+		modTime time.Time
+		want    bool
+	}{
+		{"a.csv", recent, true},
+		{"sub/a.csv", recent, true},
+		{"sub/sub/a.csv", recent, false},
+		{"a.csv", now.Add(-48 * time.Hour), false},
+		{"~$a.csv", recent, false},
+		{"other/a.csv", recent, false},
+		{"lincoln/20210401.txt", recent, true},
+		{"annex/20210401.txt", recent, false},
+		{"sub/Desktop.ini", recent, false},
+	}
+	for _, c := range cases {
+		if got := filter.accept(c.path, c.modTime, now); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.path, got, c.want)
+		}
+	}
+	if !filter.skipDir("sub/sub") || filter.skipDir("sub") {
+		t.Errorf("max depth is not applied to directories")
+	}
+
+	if _, err := NewFilter([]string{"re:("}, nil, 0, 0); err == nil {
+		t.Errorf("invalid regexp: want error")
+	}
+}
@@ -1,11 +1,72 @@
 package helper
 
-import "strings"
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+var (
+	// ハイフン、長音記号等の区切り文字
+	separatorReplacer = strings.NewReplacer(
+		"-", "", "‐", "", "‑", "", "‒", "", "–", "", "—", "", "―", "", "−", "", "ー", "", "ｰ", "",
+		" ", "", "　", "", "(", "", ")", "", "〒", "",
+	)
+	postalCodeRegexp  = regexp.MustCompile(`^\d{7}$`)
+	phoneNumberRegexp = regexp.MustCompile(`^0\d{9,10}$`)
+	e164Regexp        = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+)
+
+// japanCountryCode E.164形式の日本の国番号
+const japanCountryCode = "+81"
+
+// ToHalfWidth 全角英数字・記号を半角に変換する（半角カナは全角になる）
+func ToHalfWidth(s string) string {
+	return width.Fold.String(s)
+}
 
 // PostalCodeFormat format the zip code; 1234567 to 123-4567
+// 7桁でない場合は入力値をそのまま返す
 func PostalCodeFormat(postalCode string) string {
-	if strings.Contains(postalCode, "-") {
+	normalized, err := NormalizePostalCode(postalCode)
+	if err != nil {
 		return postalCode
 	}
-	return postalCode[:3] + "-" + postalCode[3:]
+	return normalized
+}
+
+// NormalizePostalCode 郵便番号を123-4567の形式にする。7桁でない場合はエラーを返す
+func NormalizePostalCode(postalCode string) (string, error) {
+	digits := separatorReplacer.Replace(ToHalfWidth(strings.TrimSpace(postalCode)))
+	if !postalCodeRegexp.MatchString(digits) {
+		return "", fmt.Errorf("postal code should be 7 digits: %s", postalCode)
+	}
+	return digits[:3] + "-" + digits[3:], nil
+}
+
+// NormalizePhoneNumber 電話番号を数字のみ（国内番号）またはE.164形式にする。
+// 登録済みの電話番号と照合できるよう、日本の番号（+81）は国内番号にする
+func NormalizePhoneNumber(phoneNumber string) (string, error) {
+	normalized := separatorReplacer.Replace(ToHalfWidth(strings.TrimSpace(phoneNumber)))
+	if strings.HasPrefix(normalized, japanCountryCode) {
+		// +81(0)3…のように市外局番の0を残した番号もある
+		normalized = "0" + strings.TrimPrefix(strings.TrimPrefix(normalized, japanCountryCode), "0")
+	}
+	if phoneNumberRegexp.MatchString(normalized) || e164Regexp.MatchString(normalized) {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("phone number should be digits only or E.164: %s", phoneNumber)
+}
+
+// NormalizeEmail メールアドレスを半角にし、構文をチェックする
+func NormalizeEmail(email string) (string, error) {
+	normalized := strings.TrimSpace(ToHalfWidth(email))
+	address, err := mail.ParseAddress(normalized)
+	if err != nil || address.Address != normalized {
+		return "", fmt.Errorf("invalid email address: %s", email)
+	}
+	return normalized, nil
 }
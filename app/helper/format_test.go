@@ -0,0 +1,109 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizePostalCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "ハイフンなし", input: "1234567", want: "123-4567"},
+		{name: "ハイフンあり", input: "123-4567", want: "123-4567"},
+		{name: "全角", input: "〒１２３－４５６７", want: "123-4567"},
+		{name: "桁数不足", input: "12", wantErr: true},
+		{name: "数字以外", input: "abc-defg", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePostalCode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostalCodeFormat(t *testing.T) {
+	// 7桁未満でもpanicしないこと
+	if got := PostalCodeFormat("12"); got != "12" {
+		t.Errorf("got %s, want 12", got)
+	}
+	if got := PostalCodeFormat("1234567"); got != "123-4567" {
+		t.Errorf("got %s, want 123-4567", got)
+	}
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "ハイフンあり", input: "090-1234-5678", want: "09012345678"},
+		{name: "固定電話", input: "03(1234)5678", want: "0312345678"},
+		{name: "全角", input: "０９０－１２３４－５６７８", want: "09012345678"},
+		{name: "E.164の日本の番号は国内番号", input: "+81 90 1234 5678", want: "09012345678"},
+		{name: "E.164の市外局番の0", input: "+81(0)3-1234-5678", want: "0312345678"},
+		{name: "E.164の海外の番号", input: "+1 212 555 0123", want: "+12125550123"},
+		{name: "E.164の日本の番号の桁数不足", input: "+81 90 1234", wantErr: true},
+		{name: "桁数不足", input: "090-1234", wantErr: true},
+		{name: "数字以外", input: "なし", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizePhoneNumberMatchesStored(t *testing.T) {
+	// 登録済みの電話番号はハイフンを除いて照合する（REPLACE(phone,'-','')）
+	stored := strings.ReplaceAll("03-1234-5678", "-", "")
+	got, err := NormalizePhoneNumber("+81-3-1234-5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != stored {
+		t.Errorf("got %s, want %s", got, stored)
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "正常系", input: "test@example.com", want: "test@example.com"},
+		{name: "全角", input: "ｔｅｓｔ＠ｅｘａｍｐｌｅ．ｃｏｍ", want: "test@example.com"},
+		{name: "アットマークなし", input: "test.example.com", wantErr: true},
+		{name: "表示名付き", input: "Test <test@example.com>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package helper
+
+import "time"
+
+// timestampLayout 画面から登録した日時（YYYYMMDDhhmmss）
+const timestampLayout = "20060102150405"
+
+// ParseTimestamp タイムスタンプ（YYYYMMDDhhmmss）を施設のタイムゾーンの日時として読み込む
+func ParseTimestamp(timestamp string, location *time.Location) (time.Time, error) {
+	return time.ParseInLocation(timestampLayout, timestamp, location)
+}
+
+// FormatTimestamp タイムスタンプ（YYYYMMDDhhmmss）を施設のタイムゾーンの日時として、オフセット付きISO 8601にする
+func FormatTimestamp(timestamp string, location *time.Location) (string, error) {
+	t, err := ParseTimestamp(timestamp, location)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(time.RFC3339), nil
+}
@@ -0,0 +1,53 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTimestamp(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		timestamp string
+		location  *time.Location
+		want      string
+		wantErr   bool
+	}{
+		{name: "Asia/Tokyo", timestamp: "20210701150000", location: tokyo, want: "2021-07-01T15:00:00+09:00"},
+		{name: "UTC-5", timestamp: "20211231233000", location: time.FixedZone("EST", -5*60*60), want: "2021-12-31T23:30:00-05:00"},
+		{name: "UTC", timestamp: "20210701150000", location: time.UTC, want: "2021-07-01T15:00:00Z"},
+		{name: "不正な値", timestamp: "2021-07-01", location: tokyo, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FormatTimestamp(tt.timestamp, tt.location)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseTimestamp("20210701150000", tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2021, 7, 1, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := ParseTimestamp("", tokyo); err == nil {
+		t.Error("got no error for empty timestamp")
+	}
+}
@@ -0,0 +1,113 @@
+package importerror
+
+// Code 取込エラー・警告のコード。DBに保存するため値を変更しないこと
+type Code string
+
+const (
+	CodeUnknown       Code = "UNKNOWN"
+	CodeUnknownNotice Code = "UNKNOWN_NOTICE"
+
+	// 予約
+	CodeInvalidCheckInDate       Code = "INVALID_CHECK_IN_DATE"
+	CodeInvalidCheckOutDate      Code = "INVALID_CHECK_OUT_DATE"
+	CodeInvalidReservationDate   Code = "INVALID_RESERVATION_DATE"
+	CodeInvalidReservationMethod Code = "INVALID_RESERVATION_METHOD"
+	CodeInvalidUseOfDay          Code = "INVALID_USE_OF_DAY"
+	CodeRoomInventoryCheckFailed Code = "ROOM_INVENTORY_CHECK_FAILED"
+	CodeDuplicateCheckFailed     Code = "DUPLICATE_CHECK_FAILED"
+	CodeDuplicateReservation     Code = "DUPLICATE_RESERVATION"
+	CodeGuestInsertFailed        Code = "GUEST_INSERT_FAILED"
+	CodeGuestUpdateFailed        Code = "GUEST_UPDATE_FAILED"
+	CodeReservationInsertFailed  Code = "RESERVATION_INSERT_FAILED"
+	CodeReservationRoomFailed    Code = "RESERVATION_ROOM_INSERT_FAILED"
+	CodeOverbookingInsertFailed  Code = "OVERBOOKING_INSERT_FAILED"
+
+	// 取消
+	CodeCancelFailed           Code = "CANCEL_FAILED"
+	CodeCancelRoomDeleteFailed Code = "CANCEL_ROOM_DELETE_FAILED"
+	CodeCancelGuestFetchFailed Code = "CANCEL_GUEST_FETCH_FAILED"
+	CodeCancelNotFound         Code = "CANCEL_NOT_FOUND"
+	CodeCancelMultiple         Code = "CANCEL_MULTIPLE"
+	CodeCancelNotIdentified    Code = "CANCEL_NOT_IDENTIFIED"
+	CodeCancelFetchFailed      Code = "CANCEL_FETCH_FAILED"
+
+	// 検証ルール
+	CodeRequired      Code = "REQUIRED"
+	CodeInvalidFormat Code = "INVALID_FORMAT"
+	CodeOutOfRange    Code = "OUT_OF_RANGE"
+	CodeNotNumber     Code = "NOT_NUMBER"
+	CodeSumMismatch   Code = "SUM_MISMATCH"
+	CodeUnknownField  Code = "UNKNOWN_FIELD"
+	// CodeUnsupportedField 文字列・数値以外の項目にルールが指定された
+	CodeUnsupportedField Code = "UNSUPPORTED_FIELD"
+
+	// 警告
+	CodeOverbooking         Code = "OVERBOOKING"
+	CodeSuspectedDuplicate  Code = "SUSPECTED_DUPLICATE_RESERVATION"
+	CodeInvalidPhoneNumber  Code = "INVALID_PHONE_NUMBER"
+	CodeInvalidPostalCode   Code = "INVALID_POSTAL_CODE"
+	CodeInvalidEmail        Code = "INVALID_EMAIL"
+	CodePaymentMethodFailed Code = "PAYMENT_METHOD_FAILED"
+	CodeProductNotFound     Code = "PRODUCT_NOT_FOUND"
+	CodeGenderDefaulted     Code = "GENDER_DEFAULTED"
+	CodeGuestCountMismatch  Code = "GUEST_COUNT_MISMATCH"
+)
+
+type entry struct {
+	severity Severity
+	ja       string
+	en       string
+}
+
+var catalog = map[Code]entry{
+	CodeUnknown:       {SeverityError, "取込に失敗しました。: {detail}", "Import failed: {detail}"},
+	CodeUnknownNotice: {SeverityError, "通知種別が不正です。: {notice}", "Unknown notice type: {notice}"},
+
+	CodeInvalidCheckInDate:       {SeverityError, "チェックイン日が不正か入力されていません。", "Check-in date is invalid or missing."},
+	CodeInvalidCheckOutDate:      {SeverityError, "チェックアウト日が不正か入力されていません。", "Check-out date is invalid or missing."},
+	CodeInvalidReservationDate:   {SeverityError, "予約受信日が不正か入力されていません。", "Reservation date is invalid or missing."},
+	CodeInvalidReservationMethod: {SeverityError, "予約経路が不正か入力されていません。", "Reservation channel is invalid or missing."},
+	CodeInvalidUseOfDay:          {SeverityError, "利用日が不正です。: {value}", "Date of use is invalid: {value}"},
+	CodeRoomInventoryCheckFailed: {SeverityError, "部屋在庫の確認に失敗しました。", "Failed to check room inventory."},
+	CodeDuplicateCheckFailed:     {SeverityError, "重複予約の確認に失敗しました。", "Failed to check for duplicate reservations."},
+	CodeDuplicateReservation:     {SeverityError, "同一顧客の宿泊期間が重複する予約が既に登録されています。予約ID: {reservation_id} ({stay_date_from}～{stay_date_to})", "The guest already has a reservation for overlapping dates. Reservation ID: {reservation_id} ({stay_date_from} - {stay_date_to})"},
+	CodeGuestInsertFailed:        {SeverityError, "顧客情報の登録に失敗しました。", "Failed to register the guest."},
+	CodeGuestUpdateFailed:        {SeverityError, "顧客情報の更新に失敗しました。", "Failed to update the guest."},
+	CodeReservationInsertFailed:  {SeverityError, "予約情報の登録に失敗しました。", "Failed to register the reservation."},
+	CodeReservationRoomFailed:    {SeverityError, "予約の利用室数の登録に失敗しました。", "Failed to register the rooms of the reservation."},
+	CodeOverbookingInsertFailed:  {SeverityError, "オーバーブッキング情報の登録に失敗しました。", "Failed to register the overbooking."},
+
+	CodeCancelFailed:           {SeverityError, "予約のキャンセルに失敗しました。", "Failed to cancel the reservation."},
+	CodeCancelRoomDeleteFailed: {SeverityError, "予約の利用室数の削除に失敗しました。", "Failed to delete the rooms of the cancelled reservation."},
+	CodeCancelGuestFetchFailed: {SeverityError, "キャンセルする顧客の取得に失敗しました。", "Failed to find the guest of the cancellation."},
+	CodeCancelNotFound:         {SeverityError, "キャンセルする予約が登録されていません。", "The reservation to cancel is not registered."},
+	CodeCancelMultiple:         {SeverityError, "キャンセルする同一予約が複数登録されています。", "Multiple reservations match the cancellation."},
+	CodeCancelNotIdentified:    {SeverityError, "キャンセルする予約を特定できませんでした。", "Could not identify the reservation to cancel."},
+	CodeCancelFetchFailed:      {SeverityError, "キャンセルする予約の取得に失敗しました。", "Failed to fetch the reservation to cancel."},
+
+	CodeRequired:         {SeverityError, "{label}({field}): 未入力です", "{field} is required."},
+	CodeInvalidFormat:    {SeverityError, "{label}({field}): 形式が不正です", "{field} has an invalid format."},
+	CodeOutOfRange:       {SeverityError, "{label}({field}): {min}～{max}の範囲外です", "{field} is out of range ({min} - {max})."},
+	CodeNotNumber:        {SeverityError, "{label}({field}): 数値ではありません", "{field} is not a number."},
+	CodeSumMismatch:      {SeverityError, "{label}({field}): {value}が{fields}の合計({total})と一致しません", "{field} ({value}) does not match the total of {fields} ({total})."},
+	CodeUnknownField:     {SeverityError, "{label}({field}): 未定義の項目です", "{field} is not defined in the import data."},
+	CodeUnsupportedField: {SeverityError, "{label}({field}): 検証できない項目です", "{field} cannot be validated."},
+
+	CodeOverbooking:         {SeverityWarning, "オーバーブッキングの可能性があります。部屋タイプ: {room_type}, 利用日: {stay_date}, 予約室数: {requested_rooms}, 残室数: {remaining_rooms}", "Possible overbooking. Room type: {room_type}, date: {stay_date}, requested rooms: {requested_rooms}, remaining rooms: {remaining_rooms}"},
+	CodeSuspectedDuplicate:  {SeverityWarning, "同一顧客の宿泊期間が重複する予約があります。予約ID: {reservation_id} ({stay_date_from}～{stay_date_to})", "The guest has another reservation for overlapping dates. Reservation ID: {reservation_id} ({stay_date_from} - {stay_date_to})"},
+	CodeInvalidPhoneNumber:  {SeverityWarning, "{label}の形式が不正です。: {value}", "{field} is not a valid phone number: {value}"},
+	CodeInvalidPostalCode:   {SeverityWarning, "{label}の形式が不正です。: {value}", "{field} is not a valid 7-digit postal code: {value}"},
+	CodeInvalidEmail:        {SeverityWarning, "{label}の形式が不正です。: {value}", "{field} is not a valid email address: {value}"},
+	CodePaymentMethodFailed: {SeverityWarning, "支払方法を登録できなかったため、支払方法なしで取り込みました。: {payment_method}", "The payment method could not be registered, so the reservation was imported without it: {payment_method}"},
+	CodeProductNotFound:     {SeverityWarning, "プランがプランマスタに登録されていないため、プランなしで取り込みました。プランコード: {product_code}, プラン名: {product_name}", "The plan is not registered in the product master, so the reservation was imported without it. Plan code: {product_code}, plan name: {product_name}"},
+	CodeGenderDefaulted:     {SeverityWarning, "性別が連携されないため、新規顧客を女性として登録しました。", "The gender was not provided, so the new guest was registered as female."},
+	CodeGuestCountMismatch:  {SeverityWarning, "お客様総合計人数({number_of_guests})が大人・子供の合計({total})と一致しません。", "The number of guests ({number_of_guests}) does not match the total of adults and children ({total})."},
+}
+
+// Severity カタログにないコードはエラーとして扱う
+func (c Code) Severity() Severity {
+	if entry, ok := catalog[c]; ok {
+		return entry.severity
+	}
+	return SeverityError
+}
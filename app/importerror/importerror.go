@@ -0,0 +1,90 @@
+package importerror
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+type Lang string
+
+const (
+	Japanese Lang = "ja"
+	English  Lang = "en"
+)
+
+var matcher = language.NewMatcher([]language.Tag{language.Japanese, language.English})
+
+// LangFromAcceptLanguage Accept-Languageヘッダーから表示言語を決める。判定できない場合は日本語
+func LangFromAcceptLanguage(acceptLanguage string) Lang {
+	if acceptLanguage == "" {
+		return Japanese
+	}
+	tag, _ := language.MatchStrings(matcher, acceptLanguage)
+	if base, _ := tag.Base(); base.String() == string(English) {
+		return English
+	}
+	return Japanese
+}
+
+// ImportError 取込エラー・警告。メッセージはコードとパラメータから表示時に組み立てる
+type ImportError struct {
+	Code   Code
+	Field  string
+	Params map[string]string
+}
+
+func New(code Code, field string, params map[string]string) *ImportError {
+	return &ImportError{
+		Code:   code,
+		Field:  field,
+		Params: params,
+	}
+}
+
+func (e *ImportError) Error() string {
+	return e.Message(Japanese)
+}
+
+func (e *ImportError) Severity() Severity {
+	return e.Code.Severity()
+}
+
+func (e *ImportError) Message(lang Lang) string {
+	return Message(e.Code, e.Field, e.Params, lang)
+}
+
+// List 1行に対する複数の取込エラー
+type List []*ImportError
+
+func (l List) Error() string {
+	messages := make([]string, 0, len(l))
+	for _, e := range l {
+		messages = append(messages, e.Error())
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Message コードに対応するメッセージの{パラメータ名}を置き換えて返す。{field}は項目名になる
+func Message(code Code, field string, params map[string]string, lang Lang) string {
+	entry, ok := catalog[code]
+	if !ok {
+		entry = catalog[CodeUnknown]
+	}
+	message := entry.ja
+	if lang == English {
+		message = entry.en
+	}
+	replacements := []string{"{field}", field}
+	for k, v := range params {
+		replacements = append(replacements, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(replacements...).Replace(message)
+}
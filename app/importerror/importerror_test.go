@@ -0,0 +1,36 @@
+package importerror
+
+import "testing"
+
+func TestLangFromAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Lang
+	}{
+		{"", Japanese},
+		{"ja,en-US;q=0.9", Japanese},
+		{"en-US,en;q=0.9,ja;q=0.8", English},
+		{"fr-FR", Japanese},
+	}
+	for _, tt := range tests {
+		if got := LangFromAcceptLanguage(tt.header); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	e := New(CodeOutOfRange, "StayDays", map[string]string{"label": "泊数", "min": "1", "max": "30"})
+	if got, want := e.Message(Japanese), "泊数(StayDays): 1～30の範囲外です"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := e.Message(English), "StayDays is out of range (1 - 30)."; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if e.Severity() != SeverityError {
+		t.Errorf("got %s, want %s", e.Severity(), SeverityError)
+	}
+	if got := Code("NO_SUCH_CODE").Severity(); got != SeverityError {
+		t.Errorf("got %s, want %s", got, SeverityError)
+	}
+}
@@ -5,23 +5,31 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	_ "time/tzdata"
+	"ui-backend-for-omotebako-site-controller/app/alert"
 	"ui-backend-for-omotebako-site-controller/app/cmd/fileController"
+	"ui-backend-for-omotebako-site-controller/app/cmd/importController"
 	"ui-backend-for-omotebako-site-controller/app/database"
-	"ui-backend-for-omotebako-site-controller/app/file"
 	"ui-backend-for-omotebako-site-controller/app/server/router"
 	"ui-backend-for-omotebako-site-controller/config"
 	"ui-backend-for-omotebako-site-controller/pkg"
 
 	"go.uber.org/zap"
+	"golang.org/x/xerrors"
 )
 
-func Server(port string, db *database.Database, logger *zap.SugaredLogger) {
+// Server HTTPサーバを起動して返す
+func Server(port string, db *database.Database, watchers fileController.Watchers, queue *importController.Queue, alerts *alert.Alerts, logger *zap.SugaredLogger) *router.Server {
 	// Server構造体作成
-	s := router.NewServer(port, db, logger)
+	s := router.NewServer(port, db, watchers, queue, alerts, logger)
 	// Route実行
 	s.Route()
 	// Server実行
-	s.Run()
+	go s.Run()
+	return s
 }
 
 func main() {
@@ -33,12 +41,20 @@ func main() {
 		}
 	}(sugar)
 
-	ctx := context.Background()
-	// // Watch内で、新しいファイルが生成されるまで待機させる。
-	listAuto := make(chan file.Files)
+	// SIGINT、SIGTERM（Kubernetesの停止）でキャンセルされる。キャンセルで監視と新しい取込を止める
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 実行中の取込はSHUTDOWN_TIMEOUTが経過するまでキャンセルしない
+	importCtx, cancelImports := context.WithCancel(context.Background())
+	defer cancelImports()
 
 	// DB構造体作成
 	env, err := config.NewEnv()
+	var sourcesErr *config.WatchSourcesError
+	if xerrors.As(err, &sourcesErr) {
+		sugar.Errorf("failed to load watch sources: %+v", err)
+		return
+	}
 	if err != nil {
 		sugar.Warnf("NewEnv error: %+v", err)
 	}
@@ -47,42 +63,55 @@ func main() {
 		sugar.Errorf("failed to create database: %+v", err)
 		return
 	}
-	// mainを終了させるためのチャネル
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	// mainが終了した時にgoルーチンも終了するためのチャネル
-	done := make(chan bool, 1)
-
-	siteControllerName := config.GetEnv("SITE_CONTROLLER_NAME", "Lincoln")
+	db.ImportEnv = env.ImportEnv
 
-	// 自動でcsvファイルからデータをMySQLに入れるgoルーチン
-	go fileController.Watch(ctx, listAuto, done, db, env.WatchEnv)
+	// 共有フォルダの切断、ファイルが届かない、取込ジョブの隔離等のアラート。ALERT_WEBHOOK_URLがあれば通知する
+	var notifiers []alert.Notifier
+	if env.AlertWebhookURL != "" {
+		notifiers = append(notifiers, alert.NewWebhook(env.AlertWebhookURL))
+	}
+	alerts := alert.NewAlerts(notifiers...)
 
-	// HTTPサーバを立てる
-	go Server(env.Port, db, sugar)
+	// 取込ジョブを処理するワーカー。監視で検知したファイルと画面から登録したファイルを取り込む
+	queue := importController.NewQueue(db, env, alerts)
+	queueFinished := make(chan struct{})
+	go func() {
+		queue.Run(ctx, importCtx)
+		close(queueFinished)
+	}()
 
-	for {
-		select {
-		// 自動登録
-		case newFileList := <-listAuto:
-			for _, file := range newFileList {
-				sugar.Infof("target fileName: %v\n", file.Name)
+	// 監視元ごとに、新しいファイルを取込ジョブに追加するgoルーチン
+	watchers := fileController.NewWatchers(db, env.WatchEnv, alerts)
+	var watching sync.WaitGroup
+	for _, watcher := range watchers {
+		watching.Add(1)
+		go func(watcher *fileController.Watcher) {
+			defer watching.Done()
+			watcher.Watch(ctx, queue.Wake())
+		}(watcher)
+	}
 
-				// csv登録...status＝before
-				model, err := db.CreateCsvUploadTransaction(ctx, file.Name, file.CreatedTime, "", "")
-				if err != nil {
-					sugar.Errorf("failed to insert record to database: %v", err)
-				}
+	// HTTPサーバを立てる
+	server := Server(env.Port, db, watchers, queue, alerts, sugar)
 
-				if err := db.RegisterCSVDataToDB(ctx, *file, env.MountPath, model.ID, siteControllerName); err != nil {
-					sugar.Error(err)
-				}
-			}
-		case <-quit:
-			goto END
-		}
+	<-ctx.Done()
+	stop()
+	sugar.Info("shutting down")
+	// 画面からの登録と監視はctxのキャンセルで止まる
+	watching.Wait()
+	// 実行中の取込が終わるまで待つ。終わらない場合は行の区切りで中断し、次の起動時に再開する
+	select {
+	case <-queueFinished:
+	case <-time.After(env.ShutdownTimeout):
+		sugar.Warnf("imports did not finish in %v, interrupt them", env.ShutdownTimeout)
+		cancelImports()
+		<-queueFinished
+	}
+	// 処理中のリクエストを待ってHTTPサーバを止める
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		sugar.Errorf("failed to shutdown server: %v", err)
 	}
-END:
-	done <- true
 	sugar.Info("finish main function")
 }
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+type roomInventoryRequest struct {
+	RoomType      string `json:"room_type" binding:"required"`
+	StayDate      string `json:"stay_date" binding:"required"`
+	NumberOfRooms int    `json:"number_of_rooms"`
+}
+
+// parseDateRange クエリパラメータfrom, to（YYYYMMDD）を施設のタイムゾーンで取得する。未指定の場合は本日から30日間
+func parseDateRange(c *gin.Context, location *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	from, to := today, today.AddDate(0, 0, 30)
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.ParseInLocation("20060102", v, location); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.ParseInLocation("20060102", v, location); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return from, to, nil
+}
+
+func (h *SCHandler) GetRoomInventories(c *gin.Context) {
+	from, to, err := parseDateRange(c, h.db.Location)
+	if err != nil {
+		h.log.Errorf("invalid date range: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	rows, err := h.db.GetRoomInventories(c.Request.Context(), from, to)
+	if err != nil {
+		h.log.Errorf("failed to get room inventories: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	inventories := []database.RoomInventory{}
+	for _, row := range rows {
+		inventories = append(inventories, database.RoomInventory{
+			RoomType:      row.RoomType,
+			StayDate:      row.StayDate.In(h.db.Location),
+			NumberOfRooms: row.NumberOfRooms,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"inventories": inventories})
+}
+
+func (h *SCHandler) UpdateRoomInventories(c *gin.Context) {
+	var req []roomInventoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.Errorf("failed to bind request body: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	var inventories []database.RoomInventory
+	for _, r := range req {
+		stayDate, err := time.ParseInLocation("20060102", r.StayDate, h.db.Location)
+		if err != nil || r.NumberOfRooms < 0 {
+			h.log.Errorf("invalid room inventory: %+v", r)
+			c.String(http.StatusBadRequest, "BAD REQUEST")
+			return
+		}
+		inventories = append(inventories, database.RoomInventory{
+			RoomType:      r.RoomType,
+			StayDate:      stayDate,
+			NumberOfRooms: r.NumberOfRooms,
+		})
+	}
+	if err := h.db.UpsertRoomInventories(c.Request.Context(), inventories); err != nil {
+		h.log.Errorf("failed to update room inventories: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
+
+type overbooking struct {
+	ReservationID  int       `json:"reservation_id"`
+	RoomType       string    `json:"room_type"`
+	StayDate       time.Time `json:"stay_date"`
+	RequestedRooms int       `json:"requested_rooms"`
+	RemainingRooms int       `json:"remaining_rooms"`
+}
+
+func (h *SCHandler) GetOverbookings(c *gin.Context) {
+	from, to, err := parseDateRange(c, h.db.Location)
+	if err != nil {
+		h.log.Errorf("invalid date range: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	rows, err := h.db.GetOverbookings(c.Request.Context(), from, to)
+	if err != nil {
+		h.log.Errorf("failed to get overbookings: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	overbookings := []overbooking{}
+	for _, row := range rows {
+		overbookings = append(overbookings, overbooking{
+			ReservationID:  row.ReservationID,
+			RoomType:       row.RoomType,
+			StayDate:       row.StayDate.In(h.db.Location),
+			RequestedRooms: row.RequestedRooms,
+			RemainingRooms: row.RemainingRooms,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"overbookings": overbookings})
+}
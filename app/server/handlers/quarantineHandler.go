@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/database"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/xerrors"
+)
+
+type quarantinedJob struct {
+	ID            int       `json:"id"`
+	Source        string    `json:"source"`
+	FilePath      string    `json:"file_path"`
+	CsvID         *int      `json:"csv_id"`
+	Failures      int       `json:"failures"`
+	LastError     string    `json:"last_error"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+func (h *SCHandler) GetQuarantinedJobs(c *gin.Context) {
+	jobs, err := h.queue.Quarantined(c.Request.Context())
+	if err != nil {
+		h.log.Errorf("failed to get quarantined import jobs: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	quarantined := []quarantinedJob{}
+	for _, job := range jobs {
+		quarantined = append(quarantined, quarantinedJob{
+			ID:            job.ID,
+			Source:        job.Source,
+			FilePath:      job.FilePath,
+			CsvID:         job.CSVID.Ptr(),
+			Failures:      job.Failures,
+			LastError:     job.LastError.String,
+			QuarantinedAt: job.QuarantinedAt.Time.In(h.db.Location),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": quarantined})
+}
+
+// ReleaseQuarantinedJob 隔離したジョブをすぐに再試行する。restart=trueの場合は最初の行から取り込む
+func (h *SCHandler) ReleaseQuarantinedJob(c *gin.Context) {
+	restart, err := strconv.ParseBool(c.DefaultQuery("restart", "false"))
+	if err != nil {
+		h.log.Errorf("invalid restart: %s", c.Query("restart"))
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	h.updateQuarantinedJob(c, func(ctx context.Context, id int) error {
+		return h.queue.Release(ctx, id, restart)
+	})
+}
+
+// DiscardQuarantinedJob 隔離したジョブを破棄する。監視で検知したファイルはfailed/に移動する
+func (h *SCHandler) DiscardQuarantinedJob(c *gin.Context) {
+	h.updateQuarantinedJob(c, h.queue.Discard)
+}
+
+// updateQuarantinedJob パスパラメータidの隔離したジョブをupdateで更新する。隔離したジョブではない場合は404を返す
+func (h *SCHandler) updateQuarantinedJob(c *gin.Context, update func(ctx context.Context, id int) error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		h.log.Errorf("invalid import job id: %s", c.Param("id"))
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	if err := update(c.Request.Context(), id); err != nil {
+		if xerrors.Is(err, database.ErrImportJobNotQuarantined) {
+			h.log.Errorf("import job %d is not quarantined", id)
+			c.String(http.StatusNotFound, "NOT FOUND")
+			return
+		}
+		h.log.Errorf("failed to update quarantined import job %d: %v", id, err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -7,35 +7,51 @@ import (
 	"net/http/httputil"
 	"os"
 	"strings"
-	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/cmd/fileController"
+	"ui-backend-for-omotebako-site-controller/app/cmd/importController"
 	"ui-backend-for-omotebako-site-controller/app/database"
 	"ui-backend-for-omotebako-site-controller/app/file"
+	"ui-backend-for-omotebako-site-controller/app/helper"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
 	"ui-backend-for-omotebako-site-controller/app/models"
+	"ui-backend-for-omotebako-site-controller/config"
 
 	"github.com/gin-gonic/gin"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 	"go.uber.org/zap"
+	"golang.org/x/xerrors"
 )
 
 const (
-	CONSARVATION_PATH string = "/var/lib/aion/Data"
+	CONSARVATION_PATH string = config.ConservationPath
 )
 
 type errors struct {
 	LineNumber          int    `json:"line_number"`
 	CustomerName        string `json:"customer_name"`
 	CustomerPhoneNumber string `json:"customer_phone_number"`
+	Code                string `json:"code"`
+	Field               string `json:"field"`
+	Severity            string `json:"severity"`
+	Message             string `json:"message"`
 }
 
 type SCHandler struct {
-	db  *database.Database
-	log *zap.SugaredLogger
+	db       *database.Database
+	watchers fileController.Watchers
+	queue    *importController.Queue
+	alerts   *alert.Alerts
+	log      *zap.SugaredLogger
 }
 
-func NewSCHandler(db *database.Database, logger *zap.SugaredLogger) *SCHandler {
+func NewSCHandler(db *database.Database, watchers fileController.Watchers, queue *importController.Queue, alerts *alert.Alerts, logger *zap.SugaredLogger) *SCHandler {
 	return &SCHandler{
-		db:  db,
-		log: logger,
+		db:       db,
+		watchers: watchers,
+		queue:    queue,
+		alerts:   alerts,
+		log:      logger,
 	}
 }
 
@@ -64,29 +80,97 @@ func (h *SCHandler) CSVError(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": []errors{}})
 		return
 	}
-	if len(rows) == 0 {
+	// 取込に成功したファイルの警告も返す
+	warningRows, err := h.db.GetCsvExecutionWarningsWithCsvUploadTransactionByStatus(c.Request.Context(), 0)
+	if err != nil {
+		sugar.Errorf("cannot get csv warning rows: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": []errors{}})
+		return
+	}
+	if len(rows) == 0 && len(warningRows) == 0 {
 		c.JSON(http.StatusOK, gin.H{
-			"errors": []errors{},
+			"errors":   []errors{},
+			"warnings": []errors{},
 		})
 		return
 	}
 
-	row := rows[0]
+	// 最初のCSVファイル（エラーがない場合は警告のある最初のファイル）の未解決エラー・警告を返す
+	var csvID int
+	var fileName string
+	if len(rows) > 0 {
+		csvID, fileName = rows[0].CSVID, rows[0].R.CSV.FileName.String
+	} else {
+		csvID, fileName = warningRows[0].CSVID, warningRows[0].R.CSV.FileName.String
+	}
+	lang := importerror.LangFromAcceptLanguage(c.GetHeader("Accept-Language"))
+	errs := []errors{}
+	for _, r := range rows {
+		if r.CSVID == csvID {
+			errs = append(errs, newErrors(r, lang))
+		}
+	}
+	warnings := []errors{}
+	for _, r := range warningRows {
+		if r.CSVID == csvID {
+			warnings = append(warnings, newWarnings(r, lang))
+		}
+	}
 	res := map[string]interface{}{
-		"file_name": row.R.CSV.FileName.String,
-		"errors": []errors{
-			{
-				LineNumber:          row.LineNumber,
-				CustomerName:        row.CustomerName.String,
-				CustomerPhoneNumber: row.CustomerPhoneNumber.String,
-			},
-		},
+		"file_name": fileName,
+		"errors":    errs,
+		"warnings":  warnings,
 	}
 	c.JSON(http.StatusOK, res)
 	return
 }
 
+// newErrors コードのない（コード導入前の）エラーは登録時のメッセージをそのまま返す
+func newErrors(row *models.CSVExecutionError, lang importerror.Lang) errors {
+	e := errors{
+		LineNumber:          row.LineNumber,
+		CustomerName:        row.CustomerName.String,
+		CustomerPhoneNumber: row.CustomerPhoneNumber.String,
+		Code:                row.ErrorCode.String,
+		Field:               row.FieldName.String,
+		Severity:            row.Severity.String,
+		Message:             row.ErrorMessage,
+	}
+	if row.ErrorCode.String == "" {
+		e.Code = string(importerror.CodeUnknown)
+		e.Severity = string(importerror.SeverityError)
+		return e
+	}
+	e.Message = importerror.Message(importerror.Code(row.ErrorCode.String), row.FieldName.String, database.UnmarshalParams(row.Params), lang)
+	return e
+}
+
+// newWarnings コードのない（コード導入前の）警告は登録時のメッセージをそのまま返す
+func newWarnings(row *models.CSVExecutionWarning, lang importerror.Lang) errors {
+	e := errors{
+		LineNumber:          row.LineNumber,
+		CustomerName:        row.CustomerName.String,
+		CustomerPhoneNumber: row.CustomerPhoneNumber.String,
+		Code:                row.ErrorCode.String,
+		Field:               row.FieldName.String,
+		Severity:            string(importerror.SeverityWarning),
+		Message:             row.WarningMessage,
+	}
+	if row.ErrorCode.String == "" {
+		return e
+	}
+	e.Message = importerror.Message(importerror.Code(row.ErrorCode.String), row.FieldName.String, database.UnmarshalParams(row.Params), lang)
+	return e
+}
+
 func (h *SCHandler) UpdateErrorStatus(c *gin.Context) {
+	// 警告も確認済み（1）にする
+	if err := h.db.UpdateCsvExecutionWarningsStatus(c.Request.Context(), 0, 1); err != nil {
+		sugar.Errorf("failed to update csv_execution_warnings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"timestamp": nil})
+		return
+	}
+
 	// ステータスが未解決（0)のレコードを取得する
 	rows, err := h.db.GetCsvExecutionErrorsByStatus(c.Request.Context(), 0)
 	if err != nil {
@@ -119,6 +203,12 @@ func (h *SCHandler) CreateCSV(c *gin.Context) {
 		return
 	}
 	ctx := c.Request.Context()
+	// 停止中は登録を受け付けない
+	if !h.queue.Accepting() {
+		h.log.Warnf("import queue is closed")
+		c.String(http.StatusServiceUnavailable, "SERVICE UNAVAILABLE")
+		return
+	}
 
 	// リクエストの情報を出力
 	body, err := httputil.DumpRequest(c.Request, true)
@@ -172,15 +262,21 @@ func (h *SCHandler) CreateCSV(c *gin.Context) {
 		Name:        fileInfo.Name(),
 		CreatedTime: fileInfo.ModTime(),
 	}
-	model, err := h.db.CreateCsvUploadTransaction(ctx, file.Name, time.Time{}, timestamp, filePath)
+	// 取込結果の一覧で絞り込み・並べ替えできるよう、ファイルの作成日時には登録日時（保存した日時）を記録する
+	model, err := h.db.CreateCsvUploadTransaction(ctx, file.Name, file.CreatedTime, timestamp, filePath, config.ManualSourceName, siteControllerName)
 	if err != nil {
 		sugar.Errorf("failed to insert record to database: %+v", err)
 		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
 		return
 	}
 
-	if err := h.db.RegisterCSVDataToDB(ctx, file, CONSARVATION_PATH, model.ID, siteControllerName); err != nil {
+	// 取込はワーカーで行う。結果はエラー取得APIで確認する
+	if err := h.queue.EnqueueUpload(ctx, CONSARVATION_PATH, &file, model.ID, siteControllerName); err != nil {
 		sugar.Error(err)
+		if xerrors.Is(err, importController.ErrClosed) {
+			c.String(http.StatusServiceUnavailable, "SERVICE UNAVAILABLE")
+			return
+		}
 		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
 		return
 	}
@@ -209,7 +305,13 @@ func (h *SCHandler) GetLatestTimestamp(c *gin.Context) {
 
 	timestampStr := row.Timestamp.String
 	h.log.Info(timestampStr)
-	timestampVal := fmt.Sprintf(`%s/%s/%s %s:%s:%s`, timestampStr[0:4], timestampStr[4:6], timestampStr[6:8], timestampStr[8:10], timestampStr[10:12], timestampStr[12:14])
+	// タイムスタンプ（YYYYMMDDhhmmss）は施設のタイムゾーンの日時として、オフセット付きISO 8601で返す
+	timestampVal, err := helper.FormatTimestamp(timestampStr, h.db.Location)
+	if err != nil {
+		sugar.Errorf("failed to parse timestamp %s: %v", timestampStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"timestamp": nil})
+		return
+	}
 	sugar.Infof("latest timestamp: %s", timestampVal)
 	c.JSON(http.StatusOK, gin.H{"timestamp": timestampVal})
 	return
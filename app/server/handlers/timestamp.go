@@ -1,9 +1,9 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/helper"
 	"ui-backend-for-omotebako-site-controller/app/models"
 
 	"context"
@@ -33,7 +33,13 @@ func GetLatestTimestamp(c *gin.Context, db *database.Database) {
 	}
 
 	timestampStr := row.Timestamp.String
-	timestampVal := fmt.Sprintf(`%s/%s/%s %s:%s:%s`, timestampStr[0:4], timestampStr[4:6], timestampStr[6:8], timestampStr[8:10], timestampStr[10:12], timestampStr[12:14])
+	// タイムスタンプ（YYYYMMDDhhmmss）は施設のタイムゾーンの日時として、オフセット付きISO 8601で返す
+	timestampVal, err := helper.FormatTimestamp(timestampStr, db.Location)
+	if err != nil {
+		sugar.Errorf("failed to parse timestamp %s: %v", timestampStr, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"timestamp": nil})
+		return
+	}
 	sugar.Infof("latest timestamp: %s", timestampVal)
 	c.JSON(http.StatusOK, gin.H{"timestamp": timestampVal})
 	return
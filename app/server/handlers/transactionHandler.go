@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/helper"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/volatiletech/null/v8"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+type csvTransaction struct {
+	ID             int    `json:"id"`
+	FileName       string `json:"file_name"`
+	Status         string `json:"status"`
+	Source         string `json:"source"`
+	SiteController string `json:"site_controller"`
+	// CreatedTime ファイルの作成日時（画面から登録したファイルは登録日時）
+	CreatedTime time.Time `json:"created_time"`
+	// Timestamp 画面から登録した場合の登録日時（YYYYMMDDhhmmss）
+	Timestamp string `json:"timestamp"`
+	// TotalLines ファイルの行数。SucceededLinesはエラーなく取り込んだ行数（不明な場合はnull）
+	TotalLines     null.Int `json:"total_lines"`
+	SucceededLines null.Int `json:"succeeded_lines"`
+	FailedLines    int      `json:"failed_lines"`
+	WarnedLines    int      `json:"warned_lines"`
+}
+
+type csvTransactionLine struct {
+	LineNumber int    `json:"line_number"`
+	Notice     string `json:"notice"`
+	// Result succeeded、failed。行ごとの結果を記録する前の取込結果は、エラーのない行が空になる
+	Result string `json:"result"`
+	// ReservationID, GuestID 登録・取消した予約と顧客（検証のみ、失敗した行はnull）
+	ReservationID null.Int `json:"reservation_id"`
+	GuestID       null.Int `json:"guest_id"`
+	CustomerName  string   `json:"customer_name"`
+	Errors        []errors `json:"errors"`
+	Warnings      []errors `json:"warnings"`
+}
+
+func newCSVTransaction(row database.CsvUploadTransactionSummary, location *time.Location) csvTransaction {
+	createdTime := row.CreatedTimeInWindows.Time
+	if createdTime.IsZero() {
+		// 登録日時を記録する前に画面から登録したファイルは、タイムスタンプを登録日時とする
+		if t, err := helper.ParseTimestamp(row.Timestamp.String, location); err == nil {
+			createdTime = t
+		}
+	}
+	return csvTransaction{
+		ID:             row.ID,
+		FileName:       row.FileName.String,
+		Status:         row.Status.String,
+		Source:         row.Source.String,
+		SiteController: row.SiteController.String,
+		CreatedTime:    createdTime.In(location),
+		Timestamp:      row.Timestamp.String,
+		TotalLines:     row.TotalLines,
+		SucceededLines: row.SucceededLines(),
+		FailedLines:    row.FailedLines,
+		WarnedLines:    row.WarnedLines,
+	}
+}
+
+// parseTransactionFilter クエリパラメータから取込結果の絞り込み条件を作る。
+// statusはカンマ区切りか繰り返しで複数指定でき、from、to（YYYYMMDD）はファイルの作成日（画面から登録したファイルは登録日）で、toの日も含む
+func parseTransactionFilter(c *gin.Context, location *time.Location) (database.CsvUploadTransactionFilter, error) {
+	filter := database.CsvUploadTransactionFilter{
+		Source:         c.Query("source"),
+		SiteController: c.Query("site_controller"),
+		FileName:       c.Query("file_name"),
+	}
+	for _, v := range c.QueryArray("status") {
+		for _, status := range strings.Split(v, ",") {
+			if status != "" {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation("20060102", v, location)
+		if err != nil {
+			return filter, err
+		}
+		filter.From = from
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation("20060102", v, location)
+		if err != nil {
+			return filter, err
+		}
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// parsePage クエリパラメータpage（1から）、per_page（最大maxPerPage）を返す
+func parsePage(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page <= 0 {
+		return 0, 0, xerrors.Errorf("page should be positive int: %s", c.Query("page"))
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+	if err != nil || perPage <= 0 || perPage > maxPerPage {
+		return 0, 0, xerrors.Errorf("per_page should be 1 to %d: %s", maxPerPage, c.Query("per_page"))
+	}
+	return page, perPage, nil
+}
+
+// GetCSVTransactions 取込結果の一覧を、ファイルの作成日時（画面から登録したファイルは登録日時）の新しい順に返す
+func (h *SCHandler) GetCSVTransactions(c *gin.Context) {
+	filter, err := parseTransactionFilter(c, h.db.Location)
+	if err != nil {
+		h.log.Errorf("invalid transaction filter: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	page, perPage, err := parsePage(c)
+	if err != nil {
+		h.log.Errorf("invalid page: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	rows, total, err := h.db.GetCsvUploadTransactions(c.Request.Context(), filter, perPage, (page-1)*perPage)
+	if err != nil {
+		h.log.Errorf("failed to get csv upload transactions: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	transactions := []csvTransaction{}
+	for _, row := range rows {
+		transactions = append(transactions, newCSVTransaction(row, h.db.Location))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"total":        total,
+		"page":         page,
+		"per_page":     perPage,
+	})
+}
+
+// GetCSVTransaction 取込結果のファイルの情報と、行ごとの結果（通知種別、登録・取消した予約と顧客、エラー、警告）を行番号順に返す
+func (h *SCHandler) GetCSVTransaction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		h.log.Errorf("invalid csv upload transaction id: %s", c.Param("id"))
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	ctx := c.Request.Context()
+	row, err := h.db.GetCsvUploadTransaction(ctx, id)
+	if err != nil {
+		if xerrors.Is(err, database.ErrCsvUploadTransactionNotFound) {
+			h.log.Errorf("csv upload transaction not found: %d", id)
+			c.String(http.StatusNotFound, "NOT FOUND")
+			return
+		}
+		h.log.Errorf("failed to get csv upload transaction: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+	lineRows, errorRows, warningRows, err := h.db.GetCsvExecutionLines(ctx, id)
+	if err != nil {
+		h.log.Errorf("failed to get csv execution lines: %v", err)
+		c.String(http.StatusInternalServerError, "INTERNAL SERVER ERROR")
+		return
+	}
+
+	lang := importerror.LangFromAcceptLanguage(c.GetHeader("Accept-Language"))
+	result := mergeCSVTransactionLines(lineRows, errorRows, warningRows, lang)
+
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": newCSVTransaction(*row, h.db.Location),
+		"lines":       result,
+	})
+}
+
+// mergeCSVTransactionLines 行ごとの結果、エラー、警告を行番号ごとにまとめ、行番号順に返す。
+// エラーのある行は失敗にし、結果を記録する前の取込結果の行は顧客名をエラー・警告から補う
+func mergeCSVTransactionLines(lineRows models.CSVExecutionLineSlice, errorRows models.CSVExecutionErrorSlice, warningRows models.CSVExecutionWarningSlice, lang importerror.Lang) []csvTransactionLine {
+	lines := map[int]*csvTransactionLine{}
+	line := func(number int) *csvTransactionLine {
+		if lines[number] == nil {
+			lines[number] = &csvTransactionLine{LineNumber: number, Errors: []errors{}, Warnings: []errors{}}
+		}
+		return lines[number]
+	}
+	for _, r := range lineRows {
+		l := line(r.LineNumber)
+		l.Notice = r.Notice.String
+		l.Result = r.Result
+		l.ReservationID = r.ReservationID
+		l.GuestID = r.GuestID
+		l.CustomerName = r.CustomerName.String
+	}
+	for _, r := range errorRows {
+		l := line(r.LineNumber)
+		l.Errors = append(l.Errors, newErrors(r, lang))
+		l.Result = database.LineFailed
+		if l.CustomerName == "" {
+			l.CustomerName = r.CustomerName.String
+		}
+	}
+	for _, r := range warningRows {
+		l := line(r.LineNumber)
+		l.Warnings = append(l.Warnings, newWarnings(r, lang))
+		if l.CustomerName == "" {
+			l.CustomerName = r.CustomerName.String
+		}
+	}
+	numbers := make([]int, 0, len(lines))
+	for number := range lines {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	result := make([]csvTransactionLine, 0, len(numbers))
+	for _, number := range numbers {
+		result = append(result, *lines[number])
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/database"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
+	"ui-backend-for-omotebako-site-controller/app/models"
+
+	"github.com/volatiletech/null/v8"
+)
+
+func TestNewCSVTransaction(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2021, 7, 1, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		row  *models.CSVUploadTransaction
+		want time.Time
+	}{
+		{name: "ファイルの作成日時", row: &models.CSVUploadTransaction{CreatedTimeInWindows: null.TimeFrom(created)}, want: created},
+		// 登録日時を記録する前に画面から登録したファイル
+		{name: "タイムスタンプ", row: &models.CSVUploadTransaction{CreatedTimeInWindows: null.TimeFrom(time.Time{}), Timestamp: null.StringFrom("20210701150000")}, want: created},
+		{name: "日時なし", row: &models.CSVUploadTransaction{}, want: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newCSVTransaction(database.CsvUploadTransactionSummary{CSVUploadTransaction: tt.row}, tokyo)
+			if !got.CreatedTime.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got.CreatedTime, tt.want)
+			}
+			if !tt.want.IsZero() && got.CreatedTime.Location() != tokyo {
+				t.Errorf("got location %v, want Asia/Tokyo", got.CreatedTime.Location())
+			}
+		})
+	}
+}
+
+func TestMergeCSVTransactionLines(t *testing.T) {
+	lineRows := models.CSVExecutionLineSlice{
+		{LineNumber: 1, Notice: null.StringFrom("予約"), Result: database.LineSucceeded, ReservationID: null.IntFrom(10), GuestID: null.IntFrom(20), CustomerName: null.StringFrom("山田")},
+		{LineNumber: 3, Notice: null.StringFrom("予約"), Result: database.LineFailed, CustomerName: null.StringFrom("佐藤")},
+	}
+	errorRows := models.CSVExecutionErrorSlice{
+		{LineNumber: 3, CustomerName: null.StringFrom("佐藤"), ErrorMessage: "error 1"},
+		{LineNumber: 3, CustomerName: null.StringFrom("佐藤"), ErrorMessage: "error 2"},
+		// 行ごとの結果を記録する前の取込結果
+		{LineNumber: 2, CustomerName: null.StringFrom("鈴木"), ErrorMessage: "error 3"},
+	}
+	warningRows := models.CSVExecutionWarningSlice{
+		{LineNumber: 1, CustomerName: null.StringFrom("山田"), WarningMessage: "warning 1"},
+		{LineNumber: 4, CustomerName: null.StringFrom("田中"), WarningMessage: "warning 2"},
+	}
+
+	lines := mergeCSVTransactionLines(lineRows, errorRows, warningRows, importerror.Japanese)
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+	for i, l := range lines {
+		if l.LineNumber != i+1 {
+			t.Errorf("%d: got line number %d, want %d", i, l.LineNumber, i+1)
+		}
+	}
+	if l := lines[0]; l.Result != database.LineSucceeded || l.ReservationID.Int != 10 || l.GuestID.Int != 20 || len(l.Errors) != 0 || len(l.Warnings) != 1 {
+		t.Errorf("line 1: got %+v", l)
+	}
+	if l := lines[1]; l.Result != database.LineFailed || l.CustomerName != "鈴木" || len(l.Errors) != 1 || l.Notice != "" {
+		t.Errorf("line 2: got %+v", l)
+	}
+	if l := lines[2]; l.Result != database.LineFailed || l.CustomerName != "佐藤" || len(l.Errors) != 2 || l.Errors[1].Message != "error 2" {
+		t.Errorf("line 3: got %+v", l)
+	}
+	// 行ごとの結果を記録する前のエラーのない行は、結果が空になる
+	if l := lines[3]; l.Result != "" || l.CustomerName != "田中" || len(l.Errors) != 0 || l.Warnings[0].Message != "warning 2" {
+		t.Errorf("line 4: got %+v", l)
+	}
+
+	// 結果がない場合も空の配列を返す
+	if lines := mergeCSVTransactionLines(nil, nil, nil, importerror.Japanese); lines == nil || len(lines) != 0 {
+		t.Errorf("got %v, want empty lines", lines)
+	}
+}
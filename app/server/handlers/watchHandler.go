@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"ui-backend-for-omotebako-site-controller/app/cmd/fileController"
+
+	"github.com/gin-gonic/gin"
+)
+
+type pollingIntervalRequest struct {
+	// PollingInterval スキャン間隔（分）
+	PollingInterval int `json:"polling_interval" binding:"required"`
+}
+
+func (h *SCHandler) GetWatchStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sources": h.watchers.Statuses()})
+}
+
+// findWatcher パスパラメータnameの監視元を返す。ない場合は404を返してnilを返す
+func (h *SCHandler) findWatcher(c *gin.Context) *fileController.Watcher {
+	w := h.watchers.Find(c.Param("name"))
+	if w == nil {
+		h.log.Errorf("watch source not found: %s", c.Param("name"))
+		c.String(http.StatusNotFound, "NOT FOUND")
+	}
+	return w
+}
+
+func (h *SCHandler) GetWatchSourceStatus(c *gin.Context) {
+	w := h.findWatcher(c)
+	if w == nil {
+		return
+	}
+	c.JSON(http.StatusOK, w.Status())
+}
+
+// ScanWatchSource すぐにスキャンする。スキャンは非同期に行うため、結果は状態のlast_scanで確認する
+func (h *SCHandler) ScanWatchSource(c *gin.Context) {
+	w := h.findWatcher(c)
+	if w == nil {
+		return
+	}
+	w.ScanNow()
+	c.JSON(http.StatusAccepted, w.Status())
+}
+
+func (h *SCHandler) PauseWatchSource(c *gin.Context) {
+	w := h.findWatcher(c)
+	if w == nil {
+		return
+	}
+	w.Pause()
+	c.JSON(http.StatusOK, w.Status())
+}
+
+func (h *SCHandler) ResumeWatchSource(c *gin.Context) {
+	w := h.findWatcher(c)
+	if w == nil {
+		return
+	}
+	w.Resume()
+	c.JSON(http.StatusOK, w.Status())
+}
+
+func (h *SCHandler) UpdatePollingInterval(c *gin.Context) {
+	w := h.findWatcher(c)
+	if w == nil {
+		return
+	}
+	var req pollingIntervalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.Errorf("invalid polling interval request: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	if err := w.SetPollingInterval(req.PollingInterval); err != nil {
+		h.log.Errorf("invalid polling interval: %v", err)
+		c.String(http.StatusBadRequest, "BAD REQUEST")
+		return
+	}
+	c.JSON(http.StatusOK, w.Status())
+}
+
+func (h *SCHandler) GetWatchAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"alerts": h.alerts.Events()})
+}
+
+// GetHealth 監視ディレクトリにアクセスできない監視元がある場合は503を返す
+func (h *SCHandler) GetHealth(c *gin.Context) {
+	unhealthy := []fileController.Status{}
+	for _, status := range h.watchers.Statuses() {
+		if !status.Healthy() {
+			unhealthy = append(unhealthy, status)
+		}
+	}
+	if len(unhealthy) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "sources": unhealthy})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"log"
+	"ui-backend-for-omotebako-site-controller/app/importerror"
 	"ui-backend-for-omotebako-site-controller/app/server/response"
 	"ui-backend-for-omotebako-site-controller/pkg"
 
@@ -24,6 +25,7 @@ func (h *SCHandler) WsConnect(c *gin.Context, channel chan []int) {
 		return
 	}
 	tx, err := h.db.DB.Begin()
+	lang := importerror.LangFromAcceptLanguage(c.GetHeader("Accept-Language"))
 	//何か受け取ってそのまま返すパターン
 END:
 	for {
@@ -35,13 +37,18 @@ END:
 				break END
 			}
 			row := rows[0]
+			e := newErrors(row, lang)
 			responseStruct := response.CsvExectutionError{
 				FileName: row.R.CSV.FileName.String,
 				Errors: []response.Error{
 					{
-						LineNumber:          row.LineNumber,
-						CustomerName:        row.CustomerName.String,
-						CustomerPhoneNumber: row.CustomerPhoneNumber.String,
+						LineNumber:          e.LineNumber,
+						CustomerName:        e.CustomerName,
+						CustomerPhoneNumber: e.CustomerPhoneNumber,
+						Code:                e.Code,
+						Field:               e.Field,
+						Severity:            e.Severity,
+						Message:             e.Message,
 					},
 				},
 			}
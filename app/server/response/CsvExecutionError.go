@@ -4,6 +4,10 @@ type Error struct {
 	LineNumber          int    `json:"lineNumber"`
 	CustomerName        string `json:"customerName"`
 	CustomerPhoneNumber string `json:"customerPhoneNumber"`
+	Code                string `json:"code"`
+	Field               string `json:"field"`
+	Severity            string `json:"severity"`
+	Message             string `json:"message"`
 }
 
 type CsvExectutionError struct {
@@ -1,9 +1,14 @@
 package router
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
+	"ui-backend-for-omotebako-site-controller/app/alert"
+	"ui-backend-for-omotebako-site-controller/app/cmd/fileController"
+	"ui-backend-for-omotebako-site-controller/app/cmd/importController"
 	"ui-backend-for-omotebako-site-controller/app/database"
 	"ui-backend-for-omotebako-site-controller/app/server/handlers"
 
@@ -14,15 +19,19 @@ import (
 )
 
 type Server struct {
-	gin  *gin.Engine
-	ws   *websocket.Upgrader
-	port string
-	db   *database.Database
-	log  *zap.SugaredLogger
+	gin      *gin.Engine
+	http     *http.Server
+	ws       *websocket.Upgrader
+	port     string
+	db       *database.Database
+	watchers fileController.Watchers
+	queue    *importController.Queue
+	alerts   *alert.Alerts
+	log      *zap.SugaredLogger
 }
 
-func NewServer(port string, db *database.Database, logger *zap.SugaredLogger) *Server {
-	return &Server{
+func NewServer(port string, db *database.Database, watchers fileController.Watchers, queue *importController.Queue, alerts *alert.Alerts, logger *zap.SugaredLogger) *Server {
+	s := &Server{
 		gin: gin.New(),
 		ws: &websocket.Upgrader{
 			HandshakeTimeout:  5 * time.Second,
@@ -34,14 +43,22 @@ func NewServer(port string, db *database.Database, logger *zap.SugaredLogger) *S
 			CheckOrigin:       nil,
 			EnableCompression: false,
 		},
-		port: fmt.Sprintf(`:%v`, port),
-		db:   db,
-		log:  logger,
+		port:     fmt.Sprintf(`:%v`, port),
+		db:       db,
+		watchers: watchers,
+		queue:    queue,
+		alerts:   alerts,
+		log:      logger,
 	}
+	s.http = &http.Server{
+		Addr:    s.port,
+		Handler: s.gin,
+	}
+	return s
 }
 
 func (s *Server) Route() {
-	handler := handlers.NewSCHandler(s.db, s.log)
+	handler := handlers.NewSCHandler(s.db, s.watchers, s.queue, s.alerts, s.log)
 
 	s.gin.Use(cors.New(cors.Config{
 		AllowOrigins: []string{
@@ -67,6 +84,9 @@ func (s *Server) Route() {
 		MaxAge:           300,
 	}))
 	// restAPI一覧
+	// 全ての監視元の監視ディレクトリにアクセスできる場合は200、できない場合は503
+	s.gin.GET("/api/health", handler.GetHealth)
+
 	s.gin.GET("/api/auth/csv/:timestamp", handler.GetAuthCSV)
 
 	baseGroup := s.gin.Group("/api/csv")
@@ -83,6 +103,49 @@ func (s *Server) Route() {
 	// 前回の手動連携日時を返すエンドポイント
 	baseGroup.GET("/transaction/latest", handler.GetLatestTimestamp)
 
+	// 取込結果の一覧（status、source、site_controller、from、to、file_nameで絞り込み、page、per_pageで分割）
+	baseGroup.GET("/transactions", handler.GetCSVTransactions)
+
+	// 取込結果の詳細（ファイルの情報と行ごとの結果）
+	baseGroup.GET("/transactions/:id", handler.GetCSVTransaction)
+
+	inventoryGroup := s.gin.Group("/api/inventory")
+
+	// 部屋タイプ別・日別の在庫室数
+	inventoryGroup.GET("", handler.GetRoomInventories)
+	inventoryGroup.PUT("", handler.UpdateRoomInventories)
+
+	// 取込時に検出したオーバーブッキング
+	inventoryGroup.GET("/overbookings", handler.GetOverbookings)
+
+	watchGroup := s.gin.Group("/api/watch")
+
+	// 監視元ごとのフォルダ監視の状態（監視方法、最終スキャン日時、取込の基準日時等）
+	watchGroup.GET("/status", handler.GetWatchStatus)
+
+	// 共有フォルダの切断等のアラート（新しい順）
+	watchGroup.GET("/alerts", handler.GetWatchAlerts)
+	watchGroup.GET("/sources/:name", handler.GetWatchSourceStatus)
+
+	// すぐにスキャンする
+	watchGroup.POST("/sources/:name/scan", handler.ScanWatchSource)
+
+	// 自動取込の一時停止・再開
+	watchGroup.POST("/sources/:name/pause", handler.PauseWatchSource)
+	watchGroup.POST("/sources/:name/resume", handler.ResumeWatchSource)
+
+	// スキャン間隔（分）の変更。再起動すると設定値に戻る
+	watchGroup.PUT("/sources/:name/interval", handler.UpdatePollingInterval)
+
+	importGroup := s.gin.Group("/api/import")
+
+	// 取込に繰り返し失敗して隔離したジョブ
+	importGroup.GET("/quarantine", handler.GetQuarantinedJobs)
+
+	// 隔離したジョブの再試行・破棄
+	importGroup.POST("/quarantine/:id/release", handler.ReleaseQuarantinedJob)
+	importGroup.POST("/quarantine/:id/discard", handler.DiscardQuarantinedJob)
+
 	//g.GET("/:timestamp")
 	//g.POST("/:timestamp")
 
@@ -96,7 +159,12 @@ func (s *Server) Route() {
 
 func (s *Server) Run() {
 	// log.Println("run server")
-	if err := s.gin.Run(s.port); err != nil {
+	if err := s.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Printf("run server error :%v", err)
 	}
 }
+
+// Shutdown 新しい接続を受け付けず、処理中のリクエストが終わるまでctxの期限まで待つ
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"time"
 
 	"golang.org/x/xerrors"
 )
@@ -11,31 +13,100 @@ import (
 type Env struct {
 	*MysqlEnv
 	*WatchEnv
+	*ImportEnv
 	Port string
+	// AlertWebhookURL 監視の異常（アラート）をJSONでPOSTするURL。空の場合はログと画面でのみ確認できる
+	AlertWebhookURL string
+	// ShutdownTimeout 停止時に実行中の取込が終わるのを待つ時間。経過すると取込を中断し、次の起動時に再開する
+	ShutdownTimeout time.Duration
 }
 type MysqlEnv struct {
 	User     xxxx
 	Host     xxxx
 	Password xxxx
 	Port     xxxx
+	// 施設のタイムゾーン。nilの場合はDefaultLocationを使う
+	Location *time.Location
 }
 
 type WatchEnv struct {
 	PollingInterval int
 	MountPath       string
+	// WatchMode auto（inotifyが使えない場合はポーリング）、fsnotify、polling
+	WatchMode string
+	// QuietPeriod ファイルの最終更新からこの時間が経過するまで取り込まない
+	QuietPeriod time.Duration
+	// GapTimeout 通知番号の欠番を待つ時間。0の場合は待たない
+	GapTimeout time.Duration
+	// ArchiveMode, ArchivePath 取込後のファイルの移動方法と移動先（空の場合は監視するディレクトリ）
+	ArchiveMode string
+	ArchivePath string
+	// AccessTimeout 監視するディレクトリへのアクセスが終わらない場合に、共有フォルダの応答がないとみなす時間
+	AccessTimeout time.Duration
+	// Location 施設のタイムゾーン（expected_arrivalsの時間帯に使う）
+	Location *time.Location
+	// Sources 監視元。PollingInterval、WatchModeは監視元ごとの設定がない場合のデフォルト
+	Sources []WatchSource
 }
 
-// NewEnv 必ずEnv構造体は返る、POLLING_INTERVALに数字が入っていない場合にエラーが返る
+const (
+	WatchModeAuto     = "auto"
+	WatchModeFsnotify = "fsnotify"
+	WatchModePolling  = "polling"
+)
+
+type ImportEnv struct {
+	// 同一顧客の宿泊期間が重複する予約を取込エラーにする（falseの場合は警告として取り込む）
+	BlockDuplicateReservation bool
+	// サイトコントローラー名、通知種別ごとの検証ルール
+	ValidationRules ValidationRules
+	// ImportWorkers 取込ジョブを並行して処理するワーカーの数（同じ監視元のジョブは1つずつ処理する）
+	ImportWorkers int
+	// QuarantineAfter ファイル全体の取込にこの回数失敗したジョブを隔離する
+	QuarantineAfter int
+	// RetryInterval 取込に失敗したジョブを再試行するまでの間隔。失敗するごとに長くする
+	RetryInterval time.Duration
+}
+
+// NewEnv 必ずEnv構造体は返る、POLLING_INTERVAL等の値が不正な場合にエラーが返る
 func NewEnv() (*Env, error) {
 	watchEnv, err := NewWatchEnv()
+	importEnv, importErr := NewImportEnv()
+	if err == nil {
+		err = importErr
+	}
+	location, locationErr := NewLocation()
+	if err == nil {
+		err = locationErr
+	}
+	watchEnv.Location = location
+	shutdownTimeout, shutdownErr := time.ParseDuration(GetEnv("SHUTDOWN_TIMEOUT", "20s"))
+	if shutdownErr != nil {
+		shutdownTimeout = 20 * time.Second
+		if err == nil {
+			err = xerrors.Errorf("SHUTDOWN_TIMEOUT should be duration (e.g. 20s): %w", shutdownErr)
+		}
+	}
+	alertWebhookURL := GetEnv("ALERT_WEBHOOK_URL", "")
+	if alertWebhookURL != "" {
+		if _, urlErr := url.ParseRequestURI(alertWebhookURL); urlErr != nil {
+			alertWebhookURL = ""
+			if err == nil {
+				err = xerrors.Errorf("ALERT_WEBHOOK_URL should be URL: %w", urlErr)
+			}
+		}
+	}
 	return &Env{
-		MysqlEnv: NewMysqlEnv(),
-		WatchEnv: watchEnv,
-		Port:     GetEnv("PORT", "8080"),
+		MysqlEnv:        NewMysqlEnv(location),
+		WatchEnv:        watchEnv,
+		ImportEnv:       importEnv,
+		Port:            GetEnv("PORT", "8080"),
+		AlertWebhookURL: alertWebhookURL,
+		ShutdownTimeout: shutdownTimeout,
 	}, err
 }
 
-func NewMysqlEnv() *MysqlEnv {
+func NewMysqlEnv(location *time.Location) *MysqlEnv {
 	user := GetEnv("MYSQL_USER", "xxxx")
 	host := GetEnv("MYSQL_HOST", "xxxx")
 	pass := GetEnv("MYSQL_PASSWORD", "xxxx")
@@ -46,6 +117,7 @@ func NewMysqlEnv() *MysqlEnv {
 		Host:     host,
 		Password: pass,
 		Port:     port,
+		Location: location,
 	}
 }
 
@@ -55,14 +127,129 @@ func NewWatchEnv() (*WatchEnv, error) {
 		pollingInterval = 1
 		err = xerrors.Errorf("POLLING_INTERVAL should be int: %w", err)
 	}
-	return &WatchEnv{
+	watchMode := GetEnv("WATCH_MODE", WatchModeAuto)
+	switch watchMode {
+	case WatchModeAuto, WatchModeFsnotify, WatchModePolling:
+	default:
+		if err == nil {
+			err = xerrors.Errorf("WATCH_MODE should be one of %s, %s, %s: %s", WatchModeAuto, WatchModeFsnotify, WatchModePolling, watchMode)
+		}
+		watchMode = WatchModeAuto
+	}
+	quietPeriod, quietErr := time.ParseDuration(GetEnv("QUIET_PERIOD", "10s"))
+	if quietErr != nil {
+		quietPeriod = 10 * time.Second
+		if err == nil {
+			err = xerrors.Errorf("QUIET_PERIOD should be duration (e.g. 10s): %w", quietErr)
+		}
+	}
+	gapTimeout, gapErr := time.ParseDuration(GetEnv("GAP_TIMEOUT", "30m"))
+	if gapErr != nil {
+		gapTimeout = 30 * time.Minute
+		if err == nil {
+			err = xerrors.Errorf("GAP_TIMEOUT should be duration (e.g. 30m): %w", gapErr)
+		}
+	}
+	accessTimeout, accessErr := time.ParseDuration(GetEnv("ACCESS_TIMEOUT", "30s"))
+	if accessErr != nil || accessTimeout <= 0 {
+		accessTimeout = 30 * time.Second
+		if err == nil {
+			err = xerrors.Errorf("ACCESS_TIMEOUT should be positive duration (e.g. 30s): %s", GetEnv("ACCESS_TIMEOUT", "30s"))
+		}
+	}
+	archiveMode := GetEnv("ARCHIVE_MODE", ArchiveModeNone)
+	switch archiveMode {
+	case ArchiveModeNone, ArchiveModeMove, ArchiveModeCopy:
+	default:
+		if err == nil {
+			err = xerrors.Errorf("ARCHIVE_MODE should be one of %s, %s, %s: %s", ArchiveModeNone, ArchiveModeMove, ArchiveModeCopy, archiveMode)
+		}
+		archiveMode = ArchiveModeNone
+	}
+	env := &WatchEnv{
 		PollingInterval: pollingInterval,
 		MountPath:       GetEnv("MOUNT_PATH", "/mnt/windows"),
+		WatchMode:       watchMode,
+		QuietPeriod:     quietPeriod,
+		GapTimeout:      gapTimeout,
+		ArchiveMode:     archiveMode,
+		ArchivePath:     GetEnv("ARCHIVE_PATH", ""),
+		AccessTimeout:   accessTimeout,
+	}
+	// 監視元のファイルが不正な場合は起動しないため、他のエラーより優先して返す
+	sources, sourcesErr := NewWatchSources(env)
+	if sourcesErr != nil {
+		err = sourcesErr
+	}
+	env.Sources = sources
+	return env, err
+}
+
+func NewImportEnv() (*ImportEnv, error) {
+	blockDuplicateReservation, err := strconv.ParseBool(GetEnv("BLOCK_DUPLICATE_RESERVATION", "false"))
+	if err != nil {
+		blockDuplicateReservation = false
+		err = xerrors.Errorf("BLOCK_DUPLICATE_RESERVATION should be bool: %w", err)
+	}
+	validationRules, rulesErr := NewValidationRules()
+	if err == nil {
+		err = rulesErr
+	}
+	importWorkers, workersErr := strconv.Atoi(GetEnv("IMPORT_WORKERS", "2"))
+	if workersErr != nil || importWorkers <= 0 {
+		importWorkers = 2
+		if err == nil {
+			err = xerrors.Errorf("IMPORT_WORKERS should be positive int: %s", GetEnv("IMPORT_WORKERS", "2"))
+		}
+	}
+	quarantineAfter, quarantineErr := strconv.Atoi(GetEnv("QUARANTINE_AFTER", "3"))
+	if quarantineErr != nil || quarantineAfter <= 0 {
+		quarantineAfter = 3
+		if err == nil {
+			err = xerrors.Errorf("QUARANTINE_AFTER should be positive int: %s", GetEnv("QUARANTINE_AFTER", "3"))
+		}
+	}
+	retryInterval, retryErr := time.ParseDuration(GetEnv("RETRY_INTERVAL", "1m"))
+	if retryErr != nil || retryInterval <= 0 {
+		retryInterval = time.Minute
+		if err == nil {
+			err = xerrors.Errorf("RETRY_INTERVAL should be positive duration (e.g. 1m): %s", GetEnv("RETRY_INTERVAL", "1m"))
+		}
+	}
+	return &ImportEnv{
+		BlockDuplicateReservation: blockDuplicateReservation,
+		ValidationRules:           validationRules,
+		ImportWorkers:             importWorkers,
+		QuarantineAfter:           quarantineAfter,
+		RetryInterval:             retryInterval,
 	}, err
 }
 
+// DefaultLocation 施設のタイムゾーンの既定値（日本標準時）
+func DefaultLocation() *time.Location {
+	location, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return time.FixedZone("Asia/Tokyo", 9*60*60)
+	}
+	return location
+}
+
+// NewLocation TIMEZONEから施設のタイムゾーンを返す。不正な場合はDefaultLocationとエラーが返る
+func NewLocation() (*time.Location, error) {
+	location, err := time.LoadLocation(GetEnv("TIMEZONE", "Asia/Tokyo"))
+	if err != nil {
+		return DefaultLocation(), xerrors.Errorf("TIMEZONE should be IANA time zone name: %w", err)
+	}
+	return location, nil
+}
+
+// DSN コンテナのタイムゾーンに依存しないよう、locには施設のタイムゾーンを指定する
 func (c *MysqlEnv) DSN() string {
-	return fmt.Sprintf(`%v:%v@tcp(%v:%v)/%s?charset=utf8mb4&parseTime=True&loc=Local`, c.User, c.Password, c.Host, c.Port, "xxxx")
+	location := c.Location
+	if location == nil {
+		location = DefaultLocation()
+	}
+	return fmt.Sprintf(`%v:%v@tcp(%v:%v)/%s?charset=utf8mb4&parseTime=True&loc=%s`, c.User, c.Password, c.Host, c.Port, "xxxx", url.QueryEscape(location.String()))
 }
 
 func GetEnv(key, def string) string {
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/xerrors"
+)
+
+const (
+	NoticeReservation = "予約"
+	NoticeCancel      = "取消"
+
+	// defaultSiteController サイトコントローラー個別のルールがない場合に使うキー
+	defaultSiteController = "default"
+)
+
+// ValidationRule 連携ファイルの項目ごとの検証ルール
+type ValidationRule struct {
+	// Field ReservationDataのフィールド名
+	Field string `mapstructure:"field"`
+	// Label エラーメッセージに出す項目名
+	Label    string `mapstructure:"label"`
+	Required bool   `mapstructure:"required"`
+	// Format 正規表現。未入力の場合はチェックしない
+	Format string   `mapstructure:"format"`
+	Min    *float64 `mapstructure:"min"`
+	Max    *float64 `mapstructure:"max"`
+	// SumOf 値が一致すべき項目（ReservationDataのフィールド名）の合計。お客様総合計人数と内訳の照合等に使う
+	SumOf []string `mapstructure:"sum_of"`
+
+	format *regexp.Regexp
+}
+
+// ValidationRules サイトコントローラー名、通知種別ごとの検証ルール
+type ValidationRules map[string]map[string][]ValidationRule
+
+// MatchFormat Formatが指定されていない場合は常にtrue
+func (r *ValidationRule) MatchFormat(value string) bool {
+	if r.format == nil {
+		return true
+	}
+	return r.format.MatchString(value)
+}
+
+// Rules サイトコントローラー名と通知種別に対応するルールを返す。
+// 個別のルールがない場合はdefault、それもない場合は組み込みのルールを返す
+func (r ValidationRules) Rules(siteControllerName, notice string) []ValidationRule {
+	// viperはキーを小文字にするため、サイトコントローラー名は大文字小文字を区別しない
+	for _, name := range []string{siteControllerName, defaultSiteController} {
+		if notices, ok := r[strings.ToLower(name)]; ok {
+			if rules, ok := notices[notice]; ok {
+				return rules
+			}
+		}
+	}
+	return DefaultValidationRules()[defaultSiteController][notice]
+}
+
+// Compile 正規表現をコンパイルし、Labelが未指定の場合はFieldを入れる
+func (r ValidationRules) Compile() error {
+	for name, notices := range r {
+		for notice, rules := range notices {
+			for i := range rules {
+				if rules[i].Field == "" {
+					return xerrors.Errorf("%s.%s[%d]: field is required", name, notice, i)
+				}
+				if rules[i].Label == "" {
+					rules[i].Label = rules[i].Field
+				}
+				if rules[i].Format == "" {
+					continue
+				}
+				format, err := regexp.Compile(rules[i].Format)
+				if err != nil {
+					return xerrors.Errorf("%s.%s[%d]: invalid format: %w", name, notice, i, err)
+				}
+				rules[i].format = format
+			}
+		}
+	}
+	return nil
+}
+
+// NewValidationRules VALIDATION_RULES_PATHのファイル（yaml, json等）から検証ルールを読み込む。
+// 未指定の場合は組み込みのルールを返す
+func NewValidationRules() (ValidationRules, error) {
+	path := GetEnv("VALIDATION_RULES_PATH", "")
+	if path == "" {
+		return DefaultValidationRules(), nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return DefaultValidationRules(), xerrors.Errorf("failed to read %s: %w", path, err)
+	}
+	rules := ValidationRules{}
+	if err := v.Unmarshal(&rules); err != nil {
+		return DefaultValidationRules(), xerrors.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	if err := rules.Compile(); err != nil {
+		return DefaultValidationRules(), xerrors.Errorf("invalid validation rules in %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// DefaultValidationRules 組み込みの検証ルール（必須項目のみ）
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		defaultSiteController: {
+			NoticeReservation: {
+				{Field: "Name", Label: "団体名または代表者氏名 漢字", Required: true},
+				{Field: "NameKana", Label: "団体名または代表者氏名(半角)", Required: true},
+				{Field: "PhoneNumber", Label: "団体または代表者番号", Required: true},
+				{Field: "PostalCode", Label: "団体または代表者郵便番号", Required: true},
+				{Field: "HomeAddress", Label: "団体または代表者住所", Required: true},
+				{Field: "ReservationHolder", Label: "予約者・会員名漢字", Required: true},
+				{Field: "ReservationHolderKana", Label: "予約者・会員名カタカナ", Required: true},
+				{Field: "StayDays", Label: "泊数", Required: true},
+				{Field: "NumberOfRooms", Label: "利用客室合計数", Required: true},
+				{Field: "NumberOfGuests", Label: "お客様総合計人数", Required: true},
+				{Field: "ProductName", Label: "プラン名", Required: true},
+			},
+			NoticeCancel: {
+				{Field: "Name", Label: "団体名または代表者氏名 漢字", Required: true},
+				{Field: "NameKana", Label: "団体名または代表者氏名(半角)", Required: true},
+				{Field: "PhoneNumber", Label: "団体または代表者番号", Required: true},
+			},
+		},
+	}
+}
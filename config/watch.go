@@ -0,0 +1,479 @@
+package config
+
+import (
+	"path/filepath"
+	"time"
+	"ui-backend-for-omotebako-site-controller/app/file"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// DefaultWatchSourceName WATCH_SOURCES_PATHが未指定の場合の監視元の名前
+	DefaultWatchSourceName = "default"
+	// ManualSourceName 画面から手動で登録したファイルの監視元の名前
+	ManualSourceName = "manual"
+
+	// ImportModeImport 予約情報をDBに登録する
+	ImportModeImport = "import"
+	// ImportModeValidate 検証のみ行い、予約情報はDBに登録しない（エラー・警告は記録する）
+	ImportModeValidate = "validate"
+
+	// ArchiveModeNone 取込後のファイルをそのままにする
+	ArchiveModeNone = "none"
+	// ArchiveModeMove 取込後のファイルを日付ごとのprocessed/、failed/に移動する（検証のみの監視元はコピーする）
+	ArchiveModeMove = "move"
+	// ArchiveModeCopy 取込後のファイルを日付ごとのprocessed/、failed/にコピーする
+	ArchiveModeCopy = "copy"
+
+	// ArchiveProcessedDir, ArchiveFailedDir 取込に成功した（失敗した）ファイルの移動先のディレクトリ名
+	ArchiveProcessedDir = "processed"
+	ArchiveFailedDir    = "failed"
+
+	// SourceTypeLocal ローカル（マウントした共有フォルダ）のディレクトリを監視する
+	SourceTypeLocal = "local"
+	// SourceTypeSFTP SFTPサーバのディレクトリからファイルを取得する
+	SourceTypeSFTP = "sftp"
+
+	// RemoteAfterKeep, RemoteAfterDelete, RemoteAfterMove SFTPから取得したファイルをそのままにする（削除する、移動する）
+	RemoteAfterKeep   = "keep"
+	RemoteAfterDelete = "delete"
+	RemoteAfterMove   = "move"
+
+	// ConservationPath 画面から登録したファイル、SFTPから取得したファイルを保存するディレクトリ
+	ConservationPath = "/var/lib/aion/Data"
+)
+
+// DefaultExcludePatterns excludeが未指定の場合に対象外にするファイル（Excelのロックファイル、一時ファイル、Windowsの設定ファイル、隠しファイル）
+var DefaultExcludePatterns = []string{"~$*", "*.tmp", `re:(?i)(^|/)(desktop\.ini|thumbs\.db)$`, ".*"}
+
+// WatchSource 監視するディレクトリごとの設定
+type WatchSource struct {
+	// Name 監視元の名前。csv_upload_transaction.sourceに記録し、処理済みファイル台帳も監視元ごとに持つ
+	Name string `mapstructure:"name"`
+	// Type local（デフォルト）、sftp
+	Type string `mapstructure:"type"`
+	// Path 監視するディレクトリ。sftpの場合はSFTPサーバのディレクトリ
+	Path string `mapstructure:"path"`
+	// SFTP type: sftpの接続先
+	SFTP *SFTPSource `mapstructure:"sftp"`
+	// DownloadPath SFTPから取得したファイルを置くディレクトリ。未指定の場合はConservationPath/sftp/{name}
+	DownloadPath string `mapstructure:"download_path"`
+	// SiteController 取込に使うサイトコントローラー名
+	SiteController string `mapstructure:"site_controller"`
+	// Pattern ファイル名のパターン（filepath.Matchの書式）。includeが未指定の場合に使う
+	Pattern    string `mapstructure:"pattern"`
+	ImportMode string `mapstructure:"import_mode"`
+
+	// 監視対象にするファイルの条件。パターンはglobか、re:で始まる正規表現（file.Patternを参照）
+	// Include いずれかに一致するファイルを取り込む。未指定の場合はPattern
+	Include []string `mapstructure:"include"`
+	// Exclude いずれかに一致するファイル・ディレクトリを取り込まない。未指定の場合はDefaultExcludePatterns
+	Exclude []string `mapstructure:"exclude"`
+	// MaxDepth たどるディレクトリの深さ（1：監視するディレクトリ直下のみ）。0は無制限
+	MaxDepth int `mapstructure:"max_depth"`
+	// MaxAge 更新日時がこれより古いファイルは取り込まない（例：720h）。0は無制限
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// PollingInterval, WatchMode 未指定の場合はPOLLING_INTERVAL, WATCH_MODE
+	PollingInterval int    `mapstructure:"polling_interval"`
+	WatchMode       string `mapstructure:"watch_mode"`
+
+	// 書き込み中のファイルを取り込まないための設定
+	// QuietPeriod 最終更新からこの時間が経過するまで取り込まない（例：30s）。未指定の場合はQUIET_PERIOD
+	QuietPeriod *time.Duration `mapstructure:"quiet_period"`
+	// TempPatterns 書き込み中の一時ファイル名のパターン（例：*.tmp）。一致するファイルは取り込まない
+	TempPatterns []string `mapstructure:"temp_patterns"`
+	// LockSuffixes ファイル名にこの接尾辞を付けたファイル（例：.lock）がある間は書き込み中とみなす
+	LockSuffixes []string `mapstructure:"lock_suffixes"`
+
+	// GapTimeout 通知番号に欠番がある場合に、それより後の通知を含むファイルの取込を保留する時間（例：30m）。
+	// 経過すると欠番を待たずに取り込む。0の場合は保留しない。未指定の場合はGAP_TIMEOUT
+	GapTimeout *time.Duration `mapstructure:"gap_timeout"`
+
+	// ArchiveMode none、move、copy（未指定の場合はARCHIVE_MODE）
+	ArchiveMode string `mapstructure:"archive_mode"`
+	// ArchivePath processed/、failed/を作るディレクトリ。未指定の場合はARCHIVE_PATH、それもなければPath（共有フォルダ内）
+	ArchivePath string `mapstructure:"archive_path"`
+
+	// 共有フォルダの切断を空のディレクトリと区別するための設定
+	// SentinelFile 監視するディレクトリからの相対パス。このファイルが見えない場合は共有フォルダが切断されているとみなす
+	SentinelFile string `mapstructure:"sentinel_file"`
+	// MountTypes 監視するディレクトリを含むマウントのファイルシステム（/proc/self/mountsの種類。例：fuse.smbnetfs）。
+	// 一致しない場合はマウントされていないとみなす。未指定の場合は確認しない
+	MountTypes []string `mapstructure:"mount_types"`
+	// AccessTimeout 監視するディレクトリへのアクセスが終わらない場合に応答がないとみなす時間（例：30s）。未指定の場合はACCESS_TIMEOUT
+	AccessTimeout time.Duration `mapstructure:"access_timeout"`
+
+	// ExpectedArrivals 新しいファイルが届くはずの間隔。届かない場合はサイトコントローラーの出力が止まったとみなしてアラートにする
+	ExpectedArrivals []ArrivalRule `mapstructure:"expected_arrivals"`
+
+	// Schedules スキャンする時刻のcron式（分 時 日 月 曜日、施設のタイムゾーン。例：*/2 6-22 * * *）。
+	// 指定した場合はpolling_intervalの代わりにいずれかの時刻にスキャンする。watch_modeが未指定の場合はpolling
+	Schedules []string `mapstructure:"schedules"`
+	// QuietHours 取込を止める時間帯。時間帯内に検知したファイルは取込ジョブに登録し、時間帯が終わってから取り込む
+	QuietHours []QuietHours `mapstructure:"quiet_hours"`
+}
+
+// ArrivalRule 時間帯内に、Withinごとに少なくとも1つのファイルが届くというルール
+type ArrivalRule struct {
+	// Within 最後にファイルが届いてから（時間帯の開始から）この時間内に次のファイルが届く（例：6h）
+	Within time.Duration `mapstructure:"within"`
+	// From, To ルールを適用する時間帯（HH:MM、施設のタイムゾーン）。未指定の場合は終日。ToがFrom以前の場合は翌日のToまで
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+	// Weekdays ルールを適用する曜日（sun、mon、tue、wed、thu、fri、sat。時間帯が日をまたぐ場合は開始日の曜日）。未指定の場合は毎日
+	Weekdays []string `mapstructure:"weekdays"`
+}
+
+// QuietHours 取込を止める時間帯（夜間監査中等）
+type QuietHours struct {
+	// From, To 時間帯（HH:MM、施設のタイムゾーン）。未指定の場合は終日。ToがFrom以前の場合は翌日のToまで
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+	// Weekdays 時間帯の曜日（時間帯が日をまたぐ場合は開始日の曜日）。未指定の場合は毎日
+	Weekdays []string `mapstructure:"weekdays"`
+}
+
+// InQuietHours tがquiet_hoursのいずれかの時間帯内か。tは施設のタイムゾーンにする
+func (s *WatchSource) InQuietHours(t time.Time) bool {
+	for _, hours := range s.QuietHours {
+		if _, _, ok := ClockWindow(hours.From, hours.To, hours.Weekdays, t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SFTPSource SFTPサーバへの接続と、取得したファイルの扱い
+type SFTPSource struct {
+	Host string `mapstructure:"host"`
+	// Port 未指定の場合は22
+	Port int    `mapstructure:"port"`
+	User string `mapstructure:"user"`
+	// Password, PrivateKeyPath パスワードか秘密鍵のいずれかで認証する
+	Password       string `mapstructure:"password"`
+	PrivateKeyPath string `mapstructure:"private_key_path"`
+	// KnownHostsPath, HostKey サーバの公開鍵をknown_hostsか、フィンガープリント（SHA256:...）で確認する。いずれかが必要
+	KnownHostsPath string `mapstructure:"known_hosts_path"`
+	HostKey        string `mapstructure:"host_key"`
+	// After 取込ジョブに登録したファイルをSFTPサーバでkeep（そのまま、デフォルト）、delete（削除）、move（MovePathに移動）する
+	After    string `mapstructure:"after"`
+	MovePath string `mapstructure:"move_path"`
+}
+
+// LocalPath 取込ジョブが読み込むディレクトリ。sftpの場合は取得したファイルを置くDownloadPath
+func (s *WatchSource) LocalPath() string {
+	if s.Type == SourceTypeSFTP {
+		return s.DownloadPath
+	}
+	return s.Path
+}
+
+func validateSFTPSource(sftp *SFTPSource) error {
+	if sftp == nil {
+		return xerrors.New("sftp is required")
+	}
+	if sftp.Host == "" || sftp.User == "" {
+		return xerrors.New("sftp.host and sftp.user are required")
+	}
+	if sftp.Password == "" && sftp.PrivateKeyPath == "" {
+		return xerrors.New("sftp.password or sftp.private_key_path is required")
+	}
+	if sftp.KnownHostsPath == "" && sftp.HostKey == "" {
+		return xerrors.New("sftp.known_hosts_path or sftp.host_key is required")
+	}
+	switch sftp.After {
+	case RemoteAfterKeep, RemoteAfterDelete:
+	case RemoteAfterMove:
+		if sftp.MovePath == "" {
+			return xerrors.New("sftp.move_path is required when sftp.after is move")
+		}
+	default:
+		return xerrors.Errorf("sftp.after should be one of %s, %s, %s: %s", RemoteAfterKeep, RemoteAfterDelete, RemoteAfterMove, sftp.After)
+	}
+	return nil
+}
+
+// Weekdays ArrivalRule.Weekdays、QuietHours.Weekdaysに指定する曜日
+var Weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseClock HH:MMを0時からの時間にする。空の場合はdefaultValueを返す
+func ParseClock(clock string, defaultValue time.Duration) (time.Duration, error) {
+	if clock == "" {
+		return defaultValue, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, xerrors.Errorf("time should be HH:MM: %s", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ClockWindow nowを含む、from～to（HH:MM）でweekdaysの曜日の時間帯の開始と終了。時間帯外の場合はfalseを返す。
+// from、toが両方空の場合は終日で日付で区切らないため、開始と終了はゼロ値になる
+func ClockWindow(from, to string, weekdays []string, now time.Time) (time.Time, time.Time, bool) {
+	// 設定はチェック済み
+	fromClock, _ := ParseClock(from, 0)
+	toClock, _ := ParseClock(to, 24*time.Hour)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var start, end time.Time
+	switch {
+	case from == "" && to == "":
+		return time.Time{}, time.Time{}, matchWeekday(weekdays, now)
+	case fromClock < toClock:
+		start, end = midnight.Add(fromClock), midnight.Add(toClock)
+	case now.Sub(midnight) >= fromClock:
+		// 日をまたぐ時間帯の開始日
+		start, end = midnight.Add(fromClock), midnight.AddDate(0, 0, 1).Add(toClock)
+	default:
+		// 日をまたぐ時間帯の終了日
+		start, end = midnight.AddDate(0, 0, -1).Add(fromClock), midnight.Add(toClock)
+	}
+	if now.Before(start) || !now.Before(end) || !matchWeekday(weekdays, start) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+func matchWeekday(weekdays []string, t time.Time) bool {
+	if len(weekdays) == 0 {
+		return true
+	}
+	for _, weekday := range weekdays {
+		if Weekdays[weekday] == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func validateClockWindow(from, to string, weekdays []string) error {
+	if _, err := ParseClock(from, 0); err != nil {
+		return err
+	}
+	if _, err := ParseClock(to, 0); err != nil {
+		return err
+	}
+	for _, weekday := range weekdays {
+		if _, ok := Weekdays[weekday]; !ok {
+			return xerrors.Errorf("unknown weekday: %s", weekday)
+		}
+	}
+	return nil
+}
+
+func validateArrivalRule(rule ArrivalRule) error {
+	if rule.Within <= 0 {
+		return xerrors.Errorf("within should be positive: %v", rule.Within)
+	}
+	return validateClockWindow(rule.From, rule.To, rule.Weekdays)
+}
+
+// WatchSourcesError WATCH_SOURCES_PATHのファイルが不正。監視元が分からないため起動しない
+type WatchSourcesError struct {
+	Path string
+	Err  error
+}
+
+func (e *WatchSourcesError) Error() string {
+	return "invalid watch sources in " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *WatchSourcesError) Unwrap() error {
+	return e.Err
+}
+
+// NewWatchSources WATCH_SOURCES_PATHのファイルから監視元を読み込む。
+// 未指定の場合はMOUNT_PATH、SITE_CONTROLLER_NAMEから監視元を1つ作る。ファイルが不正な場合は*WatchSourcesErrorを返す
+func NewWatchSources(env *WatchEnv) ([]WatchSource, error) {
+	path := GetEnv("WATCH_SOURCES_PATH", "")
+	if path == "" {
+		return completeWatchSources([]WatchSource{{
+			Name:           DefaultWatchSourceName,
+			Path:           env.MountPath,
+			SiteController: GetEnv("SITE_CONTROLLER_NAME", "Lincoln"),
+		}}, env)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, &WatchSourcesError{Path: path, Err: xerrors.Errorf("failed to read: %w", err)}
+	}
+	var sources []WatchSource
+	if err := v.UnmarshalKey("sources", &sources); err != nil {
+		return nil, &WatchSourcesError{Path: path, Err: xerrors.Errorf("failed to unmarshal: %w", err)}
+	}
+	if len(sources) == 0 {
+		return nil, &WatchSourcesError{Path: path, Err: xerrors.New("no sources")}
+	}
+	if _, err := completeWatchSources(sources, env); err != nil {
+		return nil, &WatchSourcesError{Path: path, Err: err}
+	}
+	return sources, nil
+}
+
+// completeWatchSources 未指定の項目にデフォルト値を入れ、設定をチェックする
+func completeWatchSources(sources []WatchSource, env *WatchEnv) ([]WatchSource, error) {
+	names := map[string]bool{}
+	for i := range sources {
+		source := &sources[i]
+		if source.Type == "" {
+			source.Type = SourceTypeLocal
+		}
+		if source.Type == SourceTypeSFTP {
+			if source.DownloadPath == "" {
+				source.DownloadPath = filepath.Join(ConservationPath, "sftp", source.Name)
+			}
+			// SFTPサーバはinotifyで監視できない
+			source.WatchMode = WatchModePolling
+			if source.SFTP != nil {
+				if source.SFTP.Port == 0 {
+					source.SFTP.Port = 22
+				}
+				if source.SFTP.After == "" {
+					source.SFTP.After = RemoteAfterKeep
+				}
+			}
+		}
+		if source.Pattern == "" {
+			source.Pattern = "*"
+		}
+		if source.Include == nil {
+			source.Include = []string{source.Pattern}
+		}
+		if source.Exclude == nil {
+			source.Exclude = DefaultExcludePatterns
+		}
+		if source.ImportMode == "" {
+			source.ImportMode = ImportModeImport
+		}
+		if source.PollingInterval <= 0 {
+			source.PollingInterval = env.PollingInterval
+		}
+		if source.WatchMode == "" && len(source.Schedules) > 0 {
+			// イベントでスキャンするとschedulesの時刻以外にも取り込むため
+			source.WatchMode = WatchModePolling
+		}
+		if source.WatchMode == "" {
+			source.WatchMode = env.WatchMode
+		}
+		if source.QuietPeriod == nil {
+			quietPeriod := env.QuietPeriod
+			source.QuietPeriod = &quietPeriod
+		}
+		if source.GapTimeout == nil {
+			gapTimeout := env.GapTimeout
+			source.GapTimeout = &gapTimeout
+		}
+		if source.ArchiveMode == "" {
+			source.ArchiveMode = env.ArchiveMode
+		}
+		if source.ArchiveMode == "" {
+			source.ArchiveMode = ArchiveModeNone
+		}
+		if source.ArchivePath == "" {
+			source.ArchivePath = env.ArchivePath
+		}
+		if source.ArchivePath == "" {
+			source.ArchivePath = source.LocalPath()
+		}
+		if source.AccessTimeout == 0 {
+			source.AccessTimeout = env.AccessTimeout
+		}
+
+		if source.Name == "" {
+			return sources, xerrors.Errorf("sources[%d]: name is required", i)
+		}
+		if source.Name == ManualSourceName {
+			return sources, xerrors.Errorf("sources[%d]: %s is reserved for manual upload", i, ManualSourceName)
+		}
+		if names[source.Name] {
+			return sources, xerrors.Errorf("sources[%d]: duplicate name %s", i, source.Name)
+		}
+		names[source.Name] = true
+		if source.Path == "" {
+			return sources, xerrors.Errorf("%s: path is required", source.Name)
+		}
+		if source.SiteController == "" {
+			return sources, xerrors.Errorf("%s: site_controller is required", source.Name)
+		}
+		switch source.Type {
+		case SourceTypeLocal:
+		case SourceTypeSFTP:
+			if err := validateSFTPSource(source.SFTP); err != nil {
+				return sources, xerrors.Errorf("%s: %w", source.Name, err)
+			}
+		default:
+			return sources, xerrors.Errorf("%s: type should be %s or %s: %s", source.Name, SourceTypeLocal, SourceTypeSFTP, source.Type)
+		}
+		for _, pattern := range append([]string{source.Pattern}, source.TempPatterns...) {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return sources, xerrors.Errorf("%s: invalid pattern %s: %w", source.Name, pattern, err)
+			}
+		}
+		if _, err := file.NewFilter(source.Include, source.Exclude, source.MaxDepth, source.MaxAge); err != nil {
+			return sources, xerrors.Errorf("%s: %w", source.Name, err)
+		}
+		if *source.GapTimeout < 0 {
+			return sources, xerrors.Errorf("%s: gap_timeout should not be negative: %v", source.Name, *source.GapTimeout)
+		}
+		if source.MaxDepth < 0 {
+			return sources, xerrors.Errorf("%s: max_depth should not be negative: %d", source.Name, source.MaxDepth)
+		}
+		if source.MaxAge < 0 {
+			return sources, xerrors.Errorf("%s: max_age should not be negative: %v", source.Name, source.MaxAge)
+		}
+		if source.AccessTimeout <= 0 {
+			return sources, xerrors.Errorf("%s: access_timeout should be positive: %v", source.Name, source.AccessTimeout)
+		}
+		if filepath.IsAbs(source.SentinelFile) {
+			return sources, xerrors.Errorf("%s: sentinel_file should be relative to path: %s", source.Name, source.SentinelFile)
+		}
+		if *source.QuietPeriod < 0 {
+			return sources, xerrors.Errorf("%s: quiet_period should not be negative: %v", source.Name, *source.QuietPeriod)
+		}
+		for j, rule := range source.ExpectedArrivals {
+			if err := validateArrivalRule(rule); err != nil {
+				return sources, xerrors.Errorf("%s: expected_arrivals[%d]: %w", source.Name, j, err)
+			}
+		}
+		for j, schedule := range source.Schedules {
+			if _, err := cron.ParseStandard(schedule); err != nil {
+				return sources, xerrors.Errorf("%s: schedules[%d]: invalid cron expression %s: %w", source.Name, j, schedule, err)
+			}
+		}
+		for j, hours := range source.QuietHours {
+			if err := validateClockWindow(hours.From, hours.To, hours.Weekdays); err != nil {
+				return sources, xerrors.Errorf("%s: quiet_hours[%d]: %w", source.Name, j, err)
+			}
+		}
+		switch source.ImportMode {
+		case ImportModeImport, ImportModeValidate:
+		default:
+			return sources, xerrors.Errorf("%s: import_mode should be %s or %s: %s", source.Name, ImportModeImport, ImportModeValidate, source.ImportMode)
+		}
+		switch source.WatchMode {
+		case WatchModeAuto, WatchModeFsnotify, WatchModePolling:
+		default:
+			return sources, xerrors.Errorf("%s: watch_mode should be one of %s, %s, %s: %s", source.Name, WatchModeAuto, WatchModeFsnotify, WatchModePolling, source.WatchMode)
+		}
+		switch source.ArchiveMode {
+		case ArchiveModeNone, ArchiveModeMove, ArchiveModeCopy:
+		default:
+			return sources, xerrors.Errorf("%s: archive_mode should be one of %s, %s, %s: %s", source.Name, ArchiveModeNone, ArchiveModeMove, ArchiveModeCopy, source.ArchiveMode)
+		}
+	}
+	return sources, nil
+}
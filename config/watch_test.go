@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func TestCompleteWatchSources(t *testing.T) {
+	env := &WatchEnv{PollingInterval: 3, WatchMode: WatchModeAuto, AccessTimeout: 30 * time.Second}
+
+	sources, err := completeWatchSources([]WatchSource{
+		{Name: "lincoln", Path: "/mnt/windows/lincoln", SiteController: "Lincoln"},
+		{Name: "annex", Path: "/mnt/windows/annex", SiteController: "Lincoln", Pattern: "*.csv", ImportMode: ImportModeValidate, PollingInterval: 5, WatchMode: WatchModePolling},
+	}, env)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := sources[0]; got.Pattern != "*" || got.ImportMode != ImportModeImport || got.PollingInterval != 3 || got.WatchMode != WatchModeAuto || got.ArchiveMode != ArchiveModeNone || got.ArchivePath != "/mnt/windows/lincoln" || got.AccessTimeout != 30*time.Second || len(got.Include) != 1 || got.Include[0] != "*" || len(got.Exclude) != len(DefaultExcludePatterns) {
+		t.Errorf("defaults are not set: %+v", got)
+	}
+	if got := sources[1]; got.Pattern != "*.csv" || got.ImportMode != ImportModeValidate || got.PollingInterval != 5 || got.WatchMode != WatchModePolling {
+		t.Errorf("overridden by defaults: %+v", got)
+	}
+
+	sftpSources, err := completeWatchSources([]WatchSource{
+		{Name: "remote", Type: SourceTypeSFTP, Path: "/exports", SiteController: "Lincoln", SFTP: &SFTPSource{Host: "sftp.example.com", User: "omotebako", Password: "secret", HostKey: "SHA256:xxxx"}},
+	}, env)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := sftpSources[0]; got.DownloadPath != "/var/lib/aion/Data/sftp/remote" || got.LocalPath() != got.DownloadPath || got.ArchivePath != got.DownloadPath || got.WatchMode != WatchModePolling || got.SFTP.Port != 22 || got.SFTP.After != RemoteAfterKeep {
+		t.Errorf("sftp defaults are not set: %+v, %+v", got, got.SFTP)
+	}
+
+	scheduled, err := completeWatchSources([]WatchSource{
+		{Name: "scheduled", Path: "/a", SiteController: "Lincoln", Schedules: []string{"*/2 6-22 * * *", "0 23,0-5 * * *"}},
+	}, env)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := scheduled[0]; got.WatchMode != WatchModePolling {
+		t.Errorf("schedules should default to polling: %+v", got)
+	}
+
+	invalids := map[string][]WatchSource{
+		"名前なし":         {{Path: "/mnt/windows", SiteController: "Lincoln"}},
+		"名前の重複":        {{Name: "a", Path: "/a", SiteController: "Lincoln"}, {Name: "a", Path: "/b", SiteController: "Lincoln"}},
+		"手動登録の名前":      {{Name: ManualSourceName, Path: "/a", SiteController: "Lincoln"}},
+		"パターンが不正":      {{Name: "a", Path: "/a", SiteController: "Lincoln", Pattern: "["}},
+		"取込モードが不正":     {{Name: "a", Path: "/a", SiteController: "Lincoln", ImportMode: "copy"}},
+		"サイトコントローラーなし": {{Name: "a", Path: "/a"}},
+		"移動方法が不正":      {{Name: "a", Path: "/a", SiteController: "Lincoln", ArchiveMode: "delete"}},
+		"正規表現が不正":      {{Name: "a", Path: "/a", SiteController: "Lincoln", Exclude: []string{"re:("}}},
+		"深さが負":         {{Name: "a", Path: "/a", SiteController: "Lincoln", MaxDepth: -1}},
+		"種類が不正":        {{Name: "a", Type: "ftp", Path: "/a", SiteController: "Lincoln"}},
+		"SFTPの設定なし":    {{Name: "a", Type: SourceTypeSFTP, Path: "/a", SiteController: "Lincoln"}},
+		"SFTPのホスト鍵なし":  {{Name: "a", Type: SourceTypeSFTP, Path: "/a", SiteController: "Lincoln", SFTP: &SFTPSource{Host: "h", User: "u", Password: "p"}}},
+		"SFTPの移動先なし":   {{Name: "a", Type: SourceTypeSFTP, Path: "/a", SiteController: "Lincoln", SFTP: &SFTPSource{Host: "h", User: "u", Password: "p", HostKey: "SHA256:xxxx", After: RemoteAfterMove}}},
+		"センチネルが絶対パス":   {{Name: "a", Path: "/a", SiteController: "Lincoln", SentinelFile: "/a/.sentinel"}},
+		"到着間隔なし":       {{Name: "a", Path: "/a", SiteController: "Lincoln", ExpectedArrivals: []ArrivalRule{{From: "08:00"}}}},
+		"時間帯が不正":       {{Name: "a", Path: "/a", SiteController: "Lincoln", ExpectedArrivals: []ArrivalRule{{Within: time.Hour, From: "8時"}}}},
+		"曜日が不正":        {{Name: "a", Path: "/a", SiteController: "Lincoln", ExpectedArrivals: []ArrivalRule{{Within: time.Hour, Weekdays: []string{"monday"}}}}},
+		"cron式が不正":     {{Name: "a", Path: "/a", SiteController: "Lincoln", Schedules: []string{"*/2 6-22 * *"}}},
+		"停止時間帯が不正":     {{Name: "a", Path: "/a", SiteController: "Lincoln", QuietHours: []QuietHours{{From: "25:00", To: "03:00"}}}},
+	}
+	for name, sources := range invalids {
+		if _, err := completeWatchSources(sources, env); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestNewWatchSourcesInvalidFile(t *testing.T) {
+	env := &WatchEnv{PollingInterval: 3, WatchMode: WatchModeAuto, MountPath: "/mnt/windows", AccessTimeout: 30 * time.Second}
+	dir := t.TempDir()
+	files := map[string]string{
+		"監視元なし":  "sources: []\n",
+		"監視元が不正": "sources:\n  - path: /a\n    site_controller: Lincoln\n",
+	}
+	defer os.Unsetenv("WATCH_SOURCES_PATH")
+	for name, content := range files {
+		path := filepath.Join(dir, name+".yml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("WATCH_SOURCES_PATH", path)
+		sources, err := NewWatchSources(env)
+		var sourcesErr *WatchSourcesError
+		if !xerrors.As(err, &sourcesErr) || sources != nil {
+			t.Errorf("%s: got %v, %v, want WatchSourcesError without MOUNT_PATH", name, sources, err)
+		}
+	}
+	// 存在しないファイル
+	os.Setenv("WATCH_SOURCES_PATH", filepath.Join(dir, "none.yml"))
+	if _, err := NewWatchSources(env); err == nil {
+		t.Error("want error for missing file")
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	source := &WatchSource{QuietHours: []QuietHours{
+		// 夜間監査（日をまたぐ）
+		{From: "23:00", To: "02:00"},
+		// 日曜日は終日
+		{Weekdays: []string{"sun"}},
+	}}
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{time.Date(2021, 4, 1, 22, 59, 0, 0, jst), false},
+		{time.Date(2021, 4, 1, 23, 0, 0, 0, jst), true},
+		{time.Date(2021, 4, 2, 1, 59, 0, 0, jst), true},
+		{time.Date(2021, 4, 2, 2, 0, 0, 0, jst), false},
+		// 2021-04-04は日曜日
+		{time.Date(2021, 4, 4, 12, 0, 0, 0, jst), true},
+	}
+	for _, tt := range tests {
+		if got := source.InQuietHours(tt.now); got != tt.want {
+			t.Errorf("InQuietHours(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+}
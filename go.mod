@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/friendsofgo/errors v0.9.2
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.7.2
 	github.com/go-sql-driver/mysql v1.6.0
@@ -12,13 +13,16 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/kat-co/vala v0.0.0-20170210184112-xxxxxx
 	github.com/modern-go/concurrent v0.0.0-20180306012644-xxxxxx // indirect
+	github.com/pkg/sftp v1.13.4
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/viper v1.8.1
 	github.com/volatiletech/null/v8 v8.1.2
 	github.com/volatiletech/randomize v0.0.1
 	github.com/volatiletech/sqlboiler/v4 v4.6.0
 	github.com/volatiletech/strmangle v0.0.1
 	go.uber.org/zap v1.18.1
-	golang.org/x/text v0.3.6 // indirect
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/text v0.3.6
 	golang.org/x/xerrors v0.0.0-20200804184101-xxxxxx
 	gopkg.in/check.v1 v1.0.0-20190902080502-xxxxxx // indirect
 )
@@ -0,0 +1,48 @@
+-- 部屋タイプ別・日別の在庫室数
+CREATE TABLE IF NOT EXISTS room_inventory (
+    room_type       VARCHAR(64) NOT NULL,
+    stay_date       DATE        NOT NULL,
+    number_of_rooms INT         NOT NULL DEFAULT 0,
+    update_date     DATETIME    NULL,
+    PRIMARY KEY (room_type, stay_date)
+);
+
+-- 予約ごとの部屋タイプ別・日別の利用室数
+CREATE TABLE IF NOT EXISTS reservation_rooms (
+    id              INT         NOT NULL AUTO_INCREMENT,
+    reservation_id  INT         NOT NULL,
+    room_type       VARCHAR(64) NOT NULL,
+    stay_date       DATE        NOT NULL,
+    number_of_rooms INT         NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    INDEX idx_reservation_rooms_room_type_stay_date (room_type, stay_date),
+    INDEX idx_reservation_rooms_reservation_id (reservation_id)
+);
+
+-- 取込時に検出したオーバーブッキング
+CREATE TABLE IF NOT EXISTS overbookings (
+    id              INT         NOT NULL AUTO_INCREMENT,
+    reservation_id  INT         NOT NULL,
+    room_type       VARCHAR(64) NOT NULL,
+    stay_date       DATE        NOT NULL,
+    requested_rooms INT         NOT NULL,
+    remaining_rooms INT         NOT NULL,
+    create_date     DATETIME    NULL,
+    PRIMARY KEY (id),
+    INDEX idx_overbookings_stay_date (stay_date),
+    INDEX idx_overbookings_reservation_id (reservation_id)
+);
+
+-- 取込時の警告（取込自体は成功した行）
+CREATE TABLE IF NOT EXISTS csv_execution_warnings (
+    id                    INT          NOT NULL AUTO_INCREMENT,
+    line_number           INT          NOT NULL,
+    customer_name         VARCHAR(255) NULL,
+    customer_phone_number VARCHAR(255) NULL,
+    warning_message       TEXT         NOT NULL,
+    status                INT          NOT NULL DEFAULT 0,
+    csv_id                INT          NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_csv_execution_warnings_csv_id (csv_id),
+    CONSTRAINT fk_csv_execution_warnings_csv_id FOREIGN KEY (csv_id) REFERENCES csv_upload_transaction (id)
+);
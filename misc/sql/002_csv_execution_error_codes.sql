@@ -0,0 +1,14 @@
+-- 取込エラー・警告のコード、項目名、パラメータ（JSON）
+-- 表示時にAccept-Languageに応じてコードとパラメータからメッセージを組み立てる
+ALTER TABLE csv_execution_errors
+    ADD COLUMN error_code VARCHAR(64)  NULL,
+    ADD COLUMN field_name VARCHAR(128) NULL,
+    ADD COLUMN severity   VARCHAR(16)  NULL,
+    ADD COLUMN params     TEXT         NULL,
+    ADD INDEX idx_csv_execution_errors_error_code (error_code);
+
+ALTER TABLE csv_execution_warnings
+    ADD COLUMN error_code VARCHAR(64)  NULL,
+    ADD COLUMN field_name VARCHAR(128) NULL,
+    ADD COLUMN params     TEXT         NULL,
+    ADD INDEX idx_csv_execution_warnings_error_code (error_code);
@@ -0,0 +1,9 @@
+-- 予約の人数内訳（食事・寝具の準備に使う）
+-- number_of_guestsは大人と子供の合計。添乗員数は含まない
+ALTER TABLE reservations
+    ADD COLUMN number_of_guests_adult   SMALLINT NULL AFTER number_of_guests,
+    ADD COLUMN number_of_guests_child_a SMALLINT NULL AFTER number_of_guests_female,
+    ADD COLUMN number_of_guests_child_b SMALLINT NULL AFTER number_of_guests_child_a,
+    ADD COLUMN number_of_guests_child_c SMALLINT NULL AFTER number_of_guests_child_b,
+    ADD COLUMN number_of_guests_child_d SMALLINT NULL AFTER number_of_guests_child_c,
+    ADD COLUMN number_of_guide          SMALLINT NULL AFTER number_of_guests_child_d;
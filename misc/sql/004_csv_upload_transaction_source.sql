@@ -0,0 +1,6 @@
+-- 取込ファイルの監視元とサイトコントローラー
+-- 監視元ごとに最新のファイル作成日時を取込の基準にする。手動登録はsource = 'manual'
+ALTER TABLE csv_upload_transaction
+    ADD COLUMN source          VARCHAR(64) NULL,
+    ADD COLUMN site_controller VARCHAR(64) NULL,
+    ADD INDEX idx_csv_upload_transaction_source (source, created_time_in_windows);
@@ -0,0 +1,15 @@
+-- 監視元ごとの処理済みファイル台帳
+-- パスと内容のハッシュで処理済みかを判定し、タイムスタンプに関わらず同じファイルは1度だけ処理する
+CREATE TABLE IF NOT EXISTS processed_files (
+    id           INT          NOT NULL AUTO_INCREMENT,
+    source       VARCHAR(64)  NOT NULL,
+    path         VARCHAR(512) NOT NULL,
+    content_hash CHAR(64)     NOT NULL,
+    size         BIGINT       NOT NULL,
+    mod_time     DATETIME     NOT NULL,
+    csv_id       INT          NULL,
+    create_date  DATETIME     NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_processed_files_source_path_hash (source, path(255), content_hash),
+    INDEX idx_processed_files_csv_id (csv_id)
+);
@@ -0,0 +1,6 @@
+-- 処理済みファイル台帳にファイル内の通知番号の最小・最大を記録する
+-- 最大の通知番号から欠番を検知し、欠番より後の通知を含むファイルの取込を保留する
+ALTER TABLE processed_files
+    ADD COLUMN first_notification_number INT NULL AFTER mod_time,
+    ADD COLUMN last_notification_number  INT NULL AFTER first_notification_number,
+    ADD INDEX idx_processed_files_source_notification (source, last_notification_number);
@@ -0,0 +1,25 @@
+-- 取込ジョブ
+-- 監視で検知したファイルと画面から登録したファイルを登録し、ワーカーが監視元ごとに登録順に取り込む。
+-- 停止時に実行中（running）だったジョブは、起動時に待機中（queued）に戻して再開する
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id              INT          NOT NULL AUTO_INCREMENT,
+    source          VARCHAR(64)  NOT NULL,
+    site_controller VARCHAR(64)  NOT NULL,
+    import_mode     VARCHAR(16)  NOT NULL,
+    dir             VARCHAR(512) NOT NULL,
+    file_name       VARCHAR(255) NOT NULL,
+    file_path       VARCHAR(512) NOT NULL,
+    created_time    DATETIME     NOT NULL,
+    ledger_id       INT          NULL,
+    csv_id          INT          NULL,
+    status          VARCHAR(16)  NOT NULL,
+    attempts        INT          NOT NULL DEFAULT 0,
+    last_error      TEXT         NULL,
+    started_at      DATETIME     NULL,
+    finished_at     DATETIME     NULL,
+    create_date     DATETIME     NULL,
+    update_date     DATETIME     NULL,
+    PRIMARY KEY (id),
+    INDEX idx_import_jobs_status (status, id),
+    INDEX idx_import_jobs_source (source, id)
+);
@@ -0,0 +1,3 @@
+-- 停止時に中断した取込ジョブの再開位置（取込順で次に取り込む行）
+ALTER TABLE import_jobs
+    ADD COLUMN next_row INT NOT NULL DEFAULT 0 AFTER attempts;
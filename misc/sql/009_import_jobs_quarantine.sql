@@ -0,0 +1,7 @@
+-- 取込に失敗したジョブの再試行と隔離
+-- ファイル全体の取込に失敗したジョブはretry_atまで待って再試行し、failuresがQUARANTINE_AFTER回になると隔離（quarantined）する。
+-- 隔離したジョブは画面から再試行（queued）するか破棄（discarded）する
+ALTER TABLE import_jobs
+    ADD COLUMN failures       INT      NOT NULL DEFAULT 0 AFTER next_row,
+    ADD COLUMN retry_at       DATETIME NULL AFTER last_error,
+    ADD COLUMN quarantined_at DATETIME NULL AFTER finished_at;
@@ -0,0 +1,6 @@
+-- 取込結果の一覧に行ごとの結果の件数を表示するため、ファイルの行数と取り込んだ行数（取込順で何行目まで取り込んだか）を記録する。
+-- 成功した行数は取り込んだ行数からエラーの行数を引いて数える
+ALTER TABLE csv_upload_transaction
+    ADD COLUMN total_lines     INT NULL,
+    ADD COLUMN processed_lines INT NULL,
+    ADD INDEX idx_csv_upload_transaction_created_time (created_time_in_windows);
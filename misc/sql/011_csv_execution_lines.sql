@@ -0,0 +1,16 @@
+-- 行ごとの取込結果
+-- エラー・警告のない行も記録し、ファイルのどの予約を取り込んだか（登録・取消した予約と顧客）を取込結果の詳細で確認できるようにする。
+-- 検証のみ（import_mode: validate）の取込は予約を登録しないため、reservation_id、guest_idは記録しない
+CREATE TABLE IF NOT EXISTS csv_execution_lines (
+    id             INT          NOT NULL AUTO_INCREMENT,
+    csv_id         INT          NOT NULL,
+    line_number    INT          NOT NULL,
+    notice         VARCHAR(32)  NULL,
+    result         VARCHAR(16)  NOT NULL,
+    reservation_id INT          NULL,
+    guest_id       INT          NULL,
+    customer_name  VARCHAR(255) NULL,
+    create_date    DATETIME     NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_csv_execution_lines_line (csv_id, line_number)
+);
@@ -0,0 +1,3 @@
+-- 行を登録するトランザクションで、csv_upload_transactionのジョブの再開位置（next_row）を更新する
+ALTER TABLE import_jobs
+    ADD INDEX idx_import_jobs_csv_id (csv_id);
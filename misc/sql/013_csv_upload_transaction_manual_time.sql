@@ -0,0 +1,14 @@
+-- 画面から登録したファイルの作成日時（created_time_in_windows）に登録日時を記録する。
+-- 監視元（source）を記録する前に画面から登録したファイルは、タイムスタンプ（YYYYMMDDhhmmss）があるためsource = 'manual'にする
+-- （監視で検知したファイルのタイムスタンプは空）
+UPDATE csv_upload_transaction
+SET source = 'manual'
+WHERE source IS NULL
+  AND timestamp REGEXP '^[0-9]{14}$';
+
+-- 記録する前に登録したファイルは、タイムスタンプ（施設のタイムゾーン）を登録日時とする
+UPDATE csv_upload_transaction
+SET created_time_in_windows = STR_TO_DATE(timestamp, '%Y%m%d%H%i%s')
+WHERE source = 'manual'
+  AND (created_time_in_windows IS NULL OR created_time_in_windows < '1000-01-01')
+  AND timestamp REGEXP '^[0-9]{14}$';